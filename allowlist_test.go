@@ -0,0 +1,63 @@
+package main_test
+
+import (
+	"database/sql/driver"
+	"net"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client allowlist", func() {
+	var fake *fakeTrino
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+	})
+
+	remote := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}
+	}
+
+	It("accepts clients from allowed ranges", func() {
+		server := startServer(fake, &config.Config{ClientAllowlist: []string{"10.0.0.0/8", "127.0.0.1"}})
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(server.Allows(remote("10.1.2.3"))).To(BeTrue())
+		Expect(server.Allows(remote("::ffff:10.1.2.3"))).To(BeTrue())
+	})
+
+	It("refuses clients from outside the ranges before the handshake", func() {
+		server := startServer(fake, &config.Config{ClientAllowlist: []string{"10.0.0.0/8"}})
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		Expect(db.Ping()).NotTo(Succeed())
+		Expect(fake.Queries()).To(BeEmpty())
+		Expect(server.Allows(remote("192.168.1.5"))).To(BeFalse())
+	})
+
+	It("accepts every client without an allowlist", func() {
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		Expect(server.Allows(remote("192.168.1.5"))).To(BeTrue())
+	})
+
+	It("rejects invalid ranges", func() {
+		cfg := &config.Config{ClientAllowlist: []string{"10.0.0.0/33"}}
+		_, err := pgtrino.NewServer(pgtrino.NewTrinoDBFromDB(fake.DB(), cfg))
+		Expect(err).To(MatchError(ContainSubstring(`invalid CLIENT_ALLOWLIST range "10.0.0.0/33"`)))
+	})
+})
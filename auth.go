@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"pg2trino/config"
+)
+
+// trinoAuth configures the Trino connection string and the extra headers of
+// Trino requests for the TRINO_AUTH mode. JWT mode sends the token as a
+// bearer token, while Kerberos mode is left to the Trino driver, which
+// requires https.
+func trinoAuth(dsn string, config *config.Config, header http.Header) (string, error) {
+	switch strings.ToLower(config.TrinoAuth) {
+	case "":
+		return dsn, nil
+	case "jwt":
+		if config.TrinoJWT == "" {
+			return "", fmt.Errorf("TRINO_AUTH=jwt requires TRINO_JWT")
+		}
+		header.Set("Authorization", "Bearer "+config.TrinoJWT)
+		return dsn, nil
+	case "kerberos":
+		if config.TrinoKerberosKeytab == "" || config.TrinoKerberosPrincipal == "" || config.TrinoKerberosRealm == "" {
+			return "", fmt.Errorf("TRINO_AUTH=kerberos requires TRINO_KERBEROS_KEYTAB, TRINO_KERBEROS_PRINCIPAL and TRINO_KERBEROS_REALM")
+		}
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", fmt.Errorf("invalid TRINO_DSN: %w", err)
+		}
+		if u.Scheme != "https" {
+			return "", fmt.Errorf("TRINO_AUTH=kerberos requires an https Trino connection")
+		}
+		query := u.Query()
+		query.Set("KerberosEnabled", "true")
+		query.Set("KerberosKeytabPath", config.TrinoKerberosKeytab)
+		query.Set("KerberosPrincipal", config.TrinoKerberosPrincipal)
+		query.Set("KerberosRealm", config.TrinoKerberosRealm)
+		query.Set("KerberosConfigPath", config.TrinoKerberosConfig)
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	default:
+		return "", fmt.Errorf("invalid TRINO_AUTH %q: expected jwt or kerberos", config.TrinoAuth)
+	}
+}
@@ -0,0 +1,79 @@
+package main_test
+
+import (
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Trino authentication", func() {
+	It("sends the JWT as a bearer token in jwt mode", func() {
+		trino := newTrinoHTTP()
+		defer trino.Close()
+		tdb, err := pgtrino.NewTrinoDB(&config.Config{
+			TrinoDSN:          trino.DSN(),
+			TrinoAuth:         "jwt",
+			TrinoJWT:          "eyJhbGciOiJIUzI1NiJ9.e30.token",
+			TrinoExtraHeaders: []string{"X-Team: analytics"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer tdb.DB.Close()
+
+		var value int
+		Expect(tdb.DB.QueryRow("SELECT 1").Scan(&value)).To(Succeed())
+
+		headers := trino.Headers()
+		Expect(headers).NotTo(BeEmpty())
+		for _, header := range headers {
+			Expect(header.Get("Authorization")).To(Equal("Bearer eyJhbGciOiJIUzI1NiJ9.e30.token"))
+			Expect(header.Get("X-Team")).To(Equal("analytics"))
+		}
+	})
+
+	It("sends no credentials by default", func() {
+		trino := newTrinoHTTP()
+		defer trino.Close()
+		tdb, err := pgtrino.NewTrinoDB(&config.Config{TrinoDSN: trino.DSN()})
+		Expect(err).NotTo(HaveOccurred())
+		defer tdb.DB.Close()
+
+		var value int
+		Expect(tdb.DB.QueryRow("SELECT 1").Scan(&value)).To(Succeed())
+		Expect(trino.Headers()[0].Get("Authorization")).To(BeEmpty())
+	})
+
+	It("passes the Kerberos settings to the Trino driver", func() {
+		dsn, err := pgtrino.TrinoAuth("https://user@trino:8443?catalog=hive", &config.Config{
+			TrinoAuth:              "kerberos",
+			TrinoKerberosKeytab:    "/etc/pg2trino.keytab",
+			TrinoKerberosPrincipal: "pg2trino",
+			TrinoKerberosRealm:     "EXAMPLE.COM",
+			TrinoKerberosConfig:    "/etc/krb5.conf",
+		}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dsn).To(Equal("https://user@trino:8443?KerberosConfigPath=%2Fetc%2Fkrb5.conf&KerberosEnabled=true&KerberosKeytabPath=%2Fetc%2Fpg2trino.keytab&KerberosPrincipal=pg2trino&KerberosRealm=EXAMPLE.COM&catalog=hive"))
+	})
+
+	It("rejects incomplete or unknown modes", func() {
+		for auth, message := range map[string]string{
+			"jwt":      "requires TRINO_JWT",
+			"kerberos": "requires TRINO_KERBEROS_KEYTAB",
+			"basic":    "invalid TRINO_AUTH",
+		} {
+			_, err := pgtrino.NewTrinoDB(&config.Config{TrinoHost: "trino", TrinoPort: "8080", TrinoAuth: auth})
+			Expect(err).To(MatchError(ContainSubstring(message)), auth)
+		}
+
+		_, err := pgtrino.NewTrinoDB(&config.Config{
+			TrinoHost:              "trino",
+			TrinoPort:              "8080",
+			TrinoAuth:              "kerberos",
+			TrinoKerberosKeytab:    "/etc/pg2trino.keytab",
+			TrinoKerberosPrincipal: "pg2trino",
+			TrinoKerberosRealm:     "EXAMPLE.COM",
+		})
+		Expect(err).To(MatchError(ContainSubstring("requires an https Trino connection")))
+	})
+})
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// setBacklog reports that the listen backlog cannot be set on this
+// platform.
+func setBacklog(net.Listener, int) error {
+	return errors.New("LISTEN_BACKLOG is not supported on this platform")
+}
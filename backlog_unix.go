@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// setBacklog sets the length of the queue of connections waiting to be
+// accepted on a TCP listener by listening on its socket again, which
+// updates the queue length of a socket already listening.
+func setBacklog(listener net.Listener, backlog int) error {
+	tcp, ok := listener.(*net.TCPListener)
+	if !ok {
+		return nil
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	if err := raw.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	}); err != nil {
+		return err
+	}
+	return listenErr
+}
@@ -0,0 +1,74 @@
+//go:build unix
+
+package main_test
+
+import (
+	"database/sql/driver"
+	"net"
+	"syscall"
+	"time"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TCP tuning", func() {
+	var fake *fakeTrino
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+	})
+
+	// keepAlive returns whether keepalives are enabled on the accepted
+	// client connection of server.
+	keepAlive := func(server *testServer) bool {
+		db := server.Connect("hive")
+		defer db.Close()
+		var one int
+		Expect(db.QueryRow("SELECT 1;").Scan(&one)).To(Succeed())
+
+		conns := server.ClientConns()
+		Expect(conns).To(HaveLen(1))
+		raw, err := conns[0].(*net.TCPConn).SyscallConn()
+		Expect(err).NotTo(HaveOccurred())
+		var (
+			value  int
+			optErr error
+		)
+		Expect(raw.Control(func(fd uintptr) {
+			value, optErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+		})).To(Succeed())
+		Expect(optErr).NotTo(HaveOccurred())
+		return value != 0
+	}
+
+	It("sets the configured keepalive on accepted connections", func() {
+		server := startServer(fake, &config.Config{TCPKeepAlive: 30 * time.Second})
+		defer server.Close()
+		Expect(keepAlive(server)).To(BeTrue())
+	})
+
+	It("disables keepalives for a negative period", func() {
+		server := startServer(fake, &config.Config{TCPKeepAlive: -1})
+		defer server.Close()
+		Expect(keepAlive(server)).To(BeFalse())
+	})
+
+	It("sets the listen backlog of TCP listeners", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		Expect(pgtrino.SetBacklog(listener, 16)).To(Succeed())
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.Close()).To(Succeed())
+	})
+})
@@ -0,0 +1,80 @@
+package main_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"runtime"
+	"testing"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// syntheticTrino returns a TrinoDB whose SELECT * FROM synthetic query
+// returns n rows with a bigint, a varchar and a double column.
+func syntheticTrino(n int) *pgtrino.TrinoDB {
+	fake := newFakeTrino()
+	row := []driver.Value{int64(42), "trino", 3.5}
+	fake.On("SELECT * FROM synthetic", fakeResult{
+		Columns:  []fakeColumn{col("id", "bigint"), col("name", "varchar"), col("score", "double")},
+		RowCount: n,
+		RowFunc:  func(int) []driver.Value { return row },
+	})
+	return pgtrino.NewTrinoDBFromDB(fake.DB(), &config.Config{})
+}
+
+// mallocsPerRow returns the number of heap allocations made per row while
+// reading a synthetic result of n rows.
+func mallocsPerRow(tdb *pgtrino.TrinoDB, n int) float64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	read, err := tdb.Fetch(context.Background(), "SELECT * FROM synthetic")
+	runtime.ReadMemStats(&after)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(read).To(Equal(n))
+	return float64(after.Mallocs-before.Mallocs) / float64(n)
+}
+
+var _ = Describe("Row allocations", func() {
+	It("stay low and constant per row as results grow", func() {
+		small := mallocsPerRow(syntheticTrino(10_000), 10_000)
+		large := mallocsPerRow(syntheticTrino(100_000), 100_000)
+		// The varchar and double values are boxed, everything else is reused
+		// or allocated in chunks.
+		Expect(large).To(BeNumerically("<", 2.5))
+		Expect(large).To(BeNumerically("~", small, 0.5))
+	})
+})
+
+// benchmarkRows is the size of the synthetic result read by BenchmarkFetch.
+const benchmarkRows = 1_000_000
+
+// BenchmarkFetch reads a synthetic result of a million rows, reporting the
+// allocations made per row. Reusing the scan destinations and allocating row
+// values in chunks brought this down from 6 allocations (256 B) to 2
+// allocations (205 B) per row, leaving only the boxed varchar and double
+// values.
+func BenchmarkFetch(b *testing.B) {
+	tdb := syntheticTrino(benchmarkRows)
+	ctx := context.Background()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n, err := tdb.Fetch(ctx, "SELECT * FROM synthetic")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n != benchmarkRows {
+			b.Fatalf("read %d rows, want %d", n, benchmarkRows)
+		}
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.Mallocs-before.Mallocs)/float64(b.N*benchmarkRows), "allocs/row")
+	b.ReportMetric(float64(after.TotalAlloc-before.TotalAlloc)/float64(b.N*benchmarkRows), "B/row")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	trino "github.com/trinodb/trino-go-client/trino"
+)
+
+// errBreakerOpen is returned for queries rejected by the open circuit breaker.
+var errBreakerOpen = psqlerr.WithCode(
+	errors.New("trino is unavailable: circuit breaker is open"),
+	codes.ConnectionException,
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker stops forwarding queries to a failing Trino cluster. It
+// opens after threshold consecutive failures, rejects queries for the
+// cooldown period and then half-opens to let a single probe query through.
+// A successful probe closes the breaker, a failed one opens it again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// newCircuitBreaker returns a circuit breaker, or nil when threshold
+// disables it. A nil breaker allows every query.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a query may be sent to Trino.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return errBreakerOpen
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return errBreakerOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record updates the breaker with the outcome of an allowed query.
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !isTrinoFailure(err) {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// isTrinoFailure reports whether err indicates Trino itself failing, as
+// opposed to a query being rejected or cancelled. The Trino driver reports
+// the queries Trino rejects with the status of the response carrying the
+// error, and transport failures, such as a refused connection, without a
+// status.
+func isTrinoFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, trino.ErrQueryCancelled) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var failed *trino.ErrQueryFailed
+	if errors.As(err, &failed) && failed.StatusCode != 0 && failed.StatusCode < http.StatusInternalServerError {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package main_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	pgtrino "pg2trino"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	trino "github.com/trinodb/trino-go-client/trino"
+)
+
+var _ = Describe("Circuit breaker", func() {
+	var (
+		now     time.Time
+		breaker *pgtrino.CircuitBreaker
+		outage  error
+	)
+
+	BeforeEach(func() {
+		// The Trino driver fails queries to a server that is down with the
+		// error of the refused connection.
+		db, err := sql.Open("trino", "http://user@127.0.0.1:1")
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+		_, outage = db.QueryContext(context.Background(), "SELECT 1")
+		Expect(outage).To(BeAssignableToTypeOf(&trino.ErrQueryFailed{}))
+
+		now = time.Now()
+		breaker = pgtrino.NewCircuitBreaker(2, time.Minute, func() time.Time { return now })
+	})
+
+	fail := func() {
+		Expect(breaker.Allow()).To(Succeed())
+		breaker.Record(outage)
+	}
+
+	It("moves through closed, open, half-open and back to closed", func() {
+		fail()
+		Expect(breaker.Allow()).To(Succeed(), "one failure keeps the breaker closed")
+		breaker.Record(outage)
+		Expect(breaker.Allow()).To(MatchError(pgtrino.ErrBreakerOpen))
+
+		now = now.Add(time.Minute)
+		Expect(breaker.Allow()).To(Succeed(), "the half-open breaker lets a probe through")
+		Expect(breaker.Allow()).To(MatchError(pgtrino.ErrBreakerOpen), "only one probe at a time")
+		breaker.Record(nil)
+
+		Expect(breaker.Allow()).To(Succeed())
+		breaker.Record(nil)
+		fail()
+		Expect(breaker.Allow()).To(Succeed(), "a success resets the failure count")
+	})
+
+	It("opens again when the probe fails", func() {
+		fail()
+		fail()
+		now = now.Add(time.Minute)
+		fail()
+		Expect(breaker.Allow()).To(MatchError(pgtrino.ErrBreakerOpen))
+	})
+
+	It("ignores queries rejected by Trino", func() {
+		rejected := &trino.ErrQueryFailed{StatusCode: http.StatusOK, Reason: errors.New("syntax error")}
+		for i := 0; i < 3; i++ {
+			Expect(breaker.Allow()).To(Succeed())
+			breaker.Record(rejected)
+		}
+		Expect(breaker.Allow()).To(Succeed())
+	})
+
+	It("is disabled without a threshold", func() {
+		Expect(pgtrino.NewCircuitBreaker(0, time.Minute, time.Now)).To(BeNil())
+	})
+})
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// castTypes maps Postgres type names, and the aliases Postgres accepts, to
+// the Trino type of the same values.
+var castTypes = map[string]string{
+	"int2":                        "smallint",
+	"int4":                        "integer",
+	"int":                         "integer",
+	"int8":                        "bigint",
+	"float4":                      "real",
+	"float8":                      "double",
+	"double precision":            "double",
+	"bool":                        "boolean",
+	"text":                        "varchar",
+	"character varying":           "varchar",
+	"character":                   "char",
+	"bpchar":                      "char",
+	"numeric":                     "decimal",
+	"timestamptz":                 "timestamp with time zone",
+	"timestamp without time zone": "timestamp",
+	"timetz":                      "time with time zone",
+	"time without time zone":      "time",
+	"bytea":                       "varbinary",
+	"jsonb":                       "json",
+}
+
+var (
+	// castTypeName matches a type name following :: or the AS of a CAST,
+	// with its optional type modifiers and array brackets.
+	castTypeName = regexp.MustCompile(`(?i)^\s*((?:double\s+precision|character\s+varying|(?:timestamp|time)\s+with(?:out)?\s+time\s+zone|"(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_]*)(?:\.[A-Za-z_][A-Za-z0-9_]*)?)(\s*\([^)]*\))?((?:\s*\[\d*\])*)`)
+	// castCall matches the start of a CAST or TRY_CAST call.
+	castCall = regexp.MustCompile(`(?i)\b(?:TRY_)?CAST\s*\(`)
+	// castAs matches the AS keyword of a CAST call.
+	castAs = regexp.MustCompile(`(?i)^AS\b`)
+)
+
+// rewriteCasts translates the Postgres expr::type cast into CAST(expr AS
+// type), and the Postgres type names of casts into their Trino equivalent,
+// so that x::int4 becomes CAST(x AS integer) and CAST(x AS bool) becomes
+// CAST(x AS boolean). The operand of :: is the literal, identifier, number,
+// parenthesized expression, function call or array constructor preceding
+// it.
+func rewriteCasts(query string) string {
+	for from := 0; ; {
+		at := castOperator(query, from)
+		if at < 0 {
+			break
+		}
+		start := operandStart(query, at)
+		typ := castTypeName.FindStringIndex(query[at+2:])
+		if start == at || typ == nil {
+			from = at + 2
+			continue
+		}
+		end := at + 2 + typ[1]
+		cast := "CAST(" + strings.TrimSpace(query[start:at]) + " AS " + trinoCastType(query[at+2:end]) + ")"
+		query = query[:start] + cast + query[end:]
+		from = start
+	}
+	return normalizeCastTypes(query)
+}
+
+// normalizeCastTypes translates the Postgres type names of the CAST and
+// TRY_CAST calls of query. Calls are rewritten from last to first, so the
+// offsets of the calls before them stay valid.
+func normalizeCastTypes(query string) string {
+	literals := literalRanges(query)
+	calls := castCall.FindAllStringIndex(query, -1)
+	for i := len(calls) - 1; i >= 0; i-- {
+		start := calls[i][1]
+		if insideRanges(literals, calls[i][0]) {
+			continue
+		}
+		end := topLevelIndex(query[start:], closingParen)
+		if end < 0 {
+			continue
+		}
+		end += start
+		as := topLevelIndex(query[start:end], castAs)
+		if as < 0 {
+			continue
+		}
+		as += start + len("AS")
+		typ := castTypeName.FindStringIndex(query[as:end])
+		if typ == nil || strings.TrimSpace(query[as+typ[1]:end]) != "" {
+			continue
+		}
+		query = query[:as] + " " + trinoCastType(query[as:end]) + query[end:]
+	}
+	return query
+}
+
+// trinoCastType translates a Postgres type name with its type modifiers
+// and array brackets into the Trino type. Unknown names are kept.
+func trinoCastType(typ string) string {
+	match := castTypeName.FindStringSubmatch(typ)
+	name := strings.Join(strings.Fields(match[1]), " ")
+	if trino, ok := castTypes[strings.ToLower(name)]; ok {
+		name = trino
+	}
+	name += strings.ReplaceAll(match[2], " ", "")
+	for i := strings.Count(match[3], "["); i > 0; i-- {
+		name = "array(" + name + ")"
+	}
+	return name
+}
+
+// castOperator returns the offset of the first :: of query from offset
+// from on outside of string literals and quoted identifiers, or -1.
+func castOperator(query string, from int) int {
+	var quote byte
+	for i := 0; i+1 < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ':' && query[i+1] == ':' && i >= from:
+			return i
+		}
+	}
+	return -1
+}
+
+// operandStart returns the offset of the operand of the :: at offset at,
+// which is at itself if there is none.
+func operandStart(query string, at int) int {
+	i := at
+	for i > 0 && query[i-1] == ' ' {
+		i--
+	}
+	if i == 0 {
+		return at
+	}
+	switch query[i-1] {
+	case '\'':
+		for _, r := range literalRanges(query) {
+			if r[1] == i {
+				return r[0]
+			}
+		}
+		return at
+	case '"':
+		start := strings.LastIndexByte(query[:i-1], '"')
+		if start < 0 {
+			return at
+		}
+		i = start
+	case ')', ']':
+		i = openingBracket(query, i-1)
+		if i < 0 {
+			return at
+		}
+	}
+	for i > 0 && isOperandByte(query[i-1]) {
+		i--
+	}
+	if i == at {
+		return at
+	}
+	return i
+}
+
+// isOperandByte reports whether c belongs to an identifier, a number, a
+// qualified name or a $n parameter.
+func isOperandByte(c byte) bool {
+	return c == '$' || c == '.' || isIdentifierByte(c)
+}
+
+// openingBracket returns the offset of the parenthesis or square bracket
+// opening the one at close, skipping string literals and quoted
+// identifiers, or -1.
+func openingBracket(query string, close int) int {
+	open := byte('(')
+	if query[close] == ']' {
+		open = '['
+	}
+	depth := 0
+	var quote byte
+	for i := close; i >= 0; i-- {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == query[close]:
+			depth++
+		case c == open:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/lib/pq/oid"
+)
+
+var (
+	// pgTypeStatement matches a SELECT from pg_type with an optional table
+	// alias, WHERE and ORDER BY clause.
+	pgTypeStatement = regexp.MustCompile(`(?is)^\s*SELECT\s+(.+?)\s+FROM\s+(?:pg_catalog\.)?pg_type(?:\s+(?:AS\s+)?([a-z_][a-z0-9_]*))?(?:\s+WHERE\s+(.+?))?(?:\s+ORDER\s+BY\s+(.+?))?\s*$`)
+	// catalogColumn matches a column reference, optionally qualified by the
+	// table alias.
+	catalogColumn = regexp.MustCompile(`(?i)^(?:([a-z_][a-z0-9_]*)\.)?([a-z_][a-z0-9_]*)$`)
+	// catalogCondition matches a column = value or column IN (values)
+	// condition of a WHERE clause.
+	catalogCondition = regexp.MustCompile(`(?is)^(\S+?)\s*(?:=\s*(.+)|\s+IN\s*\((.+)\))$`)
+	// catalogAnd matches the AND keyword joining WHERE conditions.
+	catalogAnd = regexp.MustCompile(`(?i)\s+AND\s+`)
+	// catalogCast matches a cast of a literal, as in 'int4'::regtype.
+	catalogCast = regexp.MustCompile(`(?i)\s*::\s*([a-z_][a-z0-9_]*)$`)
+	// catalogOrder matches an ORDER BY item.
+	catalogOrder = regexp.MustCompile(`(?is)^(\S+?)(?:\s+(ASC|DESC))?$`)
+)
+
+// pgType is a row of the pg_type catalog.
+type pgType struct {
+	oid      oid.Oid
+	name     string
+	length   int16
+	typtype  byte
+	category byte
+	elem     oid.Oid
+	array    oid.Oid
+}
+
+// pgTypes are the pg_type rows of the types the proxy sends to clients and
+// of their array types.
+var pgTypes = []pgType{
+	{oid.T_bool, "bool", 1, 'b', 'B', 0, oid.T__bool},
+	{oid.T_name, "name", 64, 'b', 'S', oid.T_char, oid.T__name},
+	{oid.T_int8, "int8", 8, 'b', 'N', 0, oid.T__int8},
+	{oid.T_int2, "int2", 2, 'b', 'N', 0, oid.T__int2},
+	{oid.T_int4, "int4", 4, 'b', 'N', 0, oid.T__int4},
+	{oid.T_text, "text", -1, 'b', 'S', 0, oid.T__text},
+	{oid.T_json, "json", -1, 'b', 'U', 0, oid.T__json},
+	{oid.T_float8, "float8", 8, 'b', 'N', 0, oid.T__float8},
+	{oid.T_time, "time", 8, 'b', 'D', 0, oid.T__time},
+	{oid.T_timestamp, "timestamp", 8, 'b', 'D', 0, oid.T__timestamp},
+	{oid.T_timestamptz, "timestamptz", 8, 'b', 'D', 0, oid.T__timestamptz},
+	{oid.T_numeric, "numeric", -1, 'b', 'N', 0, oid.T__numeric},
+	{oid.T_regtype, "regtype", 4, 'b', 'N', 0, oid.T__regtype},
+	{oid.T_record, "record", -1, 'p', 'P', 0, oid.T__record},
+	{oid.T_void, "void", 4, 'p', 'P', 0, 0},
+	{oid.T__bool, "_bool", -1, 'b', 'A', oid.T_bool, 0},
+	{oid.T__name, "_name", -1, 'b', 'A', oid.T_name, 0},
+	{oid.T__int8, "_int8", -1, 'b', 'A', oid.T_int8, 0},
+	{oid.T__int2, "_int2", -1, 'b', 'A', oid.T_int2, 0},
+	{oid.T__int4, "_int4", -1, 'b', 'A', oid.T_int4, 0},
+	{oid.T__text, "_text", -1, 'b', 'A', oid.T_text, 0},
+	{oid.T__json, "_json", -1, 'b', 'A', oid.T_json, 0},
+	{oid.T__float8, "_float8", -1, 'b', 'A', oid.T_float8, 0},
+	{oid.T__time, "_time", -1, 'b', 'A', oid.T_time, 0},
+	{oid.T__timestamp, "_timestamp", -1, 'b', 'A', oid.T_timestamp, 0},
+	{oid.T__timestamptz, "_timestamptz", -1, 'b', 'A', oid.T_timestamptz, 0},
+	{oid.T__numeric, "_numeric", -1, 'b', 'A', oid.T_numeric, 0},
+	{oid.T__regtype, "_regtype", -1, 'b', 'A', oid.T_regtype, 0},
+	{oid.T__record, "_record", -1, 'p', 'P', oid.T_record, 0},
+}
+
+// pgCatalogNamespace is the oid of the pg_catalog schema.
+const pgCatalogNamespace = 11
+
+// pgTypeColumn is a column of the pg_type catalog.
+type pgTypeColumn struct {
+	typ   oid.Oid
+	value func(t pgType) any
+}
+
+// pgTypeColumns are the pg_type columns answered locally, in their catalog
+// order.
+var pgTypeColumns = []string{
+	"oid", "typname", "typnamespace", "typlen", "typbyval", "typtype", "typcategory",
+	"typdelim", "typrelid", "typelem", "typarray", "typnotnull", "typbasetype", "typtypmod", "typndims",
+}
+
+// pgTypeColumnValues maps the pg_type columns to their type and value.
+var pgTypeColumnValues = map[string]pgTypeColumn{
+	"oid":          {oid.T_oid, func(t pgType) any { return uint32(t.oid) }},
+	"typname":      {oid.T_name, func(t pgType) any { return t.name }},
+	"typnamespace": {oid.T_oid, func(pgType) any { return uint32(pgCatalogNamespace) }},
+	"typlen":       {oid.T_int2, func(t pgType) any { return int64(t.length) }},
+	"typbyval":     {oid.T_bool, func(t pgType) any { return t.length > 0 && t.length <= 8 }},
+	"typtype":      {oid.T_char, func(t pgType) any { return t.typtype }},
+	"typcategory":  {oid.T_char, func(t pgType) any { return t.category }},
+	"typdelim":     {oid.T_char, func(pgType) any { return byte(',') }},
+	"typrelid":     {oid.T_oid, func(pgType) any { return uint32(0) }},
+	"typelem":      {oid.T_oid, func(t pgType) any { return uint32(t.elem) }},
+	"typarray":     {oid.T_oid, func(t pgType) any { return uint32(t.array) }},
+	"typnotnull":   {oid.T_bool, func(pgType) any { return false }},
+	"typbasetype":  {oid.T_oid, func(pgType) any { return uint32(0) }},
+	"typtypmod":    {oid.T_int4, func(pgType) any { return int64(-1) }},
+	"typndims":     {oid.T_int4, func(pgType) any { return int64(0) }},
+}
+
+// regtypeOid returns the oid of the type named name, which is either its
+// pg_type name or its name as reported by pg_typeof, or zero if there is no
+// such type.
+func regtypeOid(name string) uint32 {
+	name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "pg_catalog."))
+	for _, t := range pgTypes {
+		if t.name == name || pgTypeNames[t.oid] == name {
+			return uint32(t.oid)
+		}
+	}
+	return 0
+}
+
+// catalogLess reports whether the catalog value a sorts before b.
+func catalogLess(a, b any) bool {
+	switch a := a.(type) {
+	case uint32:
+		return a < b.(uint32)
+	case int64:
+		return a < b.(int64)
+	case bool:
+		return !a && b.(bool)
+	default:
+		return catalogText(a) < catalogText(b)
+	}
+}
+
+// catalogText returns the text form of the catalog value v. Values of the
+// Postgres "char" type are single bytes.
+func catalogText(v any) string {
+	if b, ok := v.(byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// pgTypeQuery answers the pg_type lookups drivers such as pgx run to
+// resolve type OIDs, which Trino has no catalog for, from a static pg_type
+// of the types the proxy sends. It handles a SELECT of pg_type columns with
+// WHERE conditions of the form column = value or column IN (values) joined
+// by AND, and an ORDER BY of pg_type columns. It returns false for any
+// other query, which is left to Trino. The values of the conditions may be
+// parameters such as $1, as in the lookups of clients using prepared
+// statements.
+func pgTypeQuery(query string) (wire.PreparedStatements, bool) {
+	match := pgTypeStatement.FindStringSubmatch(query)
+	if match == nil {
+		return nil, false
+	}
+	alias := match[2]
+	column := func(ref string) (string, bool) {
+		parts := catalogColumn.FindStringSubmatch(strings.TrimSpace(ref))
+		if parts == nil || parts[1] != "" && !strings.EqualFold(parts[1], alias) && !strings.EqualFold(parts[1], "pg_type") {
+			return "", false
+		}
+		name := strings.ToLower(parts[2])
+		_, ok := pgTypeColumnValues[name]
+		return name, ok
+	}
+
+	var names, selected []string
+	for _, item := range splitList(match[1]) {
+		if item == "*" {
+			names = append(names, pgTypeColumns...)
+			selected = append(selected, pgTypeColumns...)
+			continue
+		}
+		name := ""
+		if expr, as, ok := splitAlias(item); ok {
+			item, name = expr, as
+		}
+		col, ok := column(item)
+		if !ok {
+			return nil, false
+		}
+		if name == "" {
+			name = col
+		}
+		names = append(names, name)
+		selected = append(selected, col)
+	}
+
+	var (
+		filters []pgTypeFilter
+		params  []oid.Oid
+	)
+	if match[3] != "" {
+		for _, condition := range catalogAnd.Split(match[3], -1) {
+			parts := catalogCondition.FindStringSubmatch(strings.TrimSpace(condition))
+			if parts == nil {
+				return nil, false
+			}
+			col, ok := column(parts[1])
+			if !ok {
+				return nil, false
+			}
+			list := []string{parts[2]}
+			if parts[2] == "" {
+				list = splitList(parts[3])
+			}
+			filter := pgTypeFilter{column: col}
+			for _, value := range list {
+				value = strings.TrimSpace(value)
+				cast := catalogCast.FindStringSubmatchIndex(value)
+				regtype := cast != nil && strings.EqualFold(value[cast[2]:cast[3]], "regtype")
+				if cast != nil {
+					value = value[:cast[0]]
+				}
+				if match := placeholder.FindStringSubmatch(value); match != nil && match[0] == value {
+					n, _ := strconv.Atoi(match[1])
+					for len(params) < n {
+						params = append(params, oid.T_text)
+					}
+				} else if !stringLiteral.MatchString(value) && !integerLiteral.MatchString(value) {
+					return nil, false
+				}
+				filter.values = append(filter.values, pgTypeValue{value, regtype})
+			}
+			filters = append(filters, filter)
+		}
+	}
+
+	type order struct {
+		column string
+		desc   bool
+	}
+	var orders []order
+	if match[4] != "" {
+		for _, item := range splitList(match[4]) {
+			parts := catalogOrder.FindStringSubmatch(item)
+			if parts == nil {
+				return nil, false
+			}
+			col, ok := column(parts[1])
+			if !ok {
+				return nil, false
+			}
+			orders = append(orders, order{col, strings.EqualFold(parts[2], "DESC")})
+		}
+	}
+
+	columns := make(wire.Columns, len(names))
+	for i, name := range names {
+		columns[i] = wire.Column{Name: name, Oid: pgTypeColumnValues[selected[i]].typ, TypeModifier: -1}
+	}
+	handle := func(_ context.Context, writer wire.DataWriter, values []wire.Parameter) error {
+		types := append([]pgType(nil), pgTypes...)
+		for _, filter := range filters {
+			types = filter.apply(types, values)
+		}
+		for i := len(orders) - 1; i >= 0; i-- {
+			desc := orders[i].desc
+			value := pgTypeColumnValues[orders[i].column].value
+			sort.SliceStable(types, func(a, b int) bool {
+				if desc {
+					return catalogLess(value(types[b]), value(types[a]))
+				}
+				return catalogLess(value(types[a]), value(types[b]))
+			})
+		}
+		for _, t := range types {
+			row := make([]any, len(selected))
+			for j, col := range selected {
+				row[j] = pgTypeColumnValues[col].value(t)
+			}
+			if err := writer.Row(row); err != nil {
+				return err
+			}
+		}
+		return writer.Complete(fmt.Sprintf("SELECT %d", len(types)))
+	}
+	return wire.Prepared(wire.NewStatement(handle,
+		wire.WithParameters(params),
+		wire.WithColumns(columns),
+	)), true
+}
+
+// pgTypeFilter is a column = value or column IN (values) condition of a
+// pg_type lookup.
+type pgTypeFilter struct {
+	column string
+	values []pgTypeValue
+}
+
+// pgTypeValue is a value of a pg_type lookup condition: a literal or a
+// parameter such as $1, which is cast to regtype when regtype is set.
+type pgTypeValue struct {
+	text    string
+	regtype bool
+}
+
+// apply returns the types matching the filter, given the values of the
+// parameters of the lookup.
+func (f pgTypeFilter) apply(types []pgType, params []wire.Parameter) []pgType {
+	values := map[string]bool{}
+	for _, v := range f.values {
+		value := v.text
+		if match := placeholder.FindStringSubmatch(value); match != nil {
+			n, _ := strconv.Atoi(match[1])
+			value = ""
+			if n >= 1 && n <= len(params) {
+				value = string(params[n-1].Value())
+			}
+		} else if stringLiteral.MatchString(value) {
+			value = unquote(value)
+		}
+		if v.regtype && !integerLiteral.MatchString(value) {
+			value = fmt.Sprint(regtypeOid(value))
+		}
+		values[value] = true
+	}
+	var filtered []pgType
+	for _, t := range types {
+		if values[catalogText(pgTypeColumnValues[f.column].value(t))] {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
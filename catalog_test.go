@@ -0,0 +1,250 @@
+package main_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+
+	"pg2trino/config"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("pg_type lookups", func() {
+	var (
+		fake   *fakeTrino
+		server *testServer
+		db     *sql.DB
+	)
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		server = startServer(fake, &config.Config{})
+		db = server.Connect("hive")
+	})
+
+	AfterEach(func() {
+		Expect(db.Close()).To(Succeed())
+		server.Close()
+	})
+
+	// types returns the oid and typname rows of a pg_type query.
+	types := func(query string) map[uint32]string {
+		rows, err := db.Query(query)
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		names := map[uint32]string{}
+		for rows.Next() {
+			var (
+				oid  uint32
+				name string
+			)
+			Expect(rows.Scan(&oid, &name)).To(Succeed())
+			names[oid] = name
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		return names
+	}
+
+	It("resolves the OIDs of the columns of a typed query locally", func() {
+		fake.On("SELECT id, price, created_at FROM orders", fakeResult{
+			Columns: []fakeColumn{col("id", "bigint"), col("price", "decimal(10,2)"), col("created_at", "timestamp")},
+		})
+		rows, err := db.Query("SELECT id, price, created_at FROM orders;")
+		Expect(err).NotTo(HaveOccurred())
+		columns, err := rows.ColumnTypes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rows.Close()).To(Succeed())
+
+		names := types("SELECT oid, typname FROM pg_type WHERE oid IN (20, 1700, 1114);")
+		Expect(names).To(Equal(map[uint32]string{20: "int8", 1700: "numeric", 1114: "timestamp"}))
+		for _, column := range columns {
+			Expect(names).To(ContainElement(Equal(strings.ToLower(column.DatabaseTypeName()))))
+		}
+		Expect(fake.Queries()).To(HaveLen(1))
+	})
+
+	It("answers the parameterized lookups of a client resolving the types of a typed query", func() {
+		fake.On("SELECT id, price, created_at FROM orders", fakeResult{
+			Columns: []fakeColumn{col("id", "bigint"), col("price", "decimal(10,2)"), col("created_at", "timestamp")},
+		})
+		rows, err := db.Query("SELECT id, price, created_at FROM orders;")
+		Expect(err).NotTo(HaveOccurred())
+		columns, err := rows.ColumnTypes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rows.Close()).To(Succeed())
+
+		lookup, err := db.Prepare("SELECT oid, typlen FROM pg_type WHERE typname = $1 AND typtype = 'b';")
+		Expect(err).NotTo(HaveOccurred())
+		defer lookup.Close()
+		var lengths []int
+		for _, column := range columns {
+			var oid, length int
+			Expect(lookup.QueryRow(strings.ToLower(column.DatabaseTypeName())).Scan(&oid, &length)).To(Succeed(), column.Name())
+			lengths = append(lengths, length)
+		}
+		Expect(lengths).To(Equal([]int{8, -1, 8}))
+
+		var name string
+		Expect(db.QueryRow("SELECT typname FROM pg_type WHERE oid = $1::regtype;", "integer").Scan(&name)).To(Succeed())
+		Expect(name).To(Equal("int4"))
+		Expect(fake.Queries()).To(HaveLen(1))
+	})
+
+	It("filters by name, regtype casts and alias-qualified columns", func() {
+		Expect(types("SELECT t.oid, t.typname FROM pg_catalog.pg_type AS t WHERE t.typname = 'text';")).
+			To(Equal(map[uint32]string{25: "text"}))
+		Expect(types("select oid, typname from pg_type t where t.oid = 'integer'::regtype;")).
+			To(Equal(map[uint32]string{23: "int4"}))
+		Expect(types("SELECT typarray, typname FROM pg_type WHERE typname = 'bool' AND typtype = 'b';")).
+			To(Equal(map[uint32]string{1000: "bool"}))
+		Expect(types("SELECT oid, typname FROM pg_type WHERE oid = 600;")).To(BeEmpty())
+		Expect(fake.Queries()).To(BeEmpty())
+	})
+
+	It("returns every column in order for SELECT *", func() {
+		rows, err := db.Query("SELECT * FROM pg_type WHERE typelem = 23 ORDER BY oid DESC;")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		columns, err := rows.Columns()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(columns[:3]).To(Equal([]string{"oid", "typname", "typnamespace"}))
+
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		Expect(rows.Next()).To(BeTrue())
+		Expect(rows.Scan(pointers...)).To(Succeed())
+		Expect(values[0]).To(BeEquivalentTo("1007"))
+		Expect(values[1]).To(BeEquivalentTo("_int4"))
+		Expect(rows.Next()).To(BeFalse())
+	})
+
+	It("sends the \"char\" columns as single bytes", func() {
+		conn, err := pgx.Connect(context.Background(), server.DSN("hive"))
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(context.Background())
+
+		var typtype, category, delim byte
+		Expect(conn.QueryRow(context.Background(), "SELECT typtype, typcategory, typdelim FROM pg_type WHERE typname = $1;", "int4").
+			Scan(&typtype, &category, &delim)).To(Succeed())
+		Expect(string([]byte{typtype, category, delim})).To(Equal("bN,"))
+
+		rows, err := db.Query("SELECT typname, typtype FROM pg_type WHERE typcategory = 'P' ORDER BY typtype, typname;")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name, typtype string
+			Expect(rows.Scan(&name, &typtype)).To(Succeed())
+			Expect(typtype).To(Equal("p"))
+			names = append(names, name)
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		Expect(names).To(ContainElements("record", "void"))
+	})
+
+	It("leaves other catalog queries to Trino", func() {
+		query := "SELECT t.oid FROM pg_type t JOIN pg_namespace n ON t.typnamespace = n.oid"
+		fake.On(query, fakeResult{
+			Columns: []fakeColumn{col("oid", "bigint")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		var oid int64
+		Expect(db.QueryRow(query + ";").Scan(&oid)).To(Succeed())
+		Expect(fake.LastQuery().Query).To(Equal(query))
+	})
+})
+
+var _ = Describe("pg_database lookups", func() {
+	// listDatabases is the query psql runs for \l.
+	const listDatabases = `SELECT
+  d.datname as "Name",
+  pg_catalog.pg_get_userbyid(d.datdba) as "Owner",
+  pg_catalog.pg_encoding_to_char(d.encoding) as "Encoding",
+  CASE d.datlocprovider WHEN 'c' THEN 'libc' WHEN 'i' THEN 'icu' END AS "Locale Provider",
+  d.datcollate as "Collate",
+  d.datctype as "Ctype",
+  d.daticulocale as "ICU Locale",
+  d.daticurules as "ICU Rules",
+  pg_catalog.array_to_string(d.datacl, E'\n') AS "Access privileges"
+FROM pg_catalog.pg_database d
+`
+
+	var (
+		fake   *fakeTrino
+		server *testServer
+		db     *sql.DB
+	)
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		fake.On("SHOW CATALOGS", fakeResult{
+			Columns: []fakeColumn{col("Catalog", "varchar")},
+			Rows:    [][]driver.Value{{"system"}, {"hive"}, {"iceberg"}},
+		})
+		server = startServer(fake, &config.Config{})
+		db = server.Connect("hive")
+	})
+
+	AfterEach(func() {
+		Expect(db.Close()).To(Succeed())
+		server.Close()
+	})
+
+	It("lists the Trino catalogs as databases for \\l", func() {
+		rows, err := db.Query(listDatabases + "ORDER BY 1;")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		columns, err := rows.Columns()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(columns).To(Equal([]string{"Name", "Owner", "Encoding", "Locale Provider", "Collate", "Ctype", "ICU Locale", "ICU Rules", "Access privileges"}))
+
+		var names []string
+		for rows.Next() {
+			var (
+				name, owner, encoding, provider, collate, ctype string
+				icuLocale, icuRules, acl                        sql.NullString
+			)
+			Expect(rows.Scan(&name, &owner, &encoding, &provider, &collate, &ctype, &icuLocale, &icuRules, &acl)).To(Succeed())
+			Expect([]string{owner, encoding, provider, collate, ctype}).To(Equal([]string{"user", "UTF8", "libc", "C", "C"}))
+			Expect(acl.Valid).To(BeFalse())
+			names = append(names, name)
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		Expect(names).To(Equal([]string{"hive", "iceberg", "system"}))
+		Expect(fake.LastQuery().Query).To(Equal("SHOW CATALOGS"))
+	})
+
+	It("sends datlocprovider as a single byte", func() {
+		conn, err := pgx.Connect(context.Background(), server.DSN("hive"))
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(context.Background())
+
+		var provider byte
+		Expect(conn.QueryRow(context.Background(), "SELECT datlocprovider FROM pg_database WHERE datname = 'hive';").Scan(&provider)).To(Succeed())
+		Expect(provider).To(Equal(byte('c')))
+	})
+
+	It("filters by the pattern of \\l and by name", func() {
+		var name string
+		Expect(db.QueryRow(listDatabases+"WHERE d.datname OPERATOR(pg_catalog.~) '^(ice.*)$' COLLATE pg_catalog.default\nORDER BY 1;").
+			Scan(&name, new(any), new(any), new(any), new(any), new(any), new(any), new(any), new(any))).To(Succeed())
+		Expect(name).To(Equal("iceberg"))
+
+		rows, err := db.Query("SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate AND datname = 'hive';")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			Expect(rows.Scan(&name)).To(Succeed())
+			names = append(names, name)
+		}
+		Expect(names).To(Equal([]string{"hive"}))
+	})
+})
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"log"
+)
+
+// resultChecksum accumulates the row count and a checksum of the values
+// sent for a query, so results can be compared when diagnosing data
+// corruption reports. A nil checksum ignores the rows.
+type resultChecksum struct {
+	rows int
+	hash hash.Hash64
+}
+
+// newResultChecksum returns a checksum of the rows sent for a query, or nil
+// when checksums are disabled.
+func newResultChecksum(enabled bool) *resultChecksum {
+	if !enabled {
+		return nil
+	}
+	return &resultChecksum{hash: fnv.New64a()}
+}
+
+// add adds a row sent to the client to the checksum. NULL values and the
+// boundaries of values and rows are marked, so moving a value to another
+// column or row changes the checksum.
+func (c *resultChecksum) add(row []any) {
+	if c == nil {
+		return
+	}
+	c.rows++
+	for _, v := range row {
+		if v == nil {
+			_, _ = c.hash.Write([]byte{0})
+			continue
+		}
+		_, _ = fmt.Fprintf(c.hash, "\x01%v\x1f", v)
+	}
+	_, _ = c.hash.Write([]byte{'\x1e'})
+}
+
+// Sum returns the checksum of the rows added so far.
+func (c *resultChecksum) Sum() uint64 {
+	return c.hash.Sum64()
+}
+
+// log logs the row count and checksum of the result of query.
+func (c *resultChecksum) log(query string) {
+	if c == nil {
+		return
+	}
+	log.Printf("Result checksum: rows=%d checksum=%016x query=%q", c.rows, c.Sum(), query)
+}
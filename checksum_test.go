@@ -0,0 +1,61 @@
+package main_test
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"log"
+	"os"
+	"regexp"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Result checksums", func() {
+	rows := func() [][]any {
+		return [][]any{{int64(1), "a", nil}, {int64(2), "", true}}
+	}
+
+	It("is stable for identical results", func() {
+		Expect(pgtrino.ResultChecksum(rows())).To(Equal(pgtrino.ResultChecksum(rows())))
+	})
+
+	It("changes when values change or move", func() {
+		sum := pgtrino.ResultChecksum(rows())
+		Expect(pgtrino.ResultChecksum([][]any{{int64(1), "a", nil}, {int64(2), nil, true}})).NotTo(Equal(sum))
+		Expect(pgtrino.ResultChecksum([][]any{{int64(1), "a"}, {nil, int64(2), "", true}})).NotTo(Equal(sum))
+		Expect(pgtrino.ResultChecksum([][]any{{int64(2), "", true}, {int64(1), "a", nil}})).NotTo(Equal(sum))
+	})
+
+	It("logs the row count and checksum of every query in debug mode", func() {
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		defer log.SetOutput(os.Stderr)
+
+		fake := newFakeTrino()
+		fake.On("SELECT id, name FROM users", fakeResult{
+			Columns: []fakeColumn{col("id", "bigint"), col("name", "varchar")},
+			Rows:    [][]driver.Value{{int64(1), "ada"}, {int64(2), nil}},
+		})
+		server := startServer(fake, &config.Config{DebugChecksums: true})
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		for i := 0; i < 2; i++ {
+			rows, err := db.Query("SELECT id, name FROM users;")
+			Expect(err).NotTo(HaveOccurred())
+			for rows.Next() {
+			}
+			Expect(rows.Close()).To(Succeed())
+		}
+
+		checksums := regexp.MustCompile(`Result checksum: rows=(\d+) checksum=([0-9a-f]{16})`).FindAllStringSubmatch(logs.String(), -1)
+		Expect(checksums).To(HaveLen(2))
+		Expect(checksums[0][1]).To(Equal("2"))
+		Expect(checksums[1]).To(Equal(checksums[0]))
+	})
+})
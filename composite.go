@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq/oid"
+)
+
+// trinoType is the structure of a nested Trino type: an array with its
+// element type, a row with its field types, a map, or a scalar.
+type trinoType struct {
+	kind string
+	args []trinoType
+}
+
+// nestedField matches a type argument that is a nested type itself,
+// optionally preceded by a row field name.
+var nestedField = regexp.MustCompile(`(?i)^(?:"(?:[^"]|"")*"\s+|[^\s(]+\s+)?(ARRAY|ROW|MAP)\(`)
+
+// parseTrinoType parses a type name as reported by DatabaseTypeName, e.g.
+// ARRAY(ROW(X INTEGER, Y VARCHAR)). Only arrays, rows and maps are
+// distinguished, every other type is a scalar.
+func parseTrinoType(typeName string) trinoType {
+	typeName = strings.TrimSpace(typeName)
+	match := nestedField.FindStringSubmatchIndex(typeName)
+	if match == nil || !strings.HasSuffix(typeName, ")") {
+		return trinoType{kind: "scalar"}
+	}
+	kind := typeName[match[2]:match[3]]
+	t := trinoType{kind: strings.ToLower(kind)}
+	for _, arg := range splitList(typeName[match[1] : len(typeName)-1]) {
+		t.args = append(t.args, parseTrinoType(arg))
+	}
+	return t
+}
+
+// compositeExtractor returns the extractor of nested Trino types, which the
+// driver scans into interface{} values. Rows are sent as Postgres records
+// and arrays of rows as arrays of records, using the Postgres text syntax
+// at every level.
+func compositeExtractor(typeName string) typeExtractor {
+	t := parseTrinoType(typeName)
+	typ := oid.T_text
+	switch {
+	case t.kind == "row":
+		typ = oid.T_record
+	case t.kind == "array" && len(t.args) == 1 && t.args[0].kind == "row":
+		typ = oid.T__record
+	}
+	return extractorFor(typ, func(v any) any {
+		return formatNested(v, t)
+	})
+}
+
+// formatNested renders a value decoded by the Trino driver in the Postgres
+// text syntax of its type.
+func formatNested(v any, t trinoType) string {
+	values, isSlice := v.([]any)
+	switch {
+	case t.kind == "array" && isSlice:
+		var elem trinoType
+		if len(t.args) > 0 {
+			elem = t.args[0]
+		}
+		items := make([]string, len(values))
+		for i, value := range values {
+			switch {
+			case value == nil:
+				items[i] = "NULL"
+			case elem.kind == "array":
+				items[i] = formatNested(value, elem)
+			default:
+				items[i] = quoteArrayElement(formatNested(value, elem))
+			}
+		}
+		return "{" + strings.Join(items, ",") + "}"
+	case t.kind == "row" && isSlice:
+		fields := make([]string, len(values))
+		for i, value := range values {
+			if value == nil {
+				continue
+			}
+			var field trinoType
+			if i < len(t.args) {
+				field = t.args[i]
+			}
+			fields[i] = quoteRecordField(formatNested(value, field))
+		}
+		return "(" + strings.Join(fields, ",") + ")"
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		if v {
+			return "t"
+		}
+		return "f"
+	case map[string]any:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(b)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// quoteArrayElement quotes an array element the way Postgres array output
+// does: elements that are empty, NULL or contain special characters are
+// double-quoted with backslash escapes.
+func quoteArrayElement(s string) string {
+	if s != "" && !strings.EqualFold(s, "NULL") && !strings.ContainsAny(s, "{},\"\\ \t\n\r\v\f") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// quoteRecordField quotes a record field the way Postgres record output
+// does: fields that are empty or contain special characters are
+// double-quoted with doubled quotes and backslashes.
+func quoteRecordField(s string) string {
+	if s != "" && !strings.ContainsAny(s, "(),\"\\ \t\n\r\v\f") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `""`).Replace(s) + `"`
+}
@@ -1,23 +1,254 @@
 package config
 
-import "os"
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Listener is an address the proxy accepts clients on.
+type Listener struct {
+	Addr string
+	// TLS offers TLS to the clients of the listener, using the configured
+	// certificate.
+	TLS bool
+}
 
 // Config is a struct that holds the configuration for the application.
 type Config struct {
+	// Listeners are the addresses the proxy accepts clients on.
+	Listeners []Listener
+	// TLSCertFile and TLSKeyFile are the certificate and key of the TLS
+	// listeners.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSRequired refuses the clients of the TLS listeners that do not
+	// upgrade their connection to TLS, such as those connecting with
+	// sslmode=disable. Plaintext listeners are not affected.
+	TLSRequired bool
+
 	TrinoHost    string
 	TrinoPort    string
 	TrinoCatalog string
 	TrinoSchema  string
+	// TrinoDSN is the Trino connection string. When set, it is used verbatim
+	// instead of the one assembled from the host, port, catalog and schema.
+	TrinoDSN string
+	// TrinoExtraHeaders are "Name: value" HTTP headers sent with every Trino
+	// request, such as the token of an authenticating gateway.
+	TrinoExtraHeaders []string
+	// TrinoHTTPTimeout is how long Trino may take to answer an HTTP request
+	// before it fails. Zero leaves requests without a timeout.
+	TrinoHTTPTimeout time.Duration
+	// TrinoAuth is how the proxy authenticates to Trino: jwt sends TrinoJWT
+	// as a bearer token and kerberos authenticates with the keytab of
+	// TrinoKerberosPrincipal. Empty sends no credentials.
+	TrinoAuth              string
+	TrinoJWT               string
+	TrinoKerberosKeytab    string
+	TrinoKerberosPrincipal string
+	TrinoKerberosRealm     string
+	TrinoKerberosConfig    string
+	// IdleInTransactionTimeout closes sessions left idle inside a transaction
+	// for longer than this duration. Zero disables the timeout.
+	IdleInTransactionTimeout time.Duration
+	// BreakerThreshold is the number of consecutive Trino failures opening
+	// the circuit breaker. Zero disables the breaker.
+	BreakerThreshold int
+	// BreakerCooldown is how long the open breaker rejects queries before
+	// letting a probe through.
+	BreakerCooldown time.Duration
+	// TimingNotices sends the execution time of every query to the client
+	// as a notice.
+	TimingNotices bool
+	// SlowQueryThreshold is the execution time above which queries are
+	// logged as slow. Zero disables the slow query log.
+	SlowQueryThreshold time.Duration
+	// ForceTextFormat sends every result in the text format, ignoring the
+	// binary format requested by clients, to isolate binary encoding bugs.
+	ForceTextFormat bool
+	// TrimChar trims the space padding of CHAR(n) values sent to clients.
+	TrimChar bool
+	// TrimText trims the leading and trailing whitespace of text values
+	// sent to clients, for clients migrated from systems that trimmed them.
+	TrimText bool
+	// MaxStatementBytes is the maximum size of a statement accepted from a
+	// client. Zero disables the limit.
+	MaxStatementBytes int
+	// MaxPreparedStatements is the maximum number of statements a session
+	// keeps prepared, with PREPARE or as named statements of the extended
+	// protocol. Zero disables the limit.
+	MaxPreparedStatements int
+	// MaxValueBytes is the maximum size of a single value sent to a client,
+	// which defaults to the 1 GB field limit of Postgres. Zero disables the
+	// limit.
+	MaxValueBytes int
+	// MaxFieldBytes is the size varchar, char and varbinary values are
+	// truncated to before they are sent to a client, which is told with a
+	// notice. Zero disables truncation.
+	MaxFieldBytes int
+	// RateLimitQPS is the number of queries per second a client address may
+	// run. Zero disables the limit.
+	RateLimitQPS int
+	// QueryMaxMemory is the query_max_memory Trino session property sent
+	// with every query, such as 10GB, to stop runaway queries. Clients may
+	// lower it with SET SESSION. Empty keeps the Trino default.
+	QueryMaxMemory string
+	// ListenBacklog is the length of the queue of connections waiting to be
+	// accepted on each listener. Zero keeps the system default.
+	ListenBacklog int
+	// TCPKeepAlive is the keepalive period of client connections. Zero
+	// keeps the default of 15 seconds and a negative period disables
+	// keepalives.
+	TCPKeepAlive time.Duration
+	// ShutdownTimeout is how long running queries may take to complete on
+	// shutdown before their connections are force-closed.
+	ShutdownTimeout time.Duration
+	// MetricsAddr is the address serving the metrics at /debug/vars. Empty
+	// disables the metrics endpoint.
+	MetricsAddr string
+	// KeepSemicolons sends statements to Trino exactly as received, without
+	// trimming their trailing semicolons, for debugging.
+	KeepSemicolons bool
+	// CaseInsensitiveNames resolves the catalog and schema names selected by
+	// clients or qualifying the tables of their queries, which Postgres
+	// clients fold to lowercase, to the Trino names matching them ignoring
+	// case.
+	CaseInsensitiveNames bool
+	// FoldIdentifiers folds unquoted identifiers to lowercase before sending
+	// queries to Trino, as Postgres does.
+	FoldIdentifiers bool
+	// MinTrinoVersion is the oldest Trino release, such as 400, the proxy
+	// starts against. Zero disables the check.
+	MinTrinoVersion int
+	// DebugChecksums logs the row count and a checksum of the result of
+	// every query.
+	DebugChecksums bool
+	// LogConnections logs every accepted and closed client connection with
+	// the client address.
+	LogConnections bool
+	// ClientAllowlist are the CIDR ranges clients may connect from. Empty
+	// allows every client.
+	ClientAllowlist []string
+	// LocalConstants answers SELECT and VALUES statements of constants,
+	// such as the SELECT 1 of connection tests, without querying Trino.
+	LocalConstants bool
+	// LocalCurrentUser answers SELECT current_user and session_user with the
+	// user the client connected as instead of the Trino user.
+	LocalCurrentUser bool
+	// JSONOperators rewrites the Postgres JSON operators -> and ->> into
+	// Trino's JSON functions. It is off by default, as x -> 'a' is also a
+	// Trino lambda expression.
+	JSONOperators bool
+	// StrictTypes fails queries returning columns of Trino types without a
+	// Postgres mapping instead of sending them as text.
+	StrictTypes bool
+	// EmptyMissingSchemas answers introspection queries, such as those of
+	// information_schema and SHOW TABLES, against a schema that does not
+	// exist with an empty result instead of an error.
+	EmptyMissingSchemas bool
+	// HstoreMaps sends MAP(VARCHAR, VARCHAR) values in the hstore text
+	// format instead of as JSON.
+	HstoreMaps bool
+	// GeometryOid is the type OID sent for Trino GEOMETRY and
+	// SPHERICALGEOGRAPHY columns, such as the geometry OID of a PostGIS
+	// database for PostGIS-aware clients. Zero sends them as text.
+	GeometryOid int
+	// HistorySize is the number of statements kept per session for
+	// pg2trino_history(). Zero disables the history.
+	HistorySize int
+	// SchemaPerQueryCatalogs are the catalogs whose queries must run with a
+	// session schema. Queries on them naming a schema.table run with that
+	// schema as the session schema.
+	SchemaPerQueryCatalogs []string
+	// TypeOverrides are trinoType=pgType pairs overriding the Postgres type
+	// Trino types are sent as, such as decimal=text for clients unable to
+	// handle numeric.
+	TypeOverrides []string
+	// CopyBatchSize is the number of rows of a COPY FROM STDIN inserted
+	// into Trino per INSERT statement. Zero inserts every row at once.
+	CopyBatchSize int
+	// ClientTags are the Trino client tags sent with every query, used by
+	// resource groups to route queries.
+	ClientTags []string
 }
 
 // NewConfig returns a new Config struct.
 func NewConfig() *Config {
 	return &Config{
-		TrinoHost:    getEnv("TRINO_HOST", "localhost"),
-		TrinoPort:    getEnv("TRINO_PORT", "8080"),
-		TrinoCatalog: getEnv("TRINO_CATALOG", "hive"),
-		TrinoSchema:  getEnv("TRINO_SCHEMA", "default"),
+		Listeners: listeners(
+			getEnvList("LISTEN_ADDRS", []string{"127.0.0.1:5432"}),
+			getEnvList("TLS_LISTEN_ADDRS", nil),
+		),
+		TLSCertFile:              getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:               getEnv("TLS_KEY_FILE", ""),
+		TLSRequired:              getEnvBool("TLS_REQUIRED", false),
+		TrinoHost:                getEnv("TRINO_HOST", "localhost"),
+		TrinoPort:                getEnv("TRINO_PORT", "8080"),
+		TrinoCatalog:             getEnv("TRINO_CATALOG", "hive"),
+		TrinoSchema:              getEnv("TRINO_SCHEMA", "default"),
+		TrinoDSN:                 getEnv("TRINO_DSN", ""),
+		TrinoExtraHeaders:        getEnvList("TRINO_EXTRA_HEADERS", nil),
+		TrinoHTTPTimeout:         getEnvDuration("TRINO_HTTP_TIMEOUT", 0),
+		TrinoAuth:                getEnv("TRINO_AUTH", ""),
+		TrinoJWT:                 getEnv("TRINO_JWT", ""),
+		TrinoKerberosKeytab:      getEnv("TRINO_KERBEROS_KEYTAB", ""),
+		TrinoKerberosPrincipal:   getEnv("TRINO_KERBEROS_PRINCIPAL", ""),
+		TrinoKerberosRealm:       getEnv("TRINO_KERBEROS_REALM", ""),
+		TrinoKerberosConfig:      getEnv("TRINO_KERBEROS_CONFIG", "/etc/krb5.conf"),
+		IdleInTransactionTimeout: getEnvDuration("IDLE_IN_TRANSACTION_TIMEOUT", 0),
+		BreakerThreshold:         getEnvInt("BREAKER_THRESHOLD", 5),
+		BreakerCooldown:          getEnvDuration("BREAKER_COOLDOWN", 30*time.Second),
+		TimingNotices:            getEnvBool("TIMING_NOTICES", false),
+		SlowQueryThreshold:       getEnvDuration("SLOW_QUERY_THRESHOLD", 0),
+		ForceTextFormat:          getEnvBool("FORCE_TEXT_FORMAT", false),
+		TrimChar:                 getEnvBool("TRIM_CHAR", false),
+		TrimText:                 getEnvBool("TRIM_TEXT", false),
+		ClientTags:               getEnvList("TRINO_CLIENT_TAGS", nil),
+		RateLimitQPS:             getEnvInt("RATE_LIMIT_QPS", 0),
+		MaxStatementBytes:        getEnvInt("MAX_STATEMENT_BYTES", 0),
+		MaxPreparedStatements:    getEnvInt("MAX_PREPARED_STATEMENTS", 0),
+		MaxValueBytes:            getEnvInt("MAX_VALUE_BYTES", 1<<30-1),
+		MaxFieldBytes:            getEnvInt("MAX_FIELD_BYTES", 0),
+		ShutdownTimeout:          getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		ListenBacklog:            getEnvInt("LISTEN_BACKLOG", 0),
+		QueryMaxMemory:           getEnv("QUERY_MAX_MEMORY", ""),
+		TCPKeepAlive:             getEnvDuration("TCP_KEEPALIVE", 0),
+		MetricsAddr:              getEnv("METRICS_ADDR", ""),
+		FoldIdentifiers:          getEnvBool("FOLD_IDENTIFIERS", false),
+		CaseInsensitiveNames:     getEnvBool("CASE_INSENSITIVE_NAMES", false),
+		KeepSemicolons:           getEnvBool("KEEP_SEMICOLONS", false),
+		MinTrinoVersion:          getEnvInt("MIN_TRINO_VERSION", 0),
+		ClientAllowlist:          getEnvList("CLIENT_ALLOWLIST", nil),
+		LogConnections:           getEnvBool("LOG_CONNECTIONS", false),
+		DebugChecksums:           getEnvBool("DEBUG_CHECKSUMS", false),
+		JSONOperators:            getEnvBool("JSON_OPERATORS", false),
+		StrictTypes:              getEnvBool("STRICT_TYPES", false),
+		EmptyMissingSchemas:      getEnvBool("EMPTY_MISSING_SCHEMAS", false),
+		LocalConstants:           getEnvBool("LOCAL_CONSTANTS", false),
+		LocalCurrentUser:         getEnvBool("LOCAL_CURRENT_USER", true),
+		HistorySize:              getEnvInt("HISTORY_SIZE", 20),
+		GeometryOid:              getEnvInt("GEOMETRY_OID", 0),
+		HstoreMaps:               getEnvBool("HSTORE_MAPS", false),
+		SchemaPerQueryCatalogs:   getEnvList("SCHEMA_PER_QUERY_CATALOGS", nil),
+		CopyBatchSize:            getEnvInt("COPY_BATCH_SIZE", 1000),
+		TypeOverrides:            getEnvList("TYPE_OVERRIDES", nil),
+	}
+}
+
+// listeners returns the plaintext listeners on the addresses plain followed
+// by the TLS listeners on the addresses secure.
+func listeners(plain, secure []string) []Listener {
+	var listeners []Listener
+	for _, addr := range plain {
+		listeners = append(listeners, Listener{Addr: addr})
 	}
+	for _, addr := range secure {
+		listeners = append(listeners, Listener{Addr: addr, TLS: true})
+	}
+	return listeners
 }
 
 // getEnv returns the value of an environment variable or
@@ -28,3 +259,64 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvDuration returns the duration stored in an environment variable or
+// a default value if the environment variable is not set or invalid.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration %q for %s, using %s", value, key, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
+// getEnvInt returns the integer stored in an environment variable or
+// a default value if the environment variable is not set or invalid.
+func getEnvInt(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer %q for %s, using %d", value, key, defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+// getEnvBool returns the boolean stored in an environment variable or
+// a default value if the environment variable is not set or invalid.
+func getEnvBool(key string, defaultValue bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean %q for %s, using %t", value, key, defaultValue)
+		return defaultValue
+	}
+	return enabled
+}
+
+// getEnvList returns the comma-separated values stored in an environment
+// variable or a default value if the environment variable is not set.
+func getEnvList(key string, defaultValue []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
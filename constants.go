@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/lib/pq/oid"
+)
+
+var (
+	// valuesKeyword matches the VALUES keyword starting a query.
+	valuesKeyword = regexp.MustCompile(`(?is)^\s*VALUES\s*`)
+	// constantAlias matches a select item followed by a column alias.
+	constantAlias = regexp.MustCompile(`(?is)^(.*?)\s+(?:AS\s+)?([a-z_][a-z0-9_]*|"(?:[^"]|"")+")$`)
+	// integerLiteral, decimalLiteral and stringLiteral match the constants
+	// answered locally.
+	integerLiteral = regexp.MustCompile(`^-?[0-9]+$`)
+	decimalLiteral = regexp.MustCompile(`^-?(?:[0-9]+\.[0-9]*|\.[0-9]+)$`)
+	stringLiteral  = regexp.MustCompile(`^'(?:[^']|'')*'$`)
+)
+
+// constantQuery answers a SELECT of constants without a FROM clause, such
+// as the SELECT 1 drivers test connections with, or a VALUES list of
+// constants, without a Trino round trip. It returns false for any other
+// query, which is left to Trino.
+func constantQuery(query string) (wire.PreparedStatements, bool) {
+	var (
+		names []string
+		rows  [][]string
+	)
+	if match := selectKeyword.FindStringIndex(query); match != nil {
+		list := query[match[1]:]
+		if topLevelIndex(list, fromKeyword) >= 0 {
+			return nil, false
+		}
+		var row []string
+		for _, item := range splitList(list) {
+			name := "?column?"
+			if expr, alias, ok := splitAlias(item); ok {
+				item, name = expr, alias
+			}
+			names = append(names, name)
+			row = append(row, item)
+		}
+		rows = append(rows, row)
+	} else if match := valuesKeyword.FindStringIndex(query); match != nil {
+		for _, item := range splitList(query[match[1]:]) {
+			if !strings.HasPrefix(item, "(") || !strings.HasSuffix(item, ")") {
+				return nil, false
+			}
+			row := splitList(item[1 : len(item)-1])
+			if len(rows) > 0 && len(row) != len(rows[0]) {
+				return nil, false
+			}
+			rows = append(rows, row)
+		}
+		if len(rows) == 0 {
+			return nil, false
+		}
+		for i := range rows[0] {
+			names = append(names, fmt.Sprintf("column%d", i+1))
+		}
+	} else {
+		return nil, false
+	}
+	if len(names) == 0 {
+		return nil, false
+	}
+
+	columns := make(wire.Columns, len(names))
+	values := make([][]any, len(rows))
+	for i := range values {
+		values[i] = make([]any, len(names))
+	}
+	for i, name := range names {
+		typ := oid.Oid(0)
+		for j, row := range rows {
+			value, valueType, ok := constantValue(row[i])
+			if !ok || valueType != 0 && typ != 0 && valueType != typ {
+				return nil, false
+			}
+			if valueType != 0 {
+				typ = valueType
+			}
+			values[j][i] = value
+		}
+		if typ == 0 {
+			// Columns holding only NULL are text, as in Postgres.
+			typ = oid.T_text
+		}
+		columns[i] = wire.Column{Name: name, Oid: typ, TypeModifier: -1}
+	}
+	return localRows(columns, values, fmt.Sprintf("SELECT %d", len(values))), true
+}
+
+// splitAlias splits a select item into its expression and column alias, or
+// returns false if it has no alias. Unquoted aliases are folded to
+// lowercase.
+func splitAlias(item string) (string, string, bool) {
+	match := constantAlias.FindStringSubmatch(item)
+	if match == nil {
+		return "", "", false
+	}
+	name := match[2]
+	if strings.HasPrefix(name, `"`) {
+		return match[1], strings.ReplaceAll(name[1:len(name)-1], `""`, `"`), true
+	}
+	return match[1], strings.ToLower(name), true
+}
+
+// constantValue returns the value and Postgres type of a constant. The type
+// of NULL is zero, as it is taken from the other values of its column.
+func constantValue(literal string) (any, oid.Oid, bool) {
+	switch {
+	case strings.EqualFold(literal, "NULL"):
+		return nil, 0, true
+	case strings.EqualFold(literal, "TRUE"):
+		return true, oid.T_bool, true
+	case strings.EqualFold(literal, "FALSE"):
+		return false, oid.T_bool, true
+	case integerLiteral.MatchString(literal):
+		n, err := strconv.ParseInt(literal, 10, 64)
+		if err != nil {
+			return nil, 0, false
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return n, oid.T_int8, true
+		}
+		return n, oid.T_int4, true
+	case decimalLiteral.MatchString(literal):
+		// Postgres prints .5 as 0.5 and 1. as 1.
+		literal = strings.TrimSuffix(literal, ".")
+		if i := strings.IndexByte(literal, '.'); i == 0 || i == 1 && literal[0] == '-' {
+			literal = literal[:i] + "0" + literal[i:]
+		}
+		return numeric(literal), oid.T_numeric, true
+	case stringLiteral.MatchString(literal):
+		return unquote(literal), oid.T_text, true
+	}
+	return nil, 0, false
+}
@@ -0,0 +1,141 @@
+package main_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"math/big"
+
+	"pg2trino/config"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Local constant queries", func() {
+	var (
+		fake   *fakeTrino
+		server *testServer
+		db     *sql.DB
+	)
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		server = startServer(fake, &config.Config{LocalConstants: true})
+		db = server.Connect("hive")
+	})
+
+	AfterEach(func() {
+		Expect(db.Close()).To(Succeed())
+		server.Close()
+	})
+
+	It("answers SELECT 1 without querying Trino", func() {
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(value).To(Equal(1))
+		Expect(fake.Queries()).To(BeEmpty())
+	})
+
+	It("answers a SELECT of typed, aliased constants", func() {
+		rows, err := db.Query(`SELECT 1 AS one, 'it''s', 2.50 "Price", true, NULL, 5000000000;`)
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		columns, err := rows.ColumnTypes()
+		Expect(err).NotTo(HaveOccurred())
+		var names, types []string
+		for _, column := range columns {
+			names = append(names, column.Name())
+			types = append(types, column.DatabaseTypeName())
+		}
+		Expect(names).To(Equal([]string{"one", "?column?", "Price", "?column?", "?column?", "?column?"}))
+		Expect(types).To(Equal([]string{"INT4", "TEXT", "NUMERIC", "BOOL", "TEXT", "INT8"}))
+
+		Expect(rows.Next()).To(BeTrue())
+		var (
+			one, big int64
+			text     string
+			price    string
+			flag     bool
+			null     sql.NullString
+		)
+		Expect(rows.Scan(&one, &text, &price, &flag, &null, &big)).To(Succeed())
+		Expect([]any{one, text, price, flag, null.Valid, big}).To(Equal([]any{int64(1), "it's", "2.50", true, false, int64(5000000000)}))
+		Expect(rows.Next()).To(BeFalse())
+		Expect(fake.Queries()).To(BeEmpty())
+	})
+
+	It("sends decimal constants as numerics binary clients can read", func() {
+		conn, err := pgx.Connect(context.Background(), server.DSN("hive"))
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(context.Background())
+
+		var price, half, one pgtype.Numeric
+		Expect(conn.QueryRow(context.Background(), "SELECT 2.50, -.5, 1.;").Scan(&price, &half, &one)).To(Succeed())
+		Expect(price).To(Equal(pgtype.Numeric{Int: big.NewInt(250), Exp: -2, Valid: true}))
+		Expect(half).To(Equal(pgtype.Numeric{Int: big.NewInt(-5), Exp: -1, Valid: true}))
+		Expect(one).To(Equal(pgtype.Numeric{Int: big.NewInt(1), Valid: true}))
+
+		var texts [3]string
+		Expect(db.QueryRow("SELECT 2.50, -.5, 1.;").Scan(&texts[0], &texts[1], &texts[2])).To(Succeed())
+		Expect(texts).To(Equal([3]string{"2.50", "-0.5", "1"}))
+		Expect(fake.Queries()).To(BeEmpty())
+	})
+
+	It("answers VALUES (1,2) without querying Trino", func() {
+		rows, err := db.Query("VALUES (1,2), (3, NULL);")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		columns, err := rows.Columns()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(columns).To(Equal([]string{"column1", "column2"}))
+
+		var values [][]sql.NullInt64
+		for rows.Next() {
+			row := make([]sql.NullInt64, 2)
+			Expect(rows.Scan(&row[0], &row[1])).To(Succeed())
+			values = append(values, row)
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		Expect(values).To(Equal([][]sql.NullInt64{
+			{{Int64: 1, Valid: true}, {Int64: 2, Valid: true}},
+			{{Int64: 3, Valid: true}, {}},
+		}))
+		Expect(fake.Queries()).To(BeEmpty())
+	})
+
+	It("forwards queries that are not constant to Trino", func() {
+		for _, query := range []string{
+			"SELECT now()",
+			"SELECT 1 FROM orders",
+			"SELECT 1 WHERE false",
+			"VALUES (1), ('a')",
+		} {
+			fake.On(query, fakeResult{
+				Columns: []fakeColumn{col("_col0", "varchar")},
+				Rows:    [][]driver.Value{{"trino"}},
+			})
+			var value string
+			Expect(db.QueryRow(query+";").Scan(&value)).To(Succeed(), query)
+			Expect(value).To(Equal("trino"), query)
+			Expect(fake.LastQuery().Query).To(Equal(query))
+		}
+	})
+
+	It("is disabled by default", func() {
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		plain := startServer(fake, &config.Config{})
+		defer plain.Close()
+		plainDB := plain.Connect("hive")
+		defer plainDB.Close()
+
+		var value int
+		Expect(plainDB.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(fake.Queries()).To(HaveLen(1))
+	})
+})
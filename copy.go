@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+var (
+	// copyFromStatement matches COPY table [(columns)] FROM STDIN followed by
+	// its options.
+	copyFromStatement = regexp.MustCompile(`(?is)^\s*COPY\s+((?:"(?:[^"]|"")+"|[a-z_][a-z0-9_$]*)(?:\s*\.\s*(?:"(?:[^"]|"")+"|[a-z_][a-z0-9_$]*))*)\s*(?:\(([^)]*)\))?\s*FROM\s+STDIN\b(.*)$`)
+	// copyOptionToken matches a token of the COPY options, in either the
+	// WITH (FORMAT csv, HEADER) or the older WITH CSV HEADER syntax.
+	copyOptionToken = regexp.MustCompile(`'(?:[^']|'')*'|[^\s,()]+`)
+)
+
+// copyFrom is a parsed COPY FROM STDIN statement.
+type copyFrom struct {
+	table   string
+	columns []string
+	csv     bool
+	header  bool
+	// delimiter separates the fields of a row.
+	delimiter byte
+	// null is the spelling of a NULL field.
+	null string
+}
+
+// parseCopyFrom parses a COPY FROM STDIN statement in the text or CSV
+// format. It returns false for any other statement, and an error for
+// options the proxy does not support.
+func parseCopyFrom(query string) (copyFrom, bool, error) {
+	match := copyFromStatement.FindStringSubmatch(query)
+	if match == nil {
+		return copyFrom{}, false, nil
+	}
+	c := copyFrom{table: match[1]}
+	for _, column := range splitList(match[2]) {
+		c.columns = append(c.columns, strings.TrimSpace(column))
+	}
+	delimiter, null := "", ""
+	delimiterSet, nullSet := false, false
+	tokens := copyOptionToken.FindAllString(match[3], -1)
+	// value returns the value following the option at tokens[i], skipping
+	// an AS keyword.
+	value := func(i *int) (string, error) {
+		if *i+1 < len(tokens) && strings.EqualFold(tokens[*i+1], "AS") {
+			*i++
+		}
+		if *i+1 >= len(tokens) {
+			return "", psqlerr.WithCode(fmt.Errorf("COPY option %s requires a value", tokens[*i]), codes.Syntax)
+		}
+		*i++
+		return unquote(tokens[*i]), nil
+	}
+	for i := 0; i < len(tokens); i++ {
+		var err error
+		switch strings.ToUpper(tokens[i]) {
+		case "WITH":
+		case "CSV":
+			c.csv = true
+		case "FORMAT":
+			var format string
+			if format, err = value(&i); err == nil {
+				switch strings.ToLower(format) {
+				case "csv":
+					c.csv = true
+				case "text":
+					c.csv = false
+				default:
+					err = psqlerr.WithCode(fmt.Errorf("COPY format %q is not supported", format), codes.FeatureNotSupported)
+				}
+			}
+		case "HEADER":
+			c.header = true
+			if i+1 < len(tokens) {
+				if header, ok := parseBool(tokens[i+1]); ok {
+					c.header = header
+					i++
+				}
+			}
+		case "DELIMITER":
+			delimiter, err = value(&i)
+			delimiterSet = true
+		case "NULL":
+			null, err = value(&i)
+			nullSet = true
+		default:
+			err = psqlerr.WithCode(fmt.Errorf("COPY option %s is not supported", tokens[i]), codes.FeatureNotSupported)
+		}
+		if err != nil {
+			return copyFrom{}, true, err
+		}
+	}
+
+	c.delimiter, c.null = '\t', `\N`
+	if c.csv {
+		c.delimiter, c.null = ',', ""
+	}
+	if delimiterSet {
+		if len(delimiter) != 1 {
+			return copyFrom{}, true, psqlerr.WithCode(errors.New("COPY delimiter must be a single one-byte character"), codes.FeatureNotSupported)
+		}
+		c.delimiter = delimiter[0]
+	}
+	if nullSet {
+		c.null = null
+	}
+	if c.header && !c.csv {
+		return copyFrom{}, true, psqlerr.WithCode(errors.New("COPY HEADER available only in CSV mode"), codes.FeatureNotSupported)
+	}
+	return c, true, nil
+}
+
+// parseBool parses the spellings of a boolean Postgres accepts.
+func parseBool(s string) (bool, bool) {
+	switch strings.ToLower(unquote(s)) {
+	case "t", "true", "y", "yes", "on", "1":
+		return true, true
+	case "f", "false", "n", "no", "off", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// copyColumn is a column of the table a COPY loads, with its Trino type.
+type copyColumn struct {
+	name string
+	typ  string
+}
+
+// copyFromStdin answers COPY FROM STDIN, which Trino has no equivalent of,
+// by reading the rows the client streams and inserting them into the table
+// with INSERT statements of COPY_BATCH_SIZE rows each. The column types are
+// looked up with DESCRIBE, so that the values can be cast to them.
+func (tdb *TrinoDB) copyFromStdin(c copyFrom) wire.PreparedStatements {
+	handle := func(ctx context.Context, writer wire.DataWriter, _ []wire.Parameter) error {
+		session := SessionFromContext(ctx)
+		if session.reader == nil || session.writer == nil {
+			return psqlerr.WithCode(errors.New("COPY FROM STDIN is not supported on this connection"), codes.FeatureNotSupported)
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		session.busy(cancel)
+		defer session.idle(tdb.Config.IdleInTransactionTimeout)
+
+		columns, err := tdb.copyColumns(ctx, session, c)
+		if err != nil {
+			return err
+		}
+		session.writer.Start(types.ServerCopyInResponse)
+		session.writer.AddByte(0) // text format
+		session.writer.AddInt16(int16(len(columns)))
+		for range columns {
+			session.writer.AddInt16(0)
+		}
+		if err := session.writer.End(); err != nil {
+			return err
+		}
+
+		var (
+			pending []byte
+			batch   [][]*string
+			copied  int64
+			header  = c.header
+			done    bool
+		)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			_, err := tdb.modify(ctx, session, c.insertQuery(columns, batch))
+			if err != nil {
+				return classifyError(err)
+			}
+			copied += int64(len(batch))
+			batch = batch[:0]
+			return nil
+		}
+		// add parses the complete records of pending and inserts every full
+		// batch.
+		add := func(final bool) error {
+			records, rest := splitRecords(pending, c.csv, final)
+			pending = rest
+			for _, record := range records {
+				if done || record == `\.` {
+					done = true
+					continue
+				}
+				if header {
+					header = false
+					continue
+				}
+				fields, err := c.parseRecord(record)
+				if err != nil {
+					return err
+				}
+				if len(fields) != len(columns) {
+					return psqlerr.WithCode(fmt.Errorf("COPY row has %d fields, expected %d", len(fields), len(columns)), codes.BadCopyFileFormat)
+				}
+				batch = append(batch, fields)
+				if size := tdb.Config.CopyBatchSize; size > 0 && len(batch) >= size {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+
+		for {
+			typ, _, err := session.reader.ReadTypedMsg()
+			if err != nil {
+				return err
+			}
+			switch typ {
+			case types.ClientCopyData:
+				pending = append(pending, session.reader.Msg...)
+				if err := add(false); err != nil {
+					return err
+				}
+			case types.ClientCopyDone:
+				if err := add(true); err != nil {
+					return err
+				}
+				if err := flush(); err != nil {
+					return err
+				}
+				return writer.Complete(fmt.Sprintf("COPY %d", copied))
+			case types.ClientCopyFail:
+				message, _, _ := bytes.Cut(session.reader.Msg, []byte{0})
+				return psqlerr.WithCode(fmt.Errorf("COPY from stdin failed: %s", message), codes.QueryCanceled)
+			case types.ClientFlush, types.ClientSync:
+			default:
+				return psqlerr.WithCode(fmt.Errorf("unexpected message type %q during COPY from stdin", byte(typ)), codes.ProtocolViolation)
+			}
+		}
+	}
+	return wire.Prepared(wire.NewStatement(handle))
+}
+
+// copyColumns returns the columns a COPY loads, in the order of its fields,
+// with their types as described by Trino.
+func (tdb *TrinoDB) copyColumns(ctx context.Context, session *Session, c copyFrom) ([]copyColumn, error) {
+	query := "DESCRIBE " + c.table
+	rows, err := tdb.QueryContext(ctx, query, tdb.queryArgs(ctx, session, query)...)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var described []copyColumn
+	for rows.Next() {
+		values := make([]sql.NullString, len(names))
+		pointers := make([]any, len(values))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		described = append(described, copyColumn{name: values[0].String, typ: values[1].String})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, classifyError(err)
+	}
+	if len(c.columns) == 0 {
+		return described, nil
+	}
+
+	columns := make([]copyColumn, len(c.columns))
+	for i, name := range c.columns {
+		folded := strings.ToLower(name)
+		if name[0] == '"' {
+			folded = strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+		}
+		found := false
+		for _, column := range described {
+			if column.name == folded {
+				columns[i], found = column, true
+				break
+			}
+		}
+		if !found {
+			return nil, psqlerr.WithCode(fmt.Errorf("column %q of relation %q does not exist", folded, c.table), codes.UndefinedColumn)
+		}
+	}
+	return columns, nil
+}
+
+// splitRecords splits data into its newline-terminated records, leaving an
+// incomplete last record as the rest unless final is set. Newlines inside
+// the quoted fields of CSV do not end a record.
+func splitRecords(data []byte, csv, final bool) ([]string, []byte) {
+	var records []string
+	start, quoted := 0, false
+	for i, b := range data {
+		switch {
+		case csv && b == '"':
+			quoted = !quoted
+		case b == '\n' && !quoted:
+			records = append(records, strings.TrimSuffix(string(data[start:i]), "\r"))
+			start = i + 1
+		}
+	}
+	if final && start < len(data) {
+		records = append(records, strings.TrimSuffix(string(data[start:]), "\r"))
+		start = len(data)
+	}
+	return records, data[start:]
+}
+
+// parseRecord splits a record into its fields, of which NULL fields are
+// nil.
+func (c copyFrom) parseRecord(record string) ([]*string, error) {
+	if c.csv {
+		return c.parseCSVRecord(record)
+	}
+	return c.parseTextRecord(record), nil
+}
+
+// parseTextRecord parses a record of the text format, in which backslash
+// escapes stand for special characters.
+func (c copyFrom) parseTextRecord(record string) []*string {
+	var fields []*string
+	for {
+		var (
+			field strings.Builder
+			i     int
+		)
+		for i = 0; i < len(record) && record[i] != c.delimiter; i++ {
+			if record[i] != '\\' || i+1 == len(record) {
+				field.WriteByte(record[i])
+				continue
+			}
+			i++
+			switch e := record[i]; e {
+			case 'b':
+				field.WriteByte('\b')
+			case 'f':
+				field.WriteByte('\f')
+			case 'n':
+				field.WriteByte('\n')
+			case 'r':
+				field.WriteByte('\r')
+			case 't':
+				field.WriteByte('\t')
+			case 'v':
+				field.WriteByte('\v')
+			case 'x':
+				n := 0
+				for n < 2 && i+1+n < len(record) && strings.IndexByte("0123456789abcdefABCDEF", record[i+1+n]) >= 0 {
+					n++
+				}
+				if n == 0 {
+					field.WriteByte(e)
+					break
+				}
+				v, _ := strconv.ParseUint(record[i+1:i+1+n], 16, 8)
+				field.WriteByte(byte(v))
+				i += n
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				n := 1
+				for n < 3 && i+n < len(record) && record[i+n] >= '0' && record[i+n] <= '7' {
+					n++
+				}
+				v, _ := strconv.ParseUint(record[i:i+n], 8, 8)
+				field.WriteByte(byte(v))
+				i += n - 1
+			default:
+				field.WriteByte(e)
+			}
+		}
+		// The NULL marker is compared with the field before unescaping.
+		if raw := record[:i]; raw == c.null {
+			fields = append(fields, nil)
+		} else {
+			value := field.String()
+			fields = append(fields, &value)
+		}
+		if i == len(record) {
+			return fields
+		}
+		record = record[i+1:]
+	}
+}
+
+// parseCSVRecord parses a record of the CSV format, in which fields may be
+// quoted and quotes inside them are doubled. An unquoted field matching the
+// NULL marker is NULL.
+func (c copyFrom) parseCSVRecord(record string) ([]*string, error) {
+	var fields []*string
+	for {
+		var (
+			field  strings.Builder
+			quoted bool
+			open   bool
+			i      int
+		)
+		for i = 0; i < len(record) && (open || record[i] != c.delimiter); i++ {
+			switch b := record[i]; {
+			case open && b == '"' && i+1 < len(record) && record[i+1] == '"':
+				field.WriteByte('"')
+				i++
+			case b == '"':
+				open = !open
+				quoted = true
+			default:
+				field.WriteByte(b)
+			}
+		}
+		if open {
+			return nil, psqlerr.WithCode(errors.New("unterminated CSV quoted field"), codes.BadCopyFileFormat)
+		}
+		if value := field.String(); !quoted && value == c.null {
+			fields = append(fields, nil)
+		} else {
+			fields = append(fields, &value)
+		}
+		if i >= len(record) {
+			return fields, nil
+		}
+		record = record[i+1:]
+	}
+}
+
+// insertQuery returns the INSERT of rows into the table, casting every
+// value to the type of its column.
+func (c copyFrom) insertQuery(columns []copyColumn, rows [][]*string) string {
+	var b strings.Builder
+	b.WriteString("INSERT INTO ")
+	b.WriteString(c.table)
+	b.WriteString(" (")
+	for i, column := range columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(`"` + strings.ReplaceAll(column.name, `"`, `""`) + `"`)
+	}
+	b.WriteString(") VALUES ")
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for j, value := range row {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(copyValue(value, columns[j].typ))
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// copyValue returns the SQL expression of a COPY field of a column of type
+// typ. Booleans are accepted in every spelling Postgres accepts.
+func copyValue(value *string, typ string) string {
+	if value == nil {
+		return "NULL"
+	}
+	if strings.EqualFold(typ, "boolean") {
+		if b, ok := parseBool(*value); ok {
+			return strconv.FormatBool(b)
+		}
+	}
+	literal := "'" + strings.ReplaceAll(*value, "'", "''") + "'"
+	if strings.HasPrefix(strings.ToLower(typ), "varchar") {
+		return literal
+	}
+	return "CAST(" + literal + " AS " + typ + ")"
+}
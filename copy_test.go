@@ -0,0 +1,91 @@
+package main_test
+
+import (
+	"database/sql/driver"
+
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("COPY FROM STDIN", func() {
+	var fake *fakeTrino
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		fake.On("DESCRIBE orders", fakeResult{
+			Columns: []fakeColumn{col("Column", "varchar"), col("Type", "varchar"), col("Extra", "varchar"), col("Comment", "varchar")},
+			Rows: [][]driver.Value{
+				{"id", "bigint", "", ""},
+				{"name", "varchar", "", ""},
+				{"paid", "boolean", "", ""},
+			},
+		})
+	})
+
+	// copyIn sends a COPY statement as a simple query followed by data and
+	// returns the messages answering it up to ReadyForQuery.
+	copyIn := func(server *testServer, query string, data ...string) (string, string) {
+		client := dialWire(server.addr, "hive")
+		defer client.Close()
+
+		client.Send('Q', cstring(query))
+		typ, body := client.Read()
+		Expect(typ).To(Equal(byte('G')))
+		Expect(body[0]).To(BeZero())
+		for _, chunk := range data {
+			client.Send('d', []byte(chunk))
+		}
+		client.Send('c')
+		var (
+			types []byte
+			tag   string
+		)
+		for {
+			typ, body := client.Read()
+			types = append(types, typ)
+			if typ == 'C' {
+				tag = string(body[:len(body)-1])
+			}
+			if typ == 'Z' {
+				return string(types), tag
+			}
+		}
+	}
+
+	It("inserts the rows of the text format in batches", func() {
+		server := startServer(fake, &config.Config{CopyBatchSize: 2})
+		defer server.Close()
+		fake.On(`INSERT INTO orders ("id", "name") VALUES (CAST('1' AS bigint), 'tea'), (CAST('2' AS bigint), NULL)`, fakeResult{RowsAffected: 2})
+		fake.On(`INSERT INTO orders ("id", "name") VALUES (CAST('3' AS bigint), 'it''s	cake')`, fakeResult{RowsAffected: 1})
+
+		// The rows are split across the CopyData messages.
+		types, tag := copyIn(server, "COPY orders (id, name) FROM STDIN;", "1\ttea\n2\t\\N\n3\tit's", "\\tcake\n\\.\n")
+		Expect(types).To(Equal("CZ"))
+		Expect(tag).To(Equal("COPY 3"))
+		Expect(fake.Queries()).To(HaveLen(3))
+	})
+
+	It("reads CSV with a header, quoted fields and booleans", func() {
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		insert := `INSERT INTO orders ("id", "name", "paid") VALUES (CAST('1' AS bigint), 'tea, "hot"', true), (CAST('2' AS bigint), '', NULL)`
+		fake.On(insert, fakeResult{RowsAffected: 2})
+
+		types, tag := copyIn(server, "COPY orders FROM STDIN WITH (FORMAT csv, HEADER true);",
+			"id,name,paid\n", "1,\"tea, \"\"hot\"\"\",t\r\n2,\"\",\n")
+		Expect(types).To(Equal("CZ"))
+		Expect(tag).To(Equal("COPY 2"))
+		Expect(fake.LastQuery().Query).To(Equal(insert))
+	})
+
+	It("rejects rows with the wrong number of fields", func() {
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+
+		types, _ := copyIn(server, "COPY orders (id, name) FROM STDIN CSV;", "1,tea,extra\n")
+		Expect(types).To(Equal("EZ"))
+		Expect(fake.Queries()).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/lib/pq/oid"
+)
+
+var (
+	// pgDatabaseStatement matches a SELECT from pg_database with an optional
+	// table alias, WHERE and ORDER BY clause, as run by the \l command of
+	// psql.
+	pgDatabaseStatement = regexp.MustCompile(`(?is)^\s*SELECT\s+(.+?)\s+FROM\s+(?:pg_catalog\.)?pg_database(?:\s+(?:AS\s+)?([a-z_][a-z0-9_]*))?(?:\s+WHERE\s+(.+?))?(?:\s+ORDER\s+BY\s+(.+?))?\s*$`)
+	// databaseColumnRef matches a reference to a pg_database column,
+	// optionally qualified by the table alias.
+	databaseColumnRef = regexp.MustCompile(`(?i)(?:\b([a-z_][a-z0-9_]*)\.)?\b(dat[a-z]+|encoding)\b`)
+	// datnameCondition matches a datname = 'name' condition, or the
+	// datname OPERATOR(pg_catalog.~) '^(pattern)$' condition of \l pattern.
+	datnameCondition = regexp.MustCompile(`(?is)^(?:[a-z_][a-z0-9_]*\.)?datname\s*(=|~|OPERATOR\s*\(\s*pg_catalog\.~\s*\))\s*('(?:[^']|'')*')(?:\s+COLLATE\s+\S+)?$`)
+	// databaseFlagCondition matches a condition on the datallowconn or
+	// datistemplate flag.
+	databaseFlagCondition = regexp.MustCompile(`(?i)^(NOT\s+)?(?:[a-z_][a-z0-9_]*\.)?(datallowconn|datistemplate)$`)
+)
+
+// pgDatabaseColumn is a column of the pg_database catalog, whose value is
+// the same for every Trino catalog but its name.
+type pgDatabaseColumn struct {
+	typ   oid.Oid
+	value any
+}
+
+// pgDatabaseColumns are the pg_database columns answered locally. datname
+// is the name of the Trino catalog.
+var pgDatabaseColumns = map[string]pgDatabaseColumn{
+	"datname":        {oid.T_name, nil},
+	"datdba":         {oid.T_oid, uint32(10)},
+	"encoding":       {oid.T_int4, int64(6)},
+	"datlocprovider": {oid.T_char, byte('c')},
+	"datistemplate":  {oid.T_bool, false},
+	"datallowconn":   {oid.T_bool, true},
+	"datconnlimit":   {oid.T_int4, int64(-1)},
+	"datcollate":     {oid.T_text, "C"},
+	"datctype":       {oid.T_text, "C"},
+	"datlocale":      {oid.T_text, nil},
+	"daticulocale":   {oid.T_text, nil},
+	"daticurules":    {oid.T_text, nil},
+	"datacl":         {oid.T_text, nil},
+}
+
+// databaseFunctions are the expressions \l wraps pg_database columns in,
+// with their result. An empty value stands for the user the client
+// connected as.
+var databaseFunctions = []struct {
+	expr  *regexp.Regexp
+	typ   oid.Oid
+	value any
+}{
+	{regexp.MustCompile(`(?i)^(?:pg_catalog\.)?pg_get_userbyid\s*\(`), oid.T_name, ""},
+	{regexp.MustCompile(`(?i)^(?:pg_catalog\.)?pg_encoding_to_char\s*\(`), oid.T_name, "UTF8"},
+	{regexp.MustCompile(`(?i)^CASE\b.*\bdatlocprovider\b`), oid.T_text, "libc"},
+	{regexp.MustCompile(`(?i)^(?:pg_catalog\.)?array_to_string\s*\(`), oid.T_text, nil},
+}
+
+// pgDatabaseQuery answers the SELECT from pg_database of the \l command of
+// psql, and similar lookups of database names, with the Trino catalogs as
+// the databases. Select items are pg_database columns or the functions \l
+// applies to them, and WHERE conditions compare datname or test the
+// datallowconn and datistemplate flags. Databases are listed by name. It
+// returns false for any other query, which is left to Trino.
+func (tdb *TrinoDB) pgDatabaseQuery(ctx context.Context, query string) (wire.PreparedStatements, bool, error) {
+	match := pgDatabaseStatement.FindStringSubmatch(query)
+	if match == nil {
+		return nil, false, nil
+	}
+	alias := match[2]
+	// column returns the pg_database column referenced by expr.
+	column := func(expr string) (string, bool) {
+		refs := databaseColumnRef.FindAllStringSubmatch(expr, -1)
+		if len(refs) != 1 || refs[0][1] != "" && !strings.EqualFold(refs[0][1], alias) && !strings.EqualFold(refs[0][1], "pg_database") {
+			return "", false
+		}
+		name := strings.ToLower(refs[0][2])
+		_, ok := pgDatabaseColumns[name]
+		return name, ok
+	}
+
+	user := wire.AuthenticatedUsername(ctx)
+	items := splitList(match[1])
+	columns := make(wire.Columns, len(items))
+	values := make([]func(catalog string) any, len(items))
+	for i, item := range items {
+		expr, name, ok := splitAlias(item)
+		if !ok {
+			expr = item
+		}
+		col, ok := column(expr)
+		if !ok {
+			return nil, false, nil
+		}
+		if name == "" {
+			name = col
+		}
+		typ, value := pgDatabaseColumns[col].typ, pgDatabaseColumns[col].value
+		for _, f := range databaseFunctions {
+			if f.expr.MatchString(strings.TrimSpace(expr)) {
+				typ, value = f.typ, f.value
+				if value == "" {
+					value = user
+				}
+			}
+		}
+		columns[i] = wire.Column{Name: name, Oid: typ, TypeModifier: -1}
+		if col == "datname" && value == nil {
+			values[i] = func(catalog string) any { return catalog }
+		} else {
+			values[i] = func(string) any { return value }
+		}
+	}
+
+	var filters []func(catalog string) bool
+	if match[3] != "" {
+		for _, condition := range catalogAnd.Split(match[3], -1) {
+			condition = strings.TrimSpace(condition)
+			if flag := databaseFlagCondition.FindStringSubmatch(condition); flag != nil {
+				if strings.EqualFold(flag[2], "datallowconn") == (flag[1] != "") {
+					filters = append(filters, func(string) bool { return false })
+				}
+				continue
+			}
+			parts := datnameCondition.FindStringSubmatch(condition)
+			if parts == nil {
+				return nil, false, nil
+			}
+			value := unquote(parts[2])
+			if parts[1] == "=" {
+				filters = append(filters, func(catalog string) bool { return catalog == value })
+				continue
+			}
+			pattern, err := regexp.Compile(value)
+			if err != nil {
+				err = fmt.Errorf("invalid regular expression: %w", err)
+				return nil, true, psqlerr.WithCode(err, codes.InvalidRegularExpression)
+			}
+			filters = append(filters, pattern.MatchString)
+		}
+	}
+
+	result, err := tdb.fetch(ctx, SessionFromContext(ctx), "SHOW CATALOGS")
+	if err != nil {
+		return nil, true, classifyError(err)
+	}
+	catalogs := make([]string, len(result.rows))
+	for i, row := range result.rows {
+		catalogs[i] = fmt.Sprint(row[0])
+	}
+	sort.Strings(catalogs)
+	var rows [][]any
+next:
+	for _, catalog := range catalogs {
+		for _, filter := range filters {
+			if !filter(catalog) {
+				continue next
+			}
+		}
+		row := make([]any, len(values))
+		for i, value := range values {
+			row[i] = value(catalog)
+		}
+		rows = append(rows, row)
+	}
+	return localRows(columns, rows, fmt.Sprintf("SELECT %d", len(rows))), true, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+// discardStatement matches the Postgres DISCARD { ALL | PLANS | SEQUENCES |
+// TEMPORARY | TEMP } statement.
+var discardStatement = regexp.MustCompile(`(?is)^\s*DISCARD\s+(ALL|PLANS|SEQUENCES|TEMPORARY|TEMP)\s*$`)
+
+// errDiscardInTransaction rejects DISCARD ALL inside a transaction block,
+// as Postgres does.
+var errDiscardInTransaction = psqlerr.WithCode(errors.New("DISCARD ALL cannot run inside a transaction block"), codes.ActiveSQLTransaction)
+
+// parseDiscard returns what a DISCARD statement discards, such as ALL, or
+// false if query is not one.
+func parseDiscard(query string) (string, bool) {
+	match := discardStatement.FindStringSubmatch(query)
+	if match == nil {
+		return "", false
+	}
+	return strings.ToUpper(match[1]), true
+}
+
+// discard answers DISCARD, which connection poolers such as pgbouncer send
+// to reset a connection between clients. DISCARD ALL resets the session
+// state; the plans, sequences and temporary tables the other forms discard
+// do not exist in the proxy.
+func discard(ctx context.Context, what string) (wire.PreparedStatements, error) {
+	if what == "ALL" {
+		session := SessionFromContext(ctx)
+		if session.InTransaction() {
+			return nil, errDiscardInTransaction
+		}
+		session.reset()
+	}
+	if what == "TEMPORARY" {
+		what = "TEMP"
+	}
+	return commandComplete("DISCARD " + what), nil
+}
+
+// reset restores the state of a session to the one it started with: it
+// restores the settings of its startup, resets the Trino session properties
+// and role, and deallocates every prepared statement and portal.
+func (s *Session) reset() {
+	s.resetSettings()
+	s.mu.Lock()
+	s.properties = nil
+	s.role = ""
+	s.prepared = nil
+	s.mu.Unlock()
+	s.extended.reset()
+}
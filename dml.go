@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// dmlStatement matches the INSERT, UPDATE, DELETE and MERGE statements,
+	// and CREATE TABLE AS, which Trino reports the inserted rows of too.
+	dmlStatement = regexp.MustCompile(`(?is)^\s*(?:INSERT|UPDATE|DELETE|MERGE)\s|` + ctasPattern)
+	// ddlStatement matches the CREATE, DROP, ALTER, COMMENT, GRANT and
+	// REVOKE statements, which return no rows.
+	ddlStatement = regexp.MustCompile(`(?is)^\s*(?:CREATE|DROP|ALTER|COMMENT|GRANT|REVOKE)\s`)
+	// ctasStatement matches CREATE TABLE AS.
+	ctasStatement = regexp.MustCompile(ctasPattern)
+	// readStatement matches the statements that only read data.
+	readStatement = regexp.MustCompile(`(?is)^[\s(]*(?:SELECT|SHOW|EXPLAIN|DESCRIBE|VALUES|TABLE|WITH)\b`)
+	// explainAnalyze matches EXPLAIN ANALYZE, which runs the statement it
+	// explains.
+	explainAnalyze = regexp.MustCompile(`(?is)^\s*EXPLAIN\s+ANALYZE\b`)
+	// writeKeyword matches the keywords of the statements writing data,
+	// which a WITH query may wrap.
+	writeKeyword = regexp.MustCompile(`(?i)\b(?:INSERT|UPDATE|DELETE|MERGE)\b`)
+	// commandWords matches the leading keywords of a statement.
+	commandWords = regexp.MustCompile(`^\s*([A-Za-z]+)(?:\s+([A-Za-z]+))?(?:\s+([A-Za-z]+))?(?:\s+([A-Za-z]+))?`)
+)
+
+// ctasPattern matches CREATE TABLE name AS query, the query optionally in
+// parentheses.
+const ctasPattern = `(?is)^\s*CREATE\s+(?:OR\s+REPLACE\s+)?TABLE\s+.+?\s+AS\s*\(?\s*(?:SELECT|WITH|VALUES|TABLE)\b`
+
+// isDML reports whether query is a statement Trino reports the affected
+// rows of instead of returning rows.
+func isDML(query string) bool {
+	return dmlStatement.MatchString(query)
+}
+
+// isDDL reports whether query is a statement changing the schema, which
+// Trino answers with a single boolean row when run as a query instead.
+func isDDL(query string) bool {
+	return ddlStatement.MatchString(query) && !ctasStatement.MatchString(query)
+}
+
+// isRetryable reports whether query is free of side effects, so it can be
+// run again after a failure: a SELECT, SHOW, EXPLAIN without ANALYZE,
+// DESCRIBE, VALUES, TABLE or WITH query without data-modifying statements.
+// Any other statement might have been applied before failing.
+func isRetryable(query string) bool {
+	if !readStatement.MatchString(query) || explainAnalyze.MatchString(query) {
+		return false
+	}
+	literals := literalRanges(query)
+	for _, loc := range writeKeyword.FindAllStringIndex(query, -1) {
+		if !insideRanges(literals, loc[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// commandTag returns the Postgres command tag of a statement that affected
+// or returned rows rows, e.g. SELECT 3, INSERT 0 5, DELETE 3 or CREATE
+// TABLE. Statements without a tag of their own are reported as SELECT, as
+// they return rows.
+func commandTag(query string, rows int64) string {
+	if ctasStatement.MatchString(query) {
+		return fmt.Sprintf("SELECT %d", rows)
+	}
+	var words []string
+	if match := commandWords.FindStringSubmatch(query); match != nil {
+		for _, word := range match[1:] {
+			if word != "" {
+				words = append(words, strings.ToUpper(word))
+			}
+		}
+	}
+	if len(words) == 0 {
+		return fmt.Sprintf("SELECT %d", rows)
+	}
+	switch command := words[0]; command {
+	case "INSERT":
+		return fmt.Sprintf("INSERT 0 %d", rows)
+	case "UPDATE", "DELETE", "MERGE":
+		return fmt.Sprintf("%s %d", command, rows)
+	case "CREATE", "DROP", "ALTER":
+		object := words[1:]
+		if len(object) >= 2 && object[0] == "OR" && object[1] == "REPLACE" {
+			object = object[2:]
+		}
+		if len(object) == 0 {
+			return command
+		}
+		if object[0] == "MATERIALIZED" && len(object) >= 2 {
+			return command + " MATERIALIZED " + object[1]
+		}
+		return command + " " + object[0]
+	case "EXPLAIN", "SHOW", "GRANT", "REVOKE", "COMMENT", "CALL", "ANALYZE", "DEALLOCATE", "PREPARE":
+		return command
+	default:
+		return fmt.Sprintf("SELECT %d", rows)
+	}
+}
+
+// modify runs a DML or DDL statement on Trino and returns its command tag
+// with the number of affected rows. The given Trino headers are sent in
+// addition to those of the session.
+func (tdb *TrinoDB) modify(ctx context.Context, session *Session, query string, headers ...any) (*queryResult, error) {
+	if err := session.checkWrite(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	progress := &queryProgress{}
+	args := append(tdb.queryArgs(ctx, session, query), headers...)
+	args = append(args, progress.args()...)
+	result, err := tdb.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &queryResult{
+		tag:      commandTag(query, n),
+		progress: progress,
+		elapsed:  time.Since(start),
+	}, nil
+}
+
+// ExecContext runs a statement returning no rows on Trino through the
+// circuit breaker.
+func (tdb *TrinoDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if err := tdb.breaker.allow(); err != nil {
+		return nil, err
+	}
+	result, err := tdb.executor.ExecContext(ctx, query, args...)
+	tdb.breaker.record(err)
+	return result, err
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// drainSignals are the signals toggling the draining mode of the servers,
+// of which there are none on this platform.
+var drainSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// drainSignals are the signals toggling the draining mode of the servers.
+var drainSignals = []os.Signal{syscall.SIGUSR1}
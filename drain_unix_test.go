@@ -0,0 +1,65 @@
+//go:build unix
+
+package main_test
+
+import (
+	"database/sql/driver"
+	"os"
+	"syscall"
+	"time"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Draining", func() {
+	It("refuses new connections on SIGUSR1 while open ones complete their queries", func() {
+		fake := newFakeTrino()
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		fake.On("SELECT count(*) FROM events", fakeResult{
+			Columns: []fakeColumn{col("_col0", "bigint")},
+			Rows:    [][]driver.Value{{int64(7)}},
+			Delay:   300 * time.Millisecond,
+		})
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		stop := pgtrino.WatchDrainSignal([]*pgtrino.Server{server.Server})
+		defer stop()
+
+		var one int
+		existing := server.Connect("memory")
+		defer existing.Close()
+		Expect(existing.QueryRow("SELECT 1;").Scan(&one)).To(Succeed())
+		counted := make(chan int64, 1)
+		go func() {
+			defer GinkgoRecover()
+			var count int64
+			Expect(existing.QueryRow("SELECT count(*) FROM events;").Scan(&count)).To(Succeed())
+			counted <- count
+		}()
+		Eventually(fake.Queries).Should(HaveLen(2))
+
+		Expect(syscall.Kill(os.Getpid(), syscall.SIGUSR1)).To(Succeed())
+		Eventually(server.Draining).Should(BeTrue())
+		refused := server.Connect("memory")
+		defer refused.Close()
+		err := refused.QueryRow("SELECT 1;").Scan(&one)
+		Expect(err).To(BeAssignableToTypeOf(&pq.Error{}))
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("57P03"))
+		Expect(err.(*pq.Error).Severity).To(Equal("FATAL"))
+		Eventually(counted).Should(Receive(Equal(int64(7))))
+
+		Expect(syscall.Kill(os.Getpid(), syscall.SIGUSR1)).To(Succeed())
+		Eventually(server.Draining).Should(BeFalse())
+		accepted := server.Connect("memory")
+		defer accepted.Close()
+		Expect(accepted.QueryRow("SELECT 1;").Scan(&one)).To(Succeed())
+	})
+})
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+// trinoErrors maps Trino error messages to the SQLSTATE Postgres reports for
+// the same failure.
+var trinoErrors = []struct {
+	message *regexp.Regexp
+	code    codes.Code
+}{
+	{regexp.MustCompile(`Table '[^']*' does not exist`), codes.UndefinedTable},
+	{regexp.MustCompile(`Schema '[^']*' does not exist`), codes.InvalidSchemaName},
+	{regexp.MustCompile(`Catalog '[^']*' (?:does not exist|not found)`), codes.InvalidCatalogName},
+	{regexp.MustCompile(`Column '[^']*' cannot be resolved`), codes.UndefinedColumn},
+	{regexp.MustCompile(`Function '[^']*' not registered`), codes.UndefinedFunction},
+	{regexp.MustCompile(`Current transaction is aborted`), codes.InFailedSQLTransaction},
+	{regexp.MustCompile(`only supports writes using autocommit|not supported in (?:a|explicit) transaction`), codes.ActiveSQLTransaction},
+}
+
+// classifyError attaches the matching SQLSTATE to a Trino query error, so
+// clients can handle it like the equivalent Postgres error. Errors that
+// already carry a code or are not recognized are returned unchanged.
+func classifyError(err error) error {
+	if err == nil || psqlerr.GetCode(err) != codes.Uncategorized {
+		return err
+	}
+	for _, e := range trinoErrors {
+		if e.message.MatchString(err.Error()) {
+			return psqlerr.WithCode(err, e.code)
+		}
+	}
+	return err
+}
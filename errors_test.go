@@ -0,0 +1,140 @@
+package main_test
+
+import (
+	"database/sql/driver"
+	"errors"
+
+	"pg2trino/config"
+
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Errors", func() {
+	var (
+		fake   *fakeTrino
+		server *testServer
+	)
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		server = startServer(fake, &config.Config{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	expectCode := func(query, message, code string) {
+		fake.On(query, fakeResult{Err: errors.New(message)})
+		db := server.Connect("hive")
+		defer db.Close()
+
+		_, err := db.Exec(query + ";")
+		Expect(err).To(BeAssignableToTypeOf(&pq.Error{}))
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo(code))
+		Expect(err.(*pq.Error).Message).To(ContainSubstring(message))
+	}
+
+	It("maps a missing table to undefined_table", func() {
+		expectCode("SELECT * FROM missing",
+			"io.trino.spi.TrinoException: line 1:15: Table 'hive.default.missing' does not exist", "42P01")
+	})
+
+	It("maps a missing schema to invalid_schema_name", func() {
+		expectCode("SELECT * FROM nowhere.users",
+			"io.trino.spi.TrinoException: line 1:15: Schema 'nowhere' does not exist", "3F000")
+	})
+
+	It("answers introspection of a missing schema with an empty result with EMPTY_MISSING_SCHEMAS", func() {
+		const missing = "io.trino.spi.TrinoException: line 1:15: Schema 'nowhere' does not exist"
+		fake.On("SELECT table_name, ordinal_position FROM nowhere.information_schema.columns ORDER BY 2", fakeResult{Err: errors.New(missing)})
+		fake.On("SELECT * FROM (\nSELECT table_name, ordinal_position FROM information_schema.columns ORDER BY 2\n) AS missing WHERE false", fakeResult{
+			Columns: []fakeColumn{col("table_name", "varchar"), col("ordinal_position", "bigint")},
+		})
+		fake.On("SHOW TABLES FROM nowhere", fakeResult{Err: errors.New(missing)})
+		fake.On("DESCRIBE nowhere.users", fakeResult{Err: errors.New(missing)})
+		fake.On("SELECT * FROM nowhere.users", fakeResult{Err: errors.New(missing)})
+		fake.On("SELECT 'information_schema.tables' FROM nowhere.users", fakeResult{Err: errors.New(missing)})
+		tolerant := startServer(fake, &config.Config{EmptyMissingSchemas: true})
+		defer tolerant.Close()
+		db := tolerant.Connect("hive")
+		defer db.Close()
+
+		for query, columns := range map[string][]string{
+			"SELECT table_name, ordinal_position FROM nowhere.information_schema.columns ORDER BY 2;": {"table_name", "ordinal_position"},
+			"SHOW TABLES FROM nowhere;": {"Table"},
+			"DESCRIBE nowhere.users;":   {"Column", "Type", "Extra", "Comment"},
+		} {
+			rows, err := db.Query(query)
+			Expect(err).NotTo(HaveOccurred(), query)
+			Expect(rows.Columns()).To(Equal(columns), query)
+			Expect(rows.Next()).To(BeFalse(), query)
+			Expect(rows.Err()).NotTo(HaveOccurred(), query)
+			Expect(rows.Close()).To(Succeed())
+		}
+
+		for _, query := range []string{"SELECT * FROM nowhere.users;", "SELECT 'information_schema.tables' FROM nowhere.users;"} {
+			_, err := db.Exec(query)
+			Expect(err).To(BeAssignableToTypeOf(&pq.Error{}), query)
+			Expect(err.(*pq.Error).Code).To(BeEquivalentTo("3F000"), query)
+		}
+	})
+
+	It("maps a missing catalog to invalid_catalog_name", func() {
+		expectCode("SELECT * FROM nocat.default.users",
+			"io.trino.spi.TrinoException: line 1:15: Catalog 'nocat' does not exist", "3D000")
+	})
+
+	It("rejects sequence functions with feature_not_supported", func() {
+		fake.On("SELECT 'nextval(x)'", fakeResult{
+			Columns: []fakeColumn{col("_col0", "varchar")},
+			Rows:    [][]driver.Value{{"nextval(x)"}},
+		})
+		db := server.Connect("hive")
+		defer db.Close()
+
+		for _, query := range []string{"SELECT nextval('x');", "SELECT pg_catalog.CURRVAL('public.x');", "INSERT INTO t VALUES (nextval('x'), 1);"} {
+			_, err := db.Exec(query)
+			Expect(err).To(BeAssignableToTypeOf(&pq.Error{}), query)
+			Expect(err.(*pq.Error).Code).To(BeEquivalentTo("0A000"), query)
+			Expect(err.(*pq.Error).Message).To(MatchRegexp(`^function (nextval|currval) is not supported, as Trino has no sequences$`))
+		}
+		Expect(fake.Queries()).To(BeEmpty())
+
+		var value string
+		Expect(db.QueryRow("SELECT 'nextval(x)';").Scan(&value)).To(Succeed())
+		Expect(value).To(Equal("nextval(x)"))
+	})
+
+	It("leaves unrecognized errors uncategorized", func() {
+		expectCode("SELECT 1/0", "io.trino.spi.TrinoException: Division by zero", "XXUUU")
+	})
+
+	It("turns a panic into an internal error and keeps the connection", func() {
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		fake.On("SELECT id FROM broken", fakeResult{
+			Columns:  []fakeColumn{col("id", "bigint")},
+			RowCount: 1,
+			RowFunc: func(i int) []driver.Value {
+				return [][]driver.Value{}[i]
+			},
+		})
+		db := server.Connect("hive")
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+
+		_, err := db.Query("SELECT id FROM broken;")
+		Expect(err).To(BeAssignableToTypeOf(&pq.Error{}))
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("XX000"))
+		Expect(err.(*pq.Error).Message).To(ContainSubstring("index out of range"))
+
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(server.ClientConns()).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+)
+
+// QueryExecutor runs statements on Trino. TrinoDB satisfies it, guarding the
+// Trino connection with the circuit breaker. Tests substitute an in-memory
+// implementation.
+type QueryExecutor interface {
+	QueryContext(ctx context.Context, query string, args ...any) (Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Rows is the result of a query run by a QueryExecutor, read like
+// *sql.Rows.
+type Rows interface {
+	Columns() ([]string, error)
+	ColumnTypes() ([]ColumnType, error)
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+	Close() error
+}
+
+// ColumnType describes a result column of Rows, as *sql.ColumnType does.
+type ColumnType interface {
+	Name() string
+	DatabaseTypeName() string
+	ScanType() reflect.Type
+	DecimalSize() (precision, scale int64, ok bool)
+}
+
+// sqlExecutor is the QueryExecutor running statements on a *sql.DB or in a
+// *sql.Tx.
+type sqlExecutor struct {
+	db interface {
+		QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+		ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	}
+}
+
+func (e sqlExecutor) QueryContext(ctx context.Context, query string, args ...any) (Rows, error) {
+	rows, err := e.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return sqlRows{rows}, nil
+}
+
+func (e sqlExecutor) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return e.db.ExecContext(ctx, query, args...)
+}
+
+// sqlRows are the Rows of a *sql.Rows.
+type sqlRows struct {
+	*sql.Rows
+}
+
+func (r sqlRows) ColumnTypes() ([]ColumnType, error) {
+	columns, err := r.Rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	types := make([]ColumnType, len(columns))
+	for i, col := range columns {
+		types[i] = col
+	}
+	return types, nil
+}
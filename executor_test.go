@@ -0,0 +1,233 @@
+package main_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	"github.com/lib/pq/oid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// mockExecutor is an in-memory QueryExecutor answering queries with canned
+// results and counting the statements it runs.
+type mockExecutor struct {
+	mu      sync.Mutex
+	results map[string]mockResult
+	queries int
+	execs   int
+}
+
+// mockResult is the canned answer of a mockExecutor for a statement.
+type mockResult struct {
+	Columns []fakeColumn
+	// RowCount rows are generated by RowFunc.
+	RowCount     int
+	RowFunc      func(i int) []any
+	RowsAffected int64
+}
+
+func newMockExecutor() *mockExecutor {
+	return &mockExecutor{results: map[string]mockResult{}}
+}
+
+// On registers the result returned for the given statement text.
+func (m *mockExecutor) On(query string, result mockResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[query] = result
+}
+
+func (m *mockExecutor) result(query string) (mockResult, error) {
+	result, ok := m.results[query]
+	if !ok {
+		return mockResult{}, fmt.Errorf("mock executor: unexpected statement %q", query)
+	}
+	return result, nil
+}
+
+func (m *mockExecutor) QueryContext(_ context.Context, query string, _ ...any) (pgtrino.Rows, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries++
+	result, err := m.result(query)
+	if err != nil {
+		return nil, err
+	}
+	return &mockRows{result: result, index: -1}, nil
+}
+
+func (m *mockExecutor) ExecContext(_ context.Context, query string, _ ...any) (sql.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.execs++
+	result, err := m.result(query)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(result.RowsAffected), nil
+}
+
+// Counts returns the number of queries and statements without rows run.
+func (m *mockExecutor) Counts() (queries, execs int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queries, m.execs
+}
+
+// mockRows are the rows of a mockResult.
+type mockRows struct {
+	result mockResult
+	index  int
+}
+
+func (r *mockRows) Columns() ([]string, error) {
+	names := make([]string, len(r.result.Columns))
+	for i, column := range r.result.Columns {
+		names[i] = column.Name
+	}
+	return names, nil
+}
+
+func (r *mockRows) ColumnTypes() ([]pgtrino.ColumnType, error) {
+	types := make([]pgtrino.ColumnType, len(r.result.Columns))
+	for i, column := range r.result.Columns {
+		types[i] = mockColumnType{column}
+	}
+	return types, nil
+}
+
+func (r *mockRows) Next() bool {
+	r.index++
+	return r.index < r.result.RowCount
+}
+
+func (r *mockRows) Scan(dest ...any) error {
+	for i, value := range r.result.RowFunc(r.index) {
+		if scanner, ok := dest[i].(sql.Scanner); ok {
+			if err := scanner.Scan(value); err != nil {
+				return err
+			}
+			continue
+		}
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(value))
+	}
+	return nil
+}
+
+func (r *mockRows) Err() error   { return nil }
+func (r *mockRows) Close() error { return nil }
+
+// mockColumnType describes a column of mockRows.
+type mockColumnType struct {
+	column fakeColumn
+}
+
+func (c mockColumnType) Name() string             { return c.column.Name }
+func (c mockColumnType) DatabaseTypeName() string { return strings.ToUpper(c.column.Type) }
+func (c mockColumnType) ScanType() reflect.Type   { return c.column.ScanType }
+
+func (c mockColumnType) DecimalSize() (precision, scale int64, ok bool) {
+	return 0, 0, false
+}
+
+var _ = Describe("QueryExecutor", func() {
+	var (
+		executor *mockExecutor
+		tdb      *pgtrino.TrinoDB
+	)
+
+	BeforeEach(func() {
+		executor = newMockExecutor()
+		tdb = pgtrino.NewTrinoDBFromExecutor(executor, &config.Config{})
+	})
+
+	It("maps the Trino column types to Postgres OIDs", func() {
+		executor.On("SELECT * FROM orders", mockResult{
+			Columns: []fakeColumn{
+				col("id", "bigint"),
+				col("qty", "integer"),
+				col("name", "varchar"),
+				col("score", "double"),
+				col("paid", "boolean"),
+				col("at", "timestamp"),
+			},
+		})
+		columns, err := tdb.FetchColumns(context.Background(), "SELECT * FROM orders")
+		Expect(err).NotTo(HaveOccurred())
+		var oids []oid.Oid
+		for _, column := range columns {
+			oids = append(oids, column.Oid)
+		}
+		Expect(oids).To(Equal([]oid.Oid{oid.T_int8, oid.T_int4, oid.T_text, oid.T_float8, oid.T_bool, oid.T_timestamp}))
+		Expect(executor.Counts()).To(Equal(1))
+	})
+
+	It("streams every row of a result to the client", func() {
+		executor.On("SELECT id, name FROM events", mockResult{
+			Columns:  []fakeColumn{col("id", "bigint"), col("name", "varchar")},
+			RowCount: 1000,
+			RowFunc: func(i int) []any {
+				return []any{int64(i), fmt.Sprintf("event %d", i)}
+			},
+		})
+		server, err := pgtrino.NewServer(tdb)
+		Expect(err).NotTo(HaveOccurred())
+		served := serve(server)
+		defer served.Close()
+		db := served.Connect("hive")
+		defer db.Close()
+
+		rows, err := db.Query("SELECT id, name FROM events;")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		n := 0
+		for rows.Next() {
+			var (
+				id   int64
+				name string
+			)
+			Expect(rows.Scan(&id, &name)).To(Succeed())
+			Expect(id).To(BeEquivalentTo(n))
+			Expect(name).To(Equal(fmt.Sprintf("event %d", n)))
+			n++
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		Expect(n).To(Equal(1000))
+
+		queries, execs := executor.Counts()
+		Expect(queries).To(Equal(1))
+		Expect(execs).To(BeZero())
+	})
+
+	It("runs DML statements through ExecContext", func() {
+		executor.On("DELETE FROM events", mockResult{RowsAffected: 4})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		result, err := tdb.ExecContext(ctx, "DELETE FROM events")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RowsAffected()).To(Equal(int64(4)))
+		queries, execs := executor.Counts()
+		Expect([]int{queries, execs}).To(Equal([]int{0, 1}))
+	})
+
+	It("probes the Trino version through the executor", func() {
+		executor.On("SELECT version()", mockResult{
+			Columns:  []fakeColumn{col("_col0", "varchar")},
+			RowCount: 1,
+			RowFunc:  func(int) []any { return []any{"435"} },
+		})
+		Expect(tdb.ProbeVersion(context.Background())).To(Equal("435"))
+		queries, _ := executor.Counts()
+		Expect(queries).To(Equal(1))
+	})
+})
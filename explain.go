@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/lib/pq/oid"
+)
+
+var (
+	// explainOptions matches EXPLAIN (options) statement.
+	explainOptions = regexp.MustCompile(`(?is)^\s*EXPLAIN\s*\(([^()]*)\)\s*(.+)$`)
+	// formatJSONOption matches the FORMAT JSON option of EXPLAIN.
+	formatJSONOption = regexp.MustCompile(`(?is)^FORMAT\s+JSON$`)
+)
+
+// parseExplainJSON returns the Trino EXPLAIN (FORMAT JSON) query of an
+// EXPLAIN (FORMAT JSON) statement, or false if query is not one. The other
+// options, which are specific to Postgres, are dropped.
+func parseExplainJSON(query string) (string, bool) {
+	match := explainOptions.FindStringSubmatch(query)
+	if match == nil {
+		return "", false
+	}
+	for _, option := range strings.Split(match[1], ",") {
+		if formatJSONOption.MatchString(strings.TrimSpace(option)) {
+			return "EXPLAIN (FORMAT JSON) " + match[2], true
+		}
+	}
+	return "", false
+}
+
+// reshapeExplainJSON replaces the plan returned by Trino with a single
+// "QUERY PLAN" json value shaped as in Postgres, an array holding an object
+// with the plan under "Plan". Trino plans that are not a JSON document,
+// such as the text plans of older releases, are kept as a JSON string.
+func reshapeExplainJSON(result *queryResult) {
+	var lines []string
+	for _, row := range result.rows {
+		for _, value := range row {
+			if value != nil {
+				lines = append(lines, fmt.Sprint(value))
+			}
+		}
+	}
+	text := strings.Join(lines, "\n")
+	var plan any = json.RawMessage(text)
+	if !json.Valid([]byte(text)) {
+		plan = text
+	}
+	document, _ := json.Marshal([]map[string]any{{"Plan": plan}})
+	result.columns = wire.Columns{{Name: "QUERY PLAN", Oid: oid.T_json, TypeModifier: -1}}
+	result.rows = [][]any{{string(document)}}
+}
@@ -0,0 +1,85 @@
+package main_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EXPLAIN (FORMAT JSON)", func() {
+	var (
+		fake   *fakeTrino
+		server *testServer
+		db     *sql.DB
+	)
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		server = startServer(fake, &config.Config{})
+		db = server.Connect("hive")
+	})
+
+	AfterEach(func() {
+		Expect(db.Close()).To(Succeed())
+		server.Close()
+	})
+
+	explain := func(query string) []map[string]any {
+		rows, err := db.Query(query)
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		columns, err := rows.ColumnTypes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(columns).To(HaveLen(1))
+		Expect(columns[0].Name()).To(Equal("QUERY PLAN"))
+		Expect(columns[0].DatabaseTypeName()).To(Equal("JSON"))
+
+		Expect(rows.Next()).To(BeTrue())
+		var document string
+		Expect(rows.Scan(&document)).To(Succeed())
+		Expect(rows.Next()).To(BeFalse())
+		Expect(json.Valid([]byte(document))).To(BeTrue(), document)
+		var plan []map[string]any
+		Expect(json.Unmarshal([]byte(document), &plan)).To(Succeed())
+		return plan
+	}
+
+	It("returns the Trino JSON plan in a single json column", func() {
+		fake.On("EXPLAIN (FORMAT JSON) SELECT * FROM orders", fakeResult{
+			Columns: []fakeColumn{col("Query Plan", "varchar")},
+			Rows:    [][]driver.Value{{`{"0": {"name": "Output", "children": []}}`}},
+		})
+
+		plan := explain("EXPLAIN (ANALYZE false, FORMAT JSON) SELECT * FROM orders;")
+		Expect(plan).To(Equal([]map[string]any{{
+			"Plan": map[string]any{"0": map[string]any{"name": "Output", "children": []any{}}},
+		}}))
+		Expect(fake.LastQuery().Query).To(Equal("EXPLAIN (FORMAT JSON) SELECT * FROM orders"))
+	})
+
+	It("keeps plans that are not JSON as a JSON string", func() {
+		fake.On("EXPLAIN (FORMAT JSON) SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("Query Plan", "varchar")},
+			Rows:    [][]driver.Value{{"Fragment 0 [SINGLE]"}, {"    Output[_col0]"}},
+		})
+
+		plan := explain("explain (format json) SELECT 1;")
+		Expect(plan).To(Equal([]map[string]any{{"Plan": "Fragment 0 [SINGLE]\n    Output[_col0]"}}))
+	})
+
+	It("forwards other EXPLAIN statements unchanged", func() {
+		fake.On("EXPLAIN (TYPE LOGICAL) SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("Query Plan", "varchar")},
+			Rows:    [][]driver.Value{{"Output[_col0]"}},
+		})
+
+		var plan string
+		Expect(db.QueryRow("EXPLAIN (TYPE LOGICAL) SELECT 1;").Scan(&plan)).To(Succeed())
+		Expect(plan).To(Equal("Output[_col0]"))
+	})
+})
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"time"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+)
+
+// RegisteredTypes returns the scan types known to the extractor registry.
+func RegisteredTypes() []reflect.Type {
+	types := make([]reflect.Type, 0, len(scanTypeExtractors))
+	for t := range scanTypeExtractors {
+		types = append(types, t)
+	}
+	return types
+}
+
+// HasValueExtractor reports whether the registry can extract values of t.
+func HasValueExtractor(t reflect.Type) bool {
+	return scanTypeExtractors[t].value != nil
+}
+
+var (
+	TypeOid       = typeOid
+	Extract       = extract
+	ParseSet      = parseSet
+	TrinoDSN      = trinoDSN
+	TrinoAuth     = trinoAuth
+	Sleep         = sleep
+	ParsePgTypeof = parsePgTypeof
+	CommandTag    = commandTag
+	IsRetryable   = isRetryable
+
+	RewriteQuery = rewriteQuery
+
+	NewTrinoDBFromDB       = newTrinoDB
+	NewTrinoDBFromExecutor = newTrinoDBFromExecutor
+	ListenerOptions        = listenerOptions
+)
+
+// CircuitBreaker exposes the circuit breaker to tests.
+type CircuitBreaker = circuitBreaker
+
+// NewCircuitBreaker returns a circuit breaker reading the time from now.
+func NewCircuitBreaker(threshold int, cooldown time.Duration, now func() time.Time) *CircuitBreaker {
+	b := newCircuitBreaker(threshold, cooldown)
+	if b != nil {
+		b.now = now
+	}
+	return b
+}
+
+func (b *circuitBreaker) Allow() error     { return b.allow() }
+func (b *circuitBreaker) Record(err error) { b.record(err) }
+
+var ErrBreakerOpen = errBreakerOpen
+
+// Fetch runs a query and returns the number of rows read from Trino.
+func (tdb *TrinoDB) Fetch(ctx context.Context, query string) (int, error) {
+	result, err := tdb.fetch(ctx, SessionFromContext(ctx), query)
+	if err != nil {
+		return 0, err
+	}
+	return len(result.rows), nil
+}
+
+// FetchColumns runs a query and returns the columns described to the client.
+func (tdb *TrinoDB) FetchColumns(ctx context.Context, query string) (wire.Columns, error) {
+	result, err := tdb.fetch(ctx, SessionFromContext(ctx), query)
+	if err != nil {
+		return nil, err
+	}
+	return result.columns, nil
+}
+
+// ResultChecksum returns the debug checksum of the given rows.
+func ResultChecksum(rows [][]any) uint64 {
+	checksum := newResultChecksum(true)
+	for _, row := range rows {
+		checksum.add(row)
+	}
+	return checksum.Sum()
+}
+
+// SetBuild sets the version and commit of the build, as the linker does,
+// and returns a function restoring them.
+func SetBuild(v, c string) (restore func()) {
+	previousVersion, previousCommit := version, commit
+	version, commit = v, c
+	return func() { version, commit = previousVersion, previousCommit }
+}
+
+// ProbeVersion runs the startup check of the Trino version.
+func (tdb *TrinoDB) ProbeVersion(ctx context.Context) (string, error) {
+	return tdb.probeVersion(ctx)
+}
+
+// Allows reports whether the server accepts clients connecting from addr.
+func (s *Server) Allows(addr net.Addr) bool { return s.allows(addr) }
+
+// ClientConns returns the accepted client connections of the server.
+func (s *Server) ClientConns() []net.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var conns []net.Conn
+	for conn := range s.conns {
+		conns = append(conns, conn.Conn)
+	}
+	return conns
+}
+
+// WatchDrainSignal toggles the draining mode of servers on SIGUSR1.
+var WatchDrainSignal = watchDrainSignal
+
+// SetBacklog sets the accept queue length of a listener.
+var SetBacklog = setBacklog
+
+// RateLimiter exposes the rate limiter to tests.
+type RateLimiter = rateLimiter
+
+// NewRateLimiter returns a rate limiter reading the time from now.
+func NewRateLimiter(qps int, now func() time.Time) *RateLimiter {
+	l := newRateLimiter(qps)
+	if l != nil {
+		l.now = now
+	}
+	return l
+}
+
+func (l *rateLimiter) Allow(client string) error { return l.allow(client) }
+
+var ErrRateLimited = errRateLimited
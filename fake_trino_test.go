@@ -0,0 +1,485 @@
+package main_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	"github.com/lib/pq"
+	. "github.com/onsi/gomega"
+	trino "github.com/trinodb/trino-go-client/trino"
+)
+
+// fakeColumn describes a result column the way the Trino driver reports it.
+type fakeColumn struct {
+	Name     string
+	Type     string
+	ScanType reflect.Type
+}
+
+// fakeResult is the canned answer of the fake Trino server for a query.
+type fakeResult struct {
+	Columns []fakeColumn
+	Rows    [][]driver.Value
+	// RowCount and RowFunc generate a large result row by row instead of
+	// keeping it in Rows.
+	RowCount     int
+	RowFunc      func(i int) []driver.Value
+	RowsAffected int64
+	Err          error
+	// Delay is how long the query runs before returning its result.
+	Delay time.Duration
+	// NextErr is returned once, instead of the first row, by the first query
+	// receiving the result.
+	NextErr error
+}
+
+// fakeQuery records a query received by the fake Trino server.
+type fakeQuery struct {
+	Query string
+	Args  []driver.NamedValue
+}
+
+// Header returns the value of the named Trino header sent with the query.
+func (q fakeQuery) Header(name string) string {
+	for _, arg := range q.Args {
+		if arg.Name == name {
+			value, _ := arg.Value.(string)
+			return value
+		}
+	}
+	return ""
+}
+
+// fakeTrino is an in-memory stand-in for a Trino cluster, usable through
+// database/sql without starting a container.
+type fakeTrino struct {
+	mu      sync.Mutex
+	results map[string]fakeResult
+	queries []fakeQuery
+	// canceled are the queries canceled while running.
+	canceled []string
+	// CPUTime is the CPU time reported to progress callbacks.
+	CPUTime time.Duration
+	// committed are the statements without rows run so far, which take
+	// effect at once.
+	committed []string
+}
+
+func newFakeTrino() *fakeTrino {
+	return &fakeTrino{results: map[string]fakeResult{}}
+}
+
+// On registers the result returned for the given query text.
+func (f *fakeTrino) On(query string, result fakeResult) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[query] = result
+}
+
+// Queries returns all queries received so far.
+func (f *fakeTrino) Queries() []fakeQuery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeQuery(nil), f.queries...)
+}
+
+// Canceled returns the queries whose context was canceled while they ran.
+func (f *fakeTrino) Canceled() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.canceled...)
+}
+
+// Committed returns the statements without rows run so far, which take
+// effect at once.
+func (f *fakeTrino) Committed() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.committed...)
+}
+
+// LastQuery returns the most recently received query.
+func (f *fakeTrino) LastQuery() fakeQuery {
+	queries := f.Queries()
+	Expect(queries).NotTo(BeEmpty(), "no query reached the fake Trino server")
+	return queries[len(queries)-1]
+}
+
+func (f *fakeTrino) receive(query string, args []driver.NamedValue) (fakeResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries = append(f.queries, fakeQuery{Query: query, Args: args})
+	for _, arg := range args {
+		if updater, ok := arg.Value.(trino.ProgressUpdater); ok {
+			info := trino.QueryProgressInfo{QueryId: fmt.Sprintf("fake_%d", len(f.queries))}
+			info.QueryStats.CPUTimeMillis = int(f.CPUTime / time.Millisecond)
+			updater.Update(info)
+		}
+	}
+	result, ok := f.results[query]
+	if !ok {
+		return fakeResult{}, fmt.Errorf("fake trino: unexpected query %q", query)
+	}
+	if result.NextErr != nil {
+		retried := result
+		retried.NextErr = nil
+		f.results[query] = retried
+	}
+	return result, result.Err
+}
+
+// DB returns a database handle connected to the fake server.
+func (f *fakeTrino) DB() *sql.DB {
+	return sql.OpenDB(f)
+}
+
+func (f *fakeTrino) Connect(context.Context) (driver.Conn, error) { return &fakeConn{trino: f}, nil }
+func (f *fakeTrino) Driver() driver.Driver                        { return fakeDriver{trino: f} }
+
+type fakeDriver struct{ trino *fakeTrino }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{trino: d.trino}, nil }
+
+type fakeConn struct{ trino *fakeTrino }
+
+var errFakeUnsupported = errors.New("fake trino: operation not supported")
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errFakeUnsupported }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errFakeUnsupported }
+
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := c.trino.receive(query, args)
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(result.Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		c.trino.mu.Lock()
+		defer c.trino.mu.Unlock()
+		c.trino.canceled = append(c.trino.canceled, query)
+		return nil, ctx.Err()
+	}
+	return &fakeRows{result: result}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.trino.receive(query, args)
+	if err != nil {
+		return nil, err
+	}
+	c.trino.mu.Lock()
+	c.trino.committed = append(c.trino.committed, query)
+	c.trino.mu.Unlock()
+	return driver.RowsAffected(result.RowsAffected), nil
+}
+
+type fakeRows struct {
+	result fakeResult
+	index  int
+}
+
+func (r *fakeRows) Columns() []string {
+	names := make([]string, len(r.result.Columns))
+	for i, col := range r.result.Columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.result.NextErr != nil {
+		return r.result.NextErr
+	}
+	if r.result.RowFunc != nil {
+		if r.index >= r.result.RowCount {
+			return io.EOF
+		}
+		copy(dest, r.result.RowFunc(r.index))
+		r.index++
+		return nil
+	}
+	if r.index >= len(r.result.Rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.Rows[r.index])
+	r.index++
+	return nil
+}
+
+func (r *fakeRows) ColumnTypeScanType(index int) reflect.Type {
+	return r.result.Columns[index].ScanType
+}
+
+// ColumnTypeDatabaseTypeName mirrors the Trino driver, which drops the type
+// parameters except for map, array and row types.
+func (r *fakeRows) ColumnTypeDatabaseTypeName(index int) string {
+	typeName := r.result.Columns[index].Type
+	switch base := strings.SplitN(typeName, "(", 2)[0]; base {
+	case "map", "array", "row":
+	default:
+		typeName = base
+	}
+	return strings.ToUpper(typeName)
+}
+
+// ColumnTypePrecisionScale mirrors the Trino driver, which reports the
+// precision of time and timestamp types and the precision and scale of
+// decimal types.
+func (r *fakeRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	base, args, found := strings.Cut(r.result.Columns[index].Type, "(")
+	switch base {
+	case "time", "timestamp", "decimal":
+	default:
+		return 0, 0, false
+	}
+	if !found {
+		return 0, 0, false
+	}
+	args, _, _ = strings.Cut(args, ")")
+	params := strings.Split(args, ",")
+	precision, _ = strconv.ParseInt(strings.TrimSpace(params[0]), 10, 64)
+	if len(params) > 1 {
+		scale, _ = strconv.ParseInt(strings.TrimSpace(params[1]), 10, 64)
+	}
+	return precision, scale, true
+}
+
+// col builds a column using the scan type the Trino driver picks for typeName.
+func col(name, typeName string) fakeColumn {
+	var v any
+	switch strings.SplitN(typeName, "(", 2)[0] {
+	case "boolean":
+		v = sql.NullBool{}
+	case "tinyint", "smallint", "integer":
+		v = sql.NullInt32{}
+	case "bigint":
+		v = sql.NullInt64{}
+	case "real", "double":
+		v = sql.NullFloat64{}
+	case "date", "time", "time with time zone", "timestamp", "timestamp with time zone":
+		v = sql.NullTime{}
+	case "map":
+		v = trino.NullMap{}
+	case "array":
+		switch strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(typeName, "array("), ")"), "(", 2)[0] {
+		case "row", "array":
+			return fakeColumn{Name: name, Type: typeName, ScanType: reflect.TypeOf(new(any)).Elem()}
+		case "real", "double":
+			v = trino.NullSliceFloat64{}
+		case "date", "time", "time with time zone", "timestamp", "timestamp with time zone":
+			v = trino.NullSliceTime{}
+		default:
+			v = trino.NullSliceString{}
+		}
+	case "row":
+		return fakeColumn{Name: name, Type: typeName, ScanType: reflect.TypeOf(new(any)).Elem()}
+	default:
+		v = sql.NullString{}
+	}
+	return fakeColumn{Name: name, Type: typeName, ScanType: reflect.TypeOf(v)}
+}
+
+// testServer is a pg2trino wire server listening on a random local port.
+type testServer struct {
+	*pgtrino.Server
+	addr string
+}
+
+// startServer serves the Postgres wire protocol on top of the fake Trino server.
+func startServer(fake *fakeTrino, cfg *config.Config) *testServer {
+	server, err := pgtrino.NewServer(pgtrino.NewTrinoDBFromDB(fake.DB(), cfg))
+	Expect(err).NotTo(HaveOccurred())
+	return serve(server)
+}
+
+// serve serves the Postgres wire protocol of server on a random local port.
+func serve(server *pgtrino.Server) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	go func() { _ = server.Serve(listener) }()
+	return &testServer{Server: server, addr: listener.Addr().String()}
+}
+
+// DSN returns the connection string of the test server for the given database.
+func (s *testServer) DSN(database string, params ...string) string {
+	dsn := fmt.Sprintf("postgres://user@%s/%s?sslmode=disable", s.addr, database)
+	for _, param := range params {
+		dsn += "&" + param
+	}
+	return dsn
+}
+
+// Connect opens a Postgres connection to the test server for the given database.
+func (s *testServer) Connect(database string, params ...string) *sql.DB {
+	db, err := sql.Open("postgres", s.DSN(database, params...))
+	Expect(err).NotTo(HaveOccurred())
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+// ConnectWithNotices is like Connect, additionally collecting the notices
+// sent by the server.
+func (s *testServer) ConnectWithNotices(database string, params ...string) (*sql.DB, func() []string) {
+	connector, err := pq.NewConnector(s.DSN(database, params...))
+	Expect(err).NotTo(HaveOccurred())
+	var (
+		mu      sync.Mutex
+		notices []string
+	)
+	db := sql.OpenDB(pq.ConnectorWithNoticeHandler(connector, func(notice *pq.Error) {
+		mu.Lock()
+		defer mu.Unlock()
+		notices = append(notices, notice.Message)
+	}))
+	db.SetMaxOpenConns(1)
+	return db, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), notices...)
+	}
+}
+
+func (s *testServer) Close() {
+	Expect(s.Server.Close()).To(Succeed())
+}
+
+// trinoHTTP is a minimal Trino HTTP server answering every query with the
+// integer 1, or with the error set with Fail, and starting a transaction
+// for START TRANSACTION. It records the requests it receives.
+type trinoHTTP struct {
+	*httptest.Server
+	mu         sync.Mutex
+	headers    []http.Header
+	statements []trinoStatement
+	// failures are the errors queries fail with.
+	failures map[string]string
+	// transactions counts the started transactions.
+	transactions int
+	// delay is how long the server takes to answer a request.
+	delay time.Duration
+	// closed ends the delays when the server is closed.
+	closed chan struct{}
+}
+
+// trinoStatement is a statement received by trinoHTTP.
+type trinoStatement struct {
+	Query  string
+	Header http.Header
+}
+
+func newTrinoHTTP() *trinoHTTP {
+	t := &trinoHTTP{failures: map[string]string{}, closed: make(chan struct{})}
+	t.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		t.mu.Lock()
+		t.headers = append(t.headers, r.Header.Clone())
+		if r.Method == http.MethodPost {
+			t.statements = append(t.statements, trinoStatement{Query: string(body), Header: r.Header.Clone()})
+		}
+		number := len(t.statements)
+		delay := t.delay
+		t.mu.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		case <-t.closed:
+			return
+		}
+		switch r.Method {
+		case http.MethodDelete:
+			// The driver cancels the query when the rows are closed.
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPost:
+			// The query is accepted first and its result is fetched from
+			// the next URI, which numbers the statement.
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"id": "q1", "nextUri": "%s/v1/statement/q1/%d", "stats": {"state": "QUEUED"}}`, t.URL, number)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			var n int
+			_, _ = fmt.Sscanf(path.Base(r.URL.Path), "%d", &n)
+			query := t.Statements()[n-1].Query
+			t.mu.Lock()
+			failure := t.failures[query]
+			if query == "START TRANSACTION" {
+				t.transactions++
+				w.Header().Set("X-Trino-Started-Transaction-Id", fmt.Sprintf("tx%d", t.transactions))
+			}
+			t.mu.Unlock()
+			switch {
+			case failure != "":
+				_, _ = fmt.Fprintf(w, `{"id": "q1", "error": {"message": %q, "errorName": "NOT_SUPPORTED"}, "stats": {"state": "FAILED"}}`, failure)
+			case query == "START TRANSACTION" || query == "COMMIT" || query == "ROLLBACK":
+				_, _ = w.Write([]byte(`{"id": "q1", "stats": {"state": "FINISHED"}}`))
+			default:
+				_, _ = w.Write([]byte(`{"id": "q1", "columns": [{"name": "_col0", "type": "integer", "typeSignature": {"rawType": "integer"}}], "data": [[1]], "stats": {"state": "FINISHED"}}`))
+			}
+		}
+	}))
+	return t
+}
+
+// DSN returns the Trino connection string of the server.
+func (t *trinoHTTP) DSN() string {
+	return "http://user@" + t.Listener.Addr().String() + "?catalog=hive"
+}
+
+// Close ends the requests waiting for their delay and shuts the server
+// down.
+func (t *trinoHTTP) Close() {
+	close(t.closed)
+	t.Server.Close()
+}
+
+// SetDelay makes the server take delay to answer every request.
+func (t *trinoHTTP) SetDelay(delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.delay = delay
+}
+
+// Fail makes query fail with message.
+func (t *trinoHTTP) Fail(query, message string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures[query] = message
+}
+
+// Statements returns the statements received so far.
+func (t *trinoHTTP) Statements() []trinoStatement {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]trinoStatement(nil), t.statements...)
+}
+
+// Headers returns the headers of the requests received so far.
+func (t *trinoHTTP) Headers() []http.Header {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]http.Header(nil), t.headers...)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/lib/pq/oid"
+)
+
+var (
+	// pgSleepStatement matches SELECT pg_sleep(seconds).
+	pgSleepStatement = regexp.MustCompile(`(?is)^\s*SELECT\s+(?:pg_catalog\.)?pg_sleep\s*\(\s*'?(-?[0-9]*\.?[0-9]+)'?\s*\)\s*$`)
+	// lastQueryIDStatement matches SELECT pg2trino_last_query_id().
+	lastQueryIDStatement = regexp.MustCompile(`(?is)^\s*SELECT\s+pg2trino_last_query_id\s*\(\s*\)\s*$`)
+	// versionStatement matches SELECT pg2trino_version().
+	versionStatement = regexp.MustCompile(`(?is)^\s*SELECT\s+pg2trino_version\s*\(\s*\)\s*$`)
+	// selectKeyword matches the SELECT keyword starting a query.
+	selectKeyword = regexp.MustCompile(`(?is)^\s*SELECT\s+`)
+	// pgTypeofCall matches the start of a pg_typeof(expression) select item.
+	pgTypeofCall = regexp.MustCompile(`(?is)^(?:pg_catalog\.)?pg_typeof\s*\(`)
+	// fromKeyword matches the FROM keyword ending a select list.
+	fromKeyword = regexp.MustCompile(`(?i)^FROM\b`)
+	// userFunction matches the Postgres functions returning the user of the
+	// session.
+	userFunction = regexp.MustCompile(`(?i)^(?:current_user|session_user|current_role|user)$`)
+)
+
+// parsePgSleep returns the delay of a SELECT pg_sleep(seconds) statement,
+// or false if query is not one.
+func parsePgSleep(query string) (time.Duration, bool) {
+	match := pgSleepStatement.FindStringSubmatch(query)
+	if match == nil {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// pgSleep answers SELECT pg_sleep(seconds), which Trino lacks, by sleeping
+// in the proxy and returning a single void value.
+func pgSleep(delay time.Duration) wire.PreparedStatements {
+	handle := func(ctx context.Context, writer wire.DataWriter, _ []wire.Parameter) error {
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+		if err := writer.Row([]any{""}); err != nil {
+			return err
+		}
+		return writer.Complete("SELECT 1")
+	}
+	columns := wire.Columns{{Name: "pg_sleep", Oid: oid.T_void, TypeModifier: -1}}
+	return wire.Prepared(wire.NewStatement(handle, wire.WithColumns(columns)))
+}
+
+// sleep waits for the given delay, failing with query_canceled when ctx is
+// done first.
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		err := fmt.Errorf("canceling statement due to user request: %w", ctx.Err())
+		return psqlerr.WithCode(err, codes.QueryCanceled)
+	}
+}
+
+// buildVersion answers SELECT pg2trino_version() with the version and
+// commit of the proxy build, for users reporting issues.
+func buildVersion() wire.PreparedStatements {
+	return textRows([]string{"pg2trino_version"}, [][]string{{buildInfo()}}, "SELECT 1")
+}
+
+// lastQueryID answers SELECT pg2trino_last_query_id() with the Trino query
+// id of the last statement the session ran on Trino, or NULL if it has not
+// run any.
+func lastQueryID(ctx context.Context) wire.PreparedStatements {
+	var id any
+	if last := SessionFromContext(ctx).LastQueryID(); last != "" {
+		id = last
+	}
+	handle := func(_ context.Context, writer wire.DataWriter, _ []wire.Parameter) error {
+		if err := writer.Row([]any{id}); err != nil {
+			return err
+		}
+		return writer.Complete("SELECT 1")
+	}
+	columns := wire.Columns{{Name: "pg2trino_last_query_id", Oid: oid.T_text, TypeModifier: -1}}
+	return wire.Prepared(wire.NewStatement(handle, wire.WithColumns(columns)))
+}
+
+// currentUser answers a SELECT of current_user, session_user, current_role
+// and user, without a FROM clause, with the user the client connected as.
+// Trino would return its own user, which may differ from the Postgres login
+// that ORMs compare it to. It returns false for any other query.
+func currentUser(ctx context.Context, query string) (wire.PreparedStatements, bool) {
+	match := selectKeyword.FindStringIndex(query)
+	if match == nil {
+		return nil, false
+	}
+	items := splitList(query[match[1]:])
+	if len(items) == 0 {
+		return nil, false
+	}
+	user := wire.AuthenticatedUsername(ctx)
+	columns := make(wire.Columns, len(items))
+	row := make([]any, len(items))
+	for i, item := range items {
+		name := ""
+		if expr, alias, ok := splitAlias(item); ok && userFunction.MatchString(expr) {
+			item, name = expr, alias
+		}
+		if !userFunction.MatchString(item) {
+			return nil, false
+		}
+		if name == "" {
+			name = strings.ToLower(item)
+			if name == "user" {
+				name = "current_user"
+			}
+		}
+		columns[i] = wire.Column{Name: name, Oid: oid.T_name, TypeModifier: -1}
+		row[i] = user
+	}
+	return localRows(columns, [][]any{row}, "SELECT 1"), true
+}
+
+// parsePgTypeof returns the query selecting the arguments of a SELECT
+// statement whose select items are all pg_typeof(expression) calls, or false
+// if query is not one.
+func parsePgTypeof(query string) (string, bool) {
+	match := selectKeyword.FindStringIndex(query)
+	if match == nil {
+		return "", false
+	}
+	list, rest := query[match[1]:], ""
+	if i := topLevelIndex(list, fromKeyword); i >= 0 {
+		list, rest = list[:i], " "+list[i:]
+	}
+	items := splitList(list)
+	if len(items) == 0 {
+		return "", false
+	}
+	args := make([]string, len(items))
+	for i, item := range items {
+		call := pgTypeofCall.FindStringIndex(item)
+		if call == nil || !strings.HasSuffix(item, ")") {
+			return "", false
+		}
+		arg := item[call[1] : len(item)-1]
+		if topLevelIndex(arg, closingParen) >= 0 {
+			// The call ends before the item, as in pg_typeof(a) + 1.
+			return "", false
+		}
+		args[i] = strings.TrimSpace(arg)
+	}
+	return "SELECT " + strings.Join(args, ", ") + rest, true
+}
+
+// closingParen matches a closing parenthesis.
+var closingParen = regexp.MustCompile(`^\)`)
+
+// topLevelIndex returns the index of the first match of pattern in s outside
+// of quotes and parentheses, or -1 if there is none. An unbalanced closing
+// parenthesis counts as outside of parentheses.
+func topLevelIndex(s string, pattern *regexp.Regexp) int {
+	var (
+		quote byte
+		depth int
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			continue
+		case c == '\'' || c == '"':
+			quote = c
+			continue
+		}
+		// Matches starting with a word character must start a word.
+		wordStart := !isIdentifierByte(c) || i == 0 || !isIdentifierByte(s[i-1])
+		if depth == 0 && wordStart && pattern.MatchString(s[i:]) {
+			return i
+		}
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+	}
+	return -1
+}
+
+// isIdentifierByte reports whether c may be part of an unquoted identifier.
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// reportTypeNames replaces the values of a result by the Postgres names of
+// their column types, answering the pg_typeof calls whose arguments the
+// result holds.
+func reportTypeNames(result *queryResult) {
+	names := make([]any, len(result.columns))
+	for i, column := range result.columns {
+		names[i] = pgTypeName(column.Oid)
+		result.columns[i] = wire.Column{Name: "pg_typeof", Oid: oid.T_regtype, TypeModifier: -1}
+	}
+	for _, row := range result.rows {
+		copy(row, names)
+	}
+}
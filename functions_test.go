@@ -0,0 +1,217 @@
+package main_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Functions", func() {
+	var (
+		fake   *fakeTrino
+		server *testServer
+	)
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		server = startServer(fake, &config.Config{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("pg_sleep", func() {
+		It("sleeps in the proxy and returns a single void value", func() {
+			db := server.Connect("memory")
+			defer db.Close()
+
+			start := time.Now()
+			var value string
+			Expect(db.QueryRow("SELECT pg_sleep(0.2);").Scan(&value)).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+			Expect(value).To(BeEmpty())
+			Expect(fake.Queries()).To(BeEmpty())
+		})
+
+		It("stops sleeping when the statement is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+
+			start := time.Now()
+			err := pgtrino.Sleep(ctx, time.Minute)
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(psqlerr.GetCode(err)).To(BeEquivalentTo("57014"))
+		})
+	})
+
+	Describe("pg2trino_last_query_id", func() {
+		It("returns the Trino query id of the last statement of the session", func() {
+			fake.On("SELECT 1", fakeResult{
+				Columns: []fakeColumn{col("_col0", "integer")},
+				Rows:    [][]driver.Value{{int64(1)}},
+			})
+			db := server.Connect("memory")
+			defer db.Close()
+
+			var id sql.NullString
+			Expect(db.QueryRow("SELECT pg2trino_last_query_id();").Scan(&id)).To(Succeed())
+			Expect(id.Valid).To(BeFalse())
+
+			var value int
+			Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+			Expect(db.QueryRow("SELECT pg2trino_last_query_id();").Scan(&id)).To(Succeed())
+			Expect(id.String).NotTo(BeEmpty())
+			Expect(id.String).To(Equal("fake_1"))
+			Expect(fake.Queries()).To(HaveLen(1))
+		})
+	})
+
+	Describe("pg2trino_history", func() {
+		It("returns the most recent statements of the session in order", func() {
+			for _, query := range []string{"SELECT 1", "SELECT 2", "SELECT 3", "SELECT 4"} {
+				fake.On(query, fakeResult{
+					Columns: []fakeColumn{col("_col0", "integer")},
+					Rows:    [][]driver.Value{{int64(1)}},
+				})
+			}
+			history := startServer(fake, &config.Config{HistorySize: 3})
+			defer history.Close()
+			db := history.Connect("memory")
+			defer db.Close()
+
+			var value int
+			for _, query := range []string{"SELECT 1;", "SELECT 2;", "SELECT 3;", "SELECT 4;"} {
+				Expect(db.QueryRow(query).Scan(&value)).To(Succeed())
+			}
+
+			rows, err := db.Query("SELECT * FROM pg2trino_history();")
+			Expect(err).NotTo(HaveOccurred())
+			defer rows.Close()
+			var queries []string
+			for rows.Next() {
+				var (
+					start time.Time
+					query string
+				)
+				Expect(rows.Scan(&start, &query)).To(Succeed())
+				Expect(start).To(BeTemporally("~", time.Now(), time.Minute))
+				queries = append(queries, query)
+			}
+			Expect(rows.Err()).NotTo(HaveOccurred())
+			Expect(queries).To(Equal([]string{"SELECT 2", "SELECT 3", "SELECT 4"}))
+		})
+
+		It("is empty when the history is disabled", func() {
+			db := server.Connect("memory")
+			defer db.Close()
+
+			rows, err := db.Query("SELECT pg2trino_history();")
+			Expect(err).NotTo(HaveOccurred())
+			defer rows.Close()
+			Expect(rows.Next()).To(BeFalse())
+			Expect(fake.Queries()).To(BeEmpty())
+		})
+	})
+
+	Describe("current_user", func() {
+		It("returns the connecting user for current_user and session_user", func() {
+			local := startServer(fake, &config.Config{LocalCurrentUser: true})
+			defer local.Close()
+			db := local.Connect("memory")
+			defer db.Close()
+
+			var current, session string
+			Expect(db.QueryRow("SELECT current_user;").Scan(&current)).To(Succeed())
+			Expect(current).To(Equal("user"))
+			Expect(db.QueryRow("select SESSION_USER;").Scan(&session)).To(Succeed())
+			Expect(session).To(Equal("user"))
+
+			rows, err := db.Query(`SELECT current_user, session_user AS "Login";`)
+			Expect(err).NotTo(HaveOccurred())
+			defer rows.Close()
+			columns, err := rows.Columns()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(columns).To(Equal([]string{"current_user", "Login"}))
+			Expect(rows.Next()).To(BeTrue())
+			Expect(rows.Scan(&current, &session)).To(Succeed())
+			Expect([]string{current, session}).To(Equal([]string{"user", "user"}))
+			Expect(fake.Queries()).To(BeEmpty())
+		})
+
+		It("forwards current_user to Trino when disabled", func() {
+			fake.On("SELECT current_user", fakeResult{
+				Columns: []fakeColumn{col("_col0", "varchar")},
+				Rows:    [][]driver.Value{{"trino"}},
+			})
+			db := server.Connect("memory")
+			defer db.Close()
+
+			var user string
+			Expect(db.QueryRow("SELECT current_user;").Scan(&user)).To(Succeed())
+			Expect(user).To(Equal("trino"))
+		})
+	})
+
+	Describe("pg_typeof", func() {
+		It("returns the Postgres type names of the selected columns", func() {
+			fake.On("SELECT id, price, created_at, name FROM orders", fakeResult{
+				Columns: []fakeColumn{
+					col("id", "integer"),
+					col("price", "decimal(10,2)"),
+					col("created_at", "timestamp with time zone"),
+					col("name", "varchar"),
+				},
+				Rows: [][]driver.Value{
+					{int64(1), "9.50", time.Now(), "tea"},
+					{int64(2), nil, nil, nil},
+				},
+			})
+			db := server.Connect("memory")
+			defer db.Close()
+
+			rows, err := db.Query("SELECT pg_typeof(id), pg_typeof(price), pg_catalog.pg_typeof(created_at), pg_typeof(name) FROM orders;")
+			Expect(err).NotTo(HaveOccurred())
+			defer rows.Close()
+			columns, err := rows.Columns()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(columns).To(Equal([]string{"pg_typeof", "pg_typeof", "pg_typeof", "pg_typeof"}))
+			var names [][]string
+			for rows.Next() {
+				row := make([]string, 4)
+				Expect(rows.Scan(&row[0], &row[1], &row[2], &row[3])).To(Succeed())
+				names = append(names, row)
+			}
+			Expect(rows.Err()).NotTo(HaveOccurred())
+			expected := []string{"integer", "numeric", "timestamp with time zone", "text"}
+			Expect(names).To(Equal([][]string{expected, expected}))
+		})
+
+		It("selects the arguments of the calls", func() {
+			query, ok := pgtrino.ParsePgTypeof("SELECT pg_typeof(coalesce(a, b)), pg_typeof(extract(year FROM ts)) FROM t WHERE x = ')'")
+			Expect(ok).To(BeTrue())
+			Expect(query).To(Equal("SELECT coalesce(a, b), extract(year FROM ts) FROM t WHERE x = ')'"))
+		})
+
+		It("leaves other select lists alone", func() {
+			for _, query := range []string{
+				"SELECT pg_typeof(a) + 1 FROM t",
+				"SELECT pg_typeof(a), b FROM t",
+				"SELECT pg_typeof(a) || pg_typeof(b)",
+				"SELECT my_pg_typeof(a)",
+			} {
+				_, ok := pgtrino.ParsePgTypeof(query)
+				Expect(ok).To(BeFalse(), query)
+			}
+		})
+	})
+})
@@ -8,6 +8,8 @@ require (
 	github.com/fsnotify/fsnotify v1.7.0 // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/hashicorp/go-uuid v1.0.3 // indirect
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20200714003250-2b9c44734f2b // indirect
 	github.com/jcmturner/gofork v1.7.6 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/nxadm/tail v1.4.11 // indirect
@@ -42,7 +44,7 @@ require (
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/jackc/pgx/v5 v5.0.3 // indirect
+	github.com/jackc/pgx/v5 v5.0.3
 	github.com/klauspost/compress v1.16.0 // indirect
 	github.com/lib/pq v1.10.9
 	github.com/lufia/plan9stats v0.0.0-20211012122336-39d0f177ccd0 // indirect
@@ -78,3 +80,10 @@ require (
 	google.golang.org/grpc v1.58.3 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
 )
+
+// psql-wire v0.11.1 writes -1 as the type modifier of every column it
+// describes and reports every session as idle. The copy in
+// third_party/psql-wire writes Column.TypeModifier instead, so time(p)
+// columns report their precision, and sends the transaction status of
+// buffer.Writer.Status, so clients see their transaction blocks.
+replace github.com/jeroenrinzema/psql-wire => ./third_party/psql-wire
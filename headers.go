@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	trino "github.com/trinodb/trino-go-client/trino"
+)
+
+// headerClients numbers the HTTP clients registered with the Trino driver,
+// as the driver registry is global.
+var headerClients atomic.Int64
+
+// headerTransport sends a fixed set of headers with every request.
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		req.Header[name] = values
+	}
+	return t.base.RoundTrip(req)
+}
+
+// transactionTransport records the ids of the Trino transactions started
+// by START TRANSACTION statements, which the Trino driver drops.
+type transactionTransport struct {
+	base    http.RoundTripper
+	started *startedTransactions
+}
+
+func (t transactionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		if id := resp.Header.Get("X-Trino-Started-Transaction-Id"); id != "" {
+			t.started.record(id)
+		}
+	}
+	return resp, err
+}
+
+// parseExtraHeaders parses the "Name: value" headers of
+// TRINO_EXTRA_HEADERS.
+func parseExtraHeaders(items []string) (http.Header, error) {
+	header := http.Header{}
+	for _, item := range items {
+		name, value, ok := strings.Cut(item, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !validHeaderName(name) || !validHeaderValue(value) {
+			return nil, fmt.Errorf("invalid TRINO_EXTRA_HEADERS header %q: expected Name: value", item)
+		}
+		header.Add(textproto.CanonicalMIMEHeaderKey(name), value)
+	}
+	return header, nil
+}
+
+// validHeaderName reports whether name is an HTTP header name token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c >= 0x80 || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue reports whether value may be sent as an HTTP header
+// value, which excludes control characters other than tab.
+func validHeaderValue(value string) bool {
+	for _, c := range value {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// hasCustomClient reports whether dsn selects a registered HTTP client with
+// its custom_client parameter.
+func hasCustomClient(dsn string) bool {
+	u, err := url.Parse(dsn)
+	return err == nil && u.Query().Has("custom_client")
+}
+
+// withClient registers an HTTP client sending header with every Trino
+// request, giving up on requests Trino takes longer than timeout to answer,
+// unless it is zero, and recording the transactions it starts in started,
+// and returns dsn using it. The timeout applies to the transport, as the
+// driver overrides the timeout of the client with the deadline of each
+// query.
+func withClient(dsn string, header http.Header, timeout time.Duration, started *startedTransactions) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid TRINO_DSN: %w", err)
+	}
+	query := u.Query()
+	if query.Has("custom_client") {
+		return "", fmt.Errorf("TRINO_EXTRA_HEADERS, TRINO_HTTP_TIMEOUT and TRINO_AUTH=jwt cannot be combined with the custom_client of TRINO_DSN")
+	}
+	key := fmt.Sprintf("pg2trino-headers-%d", headerClients.Add(1))
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+	var base http.RoundTripper = transport
+	if len(header) > 0 {
+		base = headerTransport{base: transport, header: header}
+	}
+	client := &http.Client{Transport: transactionTransport{base: base, started: started}}
+	if err := trino.RegisterCustomClient(key, client); err != nil {
+		return "", err
+	}
+	query.Set("custom_client", key)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,74 @@
+package main_test
+
+import (
+	"time"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Extra Trino headers", func() {
+	It("sends the configured headers with every Trino request", func() {
+		trino := newTrinoHTTP()
+		defer trino.Close()
+		tdb, err := pgtrino.NewTrinoDB(&config.Config{
+			TrinoDSN:          trino.DSN(),
+			TrinoExtraHeaders: []string{"X-Gateway-Token: secret", "x-team:  analytics"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer tdb.DB.Close()
+
+		var value int
+		Expect(tdb.DB.QueryRow("SELECT 1").Scan(&value)).To(Succeed())
+		Expect(value).To(Equal(1))
+
+		headers := trino.Headers()
+		Expect(len(headers)).To(BeNumerically(">=", 2))
+		for _, header := range headers {
+			Expect(header.Get("X-Gateway-Token")).To(Equal("secret"))
+			Expect(header.Get("X-Team")).To(Equal("analytics"))
+		}
+	})
+
+	It("times out Trino requests after TRINO_HTTP_TIMEOUT", func() {
+		trino := newTrinoHTTP()
+		defer trino.Close()
+		tdb, err := pgtrino.NewTrinoDB(&config.Config{
+			TrinoDSN:         trino.DSN(),
+			TrinoHTTPTimeout: 200 * time.Millisecond,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer tdb.DB.Close()
+
+		var value int
+		Expect(tdb.DB.QueryRow("SELECT 1").Scan(&value)).To(Succeed())
+
+		trino.SetDelay(5 * time.Second)
+		start := time.Now()
+		err = tdb.DB.QueryRow("SELECT 1").Scan(&value)
+		Expect(err).To(MatchError(ContainSubstring("timeout awaiting response headers")))
+		Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+	})
+
+	It("rejects invalid headers", func() {
+		for _, invalid := range []string{"X-Token", "X Token: secret", ": secret", "X-Token: se\ncret"} {
+			_, err := pgtrino.NewTrinoDB(&config.Config{
+				TrinoHost:         "trino",
+				TrinoPort:         "8080",
+				TrinoExtraHeaders: []string{invalid},
+			})
+			Expect(err).To(MatchError(ContainSubstring("invalid TRINO_EXTRA_HEADERS header")), invalid)
+		}
+	})
+
+	It("cannot be combined with a custom client in TRINO_DSN", func() {
+		_, err := pgtrino.NewTrinoDB(&config.Config{
+			TrinoDSN:          "http://user@trino:8080?custom_client=mine",
+			TrinoExtraHeaders: []string{"X-Token: secret"},
+		})
+		Expect(err).To(MatchError(ContainSubstring("custom_client")))
+	})
+})
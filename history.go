@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/lib/pq/oid"
+)
+
+// historyQueryBytes is the length statements are truncated to in the
+// history, bounding its memory together with the history size.
+const historyQueryBytes = 4096
+
+// historyStatement matches SELECT pg2trino_history() and
+// SELECT * FROM pg2trino_history().
+var historyStatement = regexp.MustCompile(`(?is)^\s*SELECT\s+(?:\*\s+FROM\s+)?pg2trino_history\s*\(\s*\)\s*$`)
+
+// historyEntry is a statement run by a session.
+type historyEntry struct {
+	start time.Time
+	query string
+}
+
+// statementHistory is a ring buffer of the last statements of a session.
+type statementHistory struct {
+	entries []historyEntry
+	// next is the index of the oldest entry once the buffer is full.
+	next int
+}
+
+// add records a statement, dropping the oldest one when the history holds
+// size statements already. A size of zero disables the history.
+func (h *statementHistory) add(entry historyEntry, size int) {
+	if size <= 0 {
+		return
+	}
+	if len(entry.query) > historyQueryBytes {
+		entry.query = entry.query[:historyQueryBytes]
+	}
+	if len(h.entries) < size {
+		h.entries = append(h.entries, entry)
+		return
+	}
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+}
+
+// list returns the recorded statements from the oldest to the most recent.
+func (h *statementHistory) list() []historyEntry {
+	entries := append([]historyEntry(nil), h.entries[h.next:]...)
+	return append(entries, h.entries[:h.next]...)
+}
+
+// recordStatement adds a statement to the history of the session.
+func (s *Session) recordStatement(query string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history.add(historyEntry{start: time.Now(), query: query}, s.Config().HistorySize)
+}
+
+// History returns the last statements the session ran, from the oldest to
+// the most recent.
+func (s *Session) History() []historyEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.history.list()
+}
+
+// history answers SELECT pg2trino_history() with the last statements of the
+// session and the time they started, from the oldest to the most recent.
+func history(ctx context.Context) wire.PreparedStatements {
+	session := SessionFromContext(ctx)
+	entries := session.History()
+	rows := make([][]any, len(entries))
+	for i, entry := range entries {
+		rows[i] = []any{formatTimestamptz(entry.start, session.Location()), entry.query}
+	}
+	columns := wire.Columns{
+		{Name: "query_start", Oid: oid.T_timestamptz, TypeModifier: -1},
+		{Name: "query", Oid: oid.T_text, TypeModifier: -1},
+	}
+	return localRows(columns, rows, fmt.Sprintf("SELECT %d", len(rows)))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq/oid"
+	trino "github.com/trinodb/trino-go-client/trino"
+)
+
+// varcharMapType matches the Trino MAP(VARCHAR, VARCHAR) type, as reported
+// by DatabaseTypeName.
+var varcharMapType = regexp.MustCompile(`(?i)^MAP\(\s*VARCHAR(?:\(\d+\))?\s*,\s*VARCHAR(?:\(\d+\))?\s*\)$`)
+
+// hstoreExtractor sends MAP(VARCHAR, VARCHAR) values in the hstore text
+// format, e.g. "a"=>"1", "b"=>NULL, for clients using hstore. As hstore is
+// an extension without a fixed OID, the values are sent as text.
+var hstoreExtractor = extractorFor(oid.T_text, func(v trino.NullMap) any {
+	return formatHstore(v.Map)
+})
+
+// formatHstore renders m in the hstore text format. Keys are sorted by
+// length first, the order Postgres stores hstore keys in.
+func formatHstore(m map[string]any) string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		value := "NULL"
+		if v := m[key]; v != nil {
+			value = quoteHstore(fmt.Sprint(v))
+		}
+		pairs[i] = quoteHstore(key) + "=>" + value
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// quoteHstore double-quotes an hstore key or value, escaping backslashes
+// and double quotes with a backslash.
+func quoteHstore(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+var (
+	// showIntrospection matches Trino's SHOW FUNCTIONS and SHOW SESSION
+	// statements, with their optional LIKE pattern.
+	showIntrospection = regexp.MustCompile(`(?is)^\s*SHOW\s+(?:FUNCTIONS|SESSION)\b`)
+	// informationSchemaQuery matches the queries reading a table of
+	// information_schema, capturing the catalog qualifying it.
+	informationSchemaQuery = regexp.MustCompile(`(?is)\bFROM\s+((?:(?:"(?:[^"]|"")*"|[a-z_][a-z0-9_]*)\s*\.\s*)?)information_schema\s*\.`)
+	// showTablesStatement matches Trino's SHOW TABLES statement.
+	showTablesStatement = regexp.MustCompile(`(?is)^\s*SHOW\s+TABLES\b`)
+	// showColumnsStatement matches Trino's SHOW COLUMNS and DESCRIBE table
+	// statements, but not DESCRIBE INPUT and DESCRIBE OUTPUT.
+	showColumnsStatement = regexp.MustCompile(`(?is)^\s*(?:SHOW\s+COLUMNS|DESCRIBE\s+(?:"|[a-z_][a-z0-9_]*\s*(?:\.|$)))`)
+)
+
+// reservedColumnNames renames the introspection columns whose names are
+// reserved words in Postgres, so clients can select them without quoting.
+var reservedColumnNames = map[string]string{
+	"default": "default_value",
+}
+
+// isIntrospection reports whether query is SHOW FUNCTIONS or SHOW SESSION,
+// through which clients discover the Trino functions and session
+// properties.
+func isIntrospection(query string) bool {
+	return showIntrospection.MatchString(query)
+}
+
+// missingSchemaResult returns the empty result answering an introspection
+// query that failed with err because its schema does not exist, or false
+// if EMPTY_MISSING_SCHEMAS is off or the query or error is another one.
+// The result has the columns the query would have returned, as clients
+// expect them even without rows: those of Trino's SHOW TABLES and SHOW
+// COLUMNS, or for the queries reading information_schema, those of the
+// same query on the information_schema of the session catalog, which
+// always exists.
+func (tdb *TrinoDB) missingSchemaResult(ctx context.Context, session *Session, query string, err error) (wire.PreparedStatements, bool) {
+	if !tdb.Config.EmptyMissingSchemas || psqlerr.GetCode(err) != codes.InvalidSchemaName {
+		return nil, false
+	}
+	tag := commandTag(query, 0)
+	switch {
+	case showTablesStatement.MatchString(query):
+		return textRows([]string{"Table"}, nil, tag), true
+	case showColumnsStatement.MatchString(query):
+		return textRows([]string{"Column", "Type", "Extra", "Comment"}, nil, tag), true
+	}
+	match := informationSchemaQuery.FindStringSubmatchIndex(query)
+	if match == nil {
+		return nil, false
+	}
+	probe := fmt.Sprintf("SELECT * FROM (\n%s%s\n) AS missing WHERE false", query[:match[2]], query[match[3]:])
+	result, err := tdb.execute(ctx, session, probe)
+	if err != nil {
+		return nil, false
+	}
+	return localRows(result.columns, nil, tag), true
+}
+
+// renameIntrospectionColumns renames the columns of SHOW FUNCTIONS and SHOW
+// SESSION, such as "Return Type" and "Default", to stable Postgres-friendly
+// names, such as return_type and default_value.
+func renameIntrospectionColumns(columns wire.Columns) {
+	renameDescribeColumns(columns)
+	for i := range columns {
+		if name, ok := reservedColumnNames[columns[i].Name]; ok {
+			columns[i].Name = name
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// writeCertificate writes a self-signed certificate for localhost and its
+// key to dir.
+func writeCertificate(dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	Expect(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)).To(Succeed())
+	Expect(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)).To(Succeed())
+	return certFile, keyFile
+}
+
+var _ = Describe("Listeners", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "pg2trino")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("serves queries on a plaintext and a TLS listener sharing one Trino connection", func() {
+		fake := newFakeTrino()
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		certFile, keyFile := writeCertificate(dir)
+		cfg := &config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile}
+		tdb := pgtrino.NewTrinoDBFromDB(fake.DB(), cfg)
+
+		var dsns []string
+		for _, listener := range []config.Listener{{Addr: "127.0.0.1:0"}, {Addr: "127.0.0.1:0", TLS: true}} {
+			options, err := pgtrino.ListenerOptions(cfg, listener)
+			Expect(err).NotTo(HaveOccurred())
+			server, err := pgtrino.NewServer(tdb, options...)
+			Expect(err).NotTo(HaveOccurred())
+			served := serve(server)
+			defer served.Close()
+			sslmode := "disable"
+			if listener.TLS {
+				sslmode = "require"
+			}
+			dsns = append(dsns, fmt.Sprintf("postgres://user@%s/memory?sslmode=%s", served.addr, sslmode))
+		}
+
+		for _, dsn := range dsns {
+			db, err := sql.Open("postgres", dsn)
+			Expect(err).NotTo(HaveOccurred())
+			var value int
+			Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed(), dsn)
+			Expect(value).To(Equal(1))
+			Expect(db.Close()).To(Succeed())
+		}
+		Expect(fake.Queries()).To(HaveLen(2))
+	})
+
+	Describe("the sslmode of clients of a TLS listener", func() {
+		var fake *fakeTrino
+
+		BeforeEach(func() {
+			fake = newFakeTrino()
+			fake.On("SELECT 1", fakeResult{
+				Columns: []fakeColumn{col("_col0", "integer")},
+				Rows:    [][]driver.Value{{int64(1)}},
+			})
+		})
+
+		// serveTLS serves a TLS listener with the given configuration.
+		serveTLS := func(cfg *config.Config) *testServer {
+			cfg.TLSCertFile, cfg.TLSKeyFile = writeCertificate(dir)
+			options, err := pgtrino.ListenerOptions(cfg, config.Listener{Addr: "127.0.0.1:0", TLS: true})
+			Expect(err).NotTo(HaveOccurred())
+			server, err := pgtrino.NewServer(pgtrino.NewTrinoDBFromDB(fake.DB(), cfg), options...)
+			Expect(err).NotTo(HaveOccurred())
+			return serve(server)
+		}
+
+		// query runs SELECT 1 with the given sslmode on a TLS listener.
+		query := func(cfg *config.Config, sslmode string) error {
+			served := serveTLS(cfg)
+			defer served.Close()
+			db, err := sql.Open("postgres", fmt.Sprintf("postgres://user@%s/memory?sslmode=%s", served.addr, sslmode))
+			Expect(err).NotTo(HaveOccurred())
+			defer db.Close()
+			var value int
+			return db.QueryRow("SELECT 1;").Scan(&value)
+		}
+
+		It("upgrades clients with sslmode=require", func() {
+			Expect(query(&config.Config{TLSRequired: true}, "require")).To(Succeed())
+			Expect(query(&config.Config{}, "require")).To(Succeed())
+		})
+
+		It("keeps clients with sslmode=disable plaintext unless TLS is required", func() {
+			Expect(query(&config.Config{}, "disable")).To(Succeed())
+
+			err := query(&config.Config{TLSRequired: true}, "disable")
+			Expect(err).To(BeAssignableToTypeOf(&pq.Error{}))
+			Expect(err.(*pq.Error).Code).To(BeEquivalentTo("28000"))
+			Expect(err.(*pq.Error).Message).To(ContainSubstring("TLS is required"))
+			Expect(fake.Queries()).To(HaveLen(1))
+		})
+
+		It("refuses plaintext clients before authenticating them when TLS is required", func() {
+			served := serveTLS(&config.Config{TLSRequired: true})
+			defer served.Close()
+			client := startWire(served.addr, "memory")
+			defer client.conn.Close()
+			typ, body := client.Read()
+			Expect(string(typ)).To(Equal("E"))
+			Expect(string(body)).To(ContainSubstring("28000"))
+		})
+	})
+
+	It("fails to configure a TLS listener without a certificate", func() {
+		cfg := &config.Config{TLSCertFile: filepath.Join(dir, "missing.pem"), TLSKeyFile: filepath.Join(dir, "missing.key")}
+		_, err := pgtrino.ListenerOptions(cfg, config.Listener{Addr: "127.0.0.1:6432", TLS: true})
+		Expect(err).To(MatchError(ContainSubstring("failed to load the TLS certificate of 127.0.0.1:6432")))
+	})
+})
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/lib/pq/oid"
+)
+
+// commandComplete returns a statement that produces no rows and completes
+// with the given command tag.
+func commandComplete(tag string) wire.PreparedStatements {
+	handle := func(_ context.Context, writer wire.DataWriter, _ []wire.Parameter) error {
+		return writer.Complete(tag)
+	}
+	return wire.Prepared(wire.NewStatement(handle))
+}
+
+// textRows returns a statement answering a query locally with the given text
+// columns and rows, completing with the given command tag.
+func textRows(names []string, rows [][]string, tag string) wire.PreparedStatements {
+	columns := make(wire.Columns, len(names))
+	for i, name := range names {
+		columns[i] = wire.Column{Name: name, Oid: oid.T_text, TypeModifier: -1}
+	}
+	values := make([][]any, len(rows))
+	for i, row := range rows {
+		values[i] = make([]any, len(row))
+		for j, value := range row {
+			values[i][j] = value
+		}
+	}
+	return localRows(columns, values, tag)
+}
+
+// localRows returns a statement answering a query locally with the given
+// columns and rows, completing with the given command tag.
+func localRows(columns wire.Columns, rows [][]any, tag string) wire.PreparedStatements {
+	handle := func(_ context.Context, writer wire.DataWriter, _ []wire.Parameter) error {
+		for _, row := range rows {
+			if err := writer.Row(row); err != nil {
+				return err
+			}
+		}
+		return writer.Complete(tag)
+	}
+	return wire.Prepared(wire.NewStatement(handle, wire.WithColumns(columns)))
+}
@@ -2,131 +2,286 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"database/sql/driver"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"reflect"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"pg2trino/config"
 
 	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
 	"github.com/lib/pq/oid"
-	trino "github.com/trinodb/trino-go-client/trino"
 )
 
+var _ QueryExecutor = (*TrinoDB)(nil)
+
 // TrinoDB encapsulates the Trino database connection.
 type TrinoDB struct {
-	DB *sql.DB
+	DB     *sql.DB
+	Config *config.Config
+
+	// executor runs the statements of clients, which is DB outside of
+	// tests.
+	executor QueryExecutor
+	breaker  *circuitBreaker
+	limiter  *rateLimiter
+	// typeOverrides are the Postgres types of TYPE_OVERRIDES by Trino type
+	// name.
+	typeOverrides map[string]oid.Oid
+	// names caches the Trino names resolved with CASE_INSENSITIVE_NAMES.
+	names nameCache
+	// transactions receives the ids of the Trino transactions started by
+	// the HTTP client of NewTrinoDB. It is nil with other clients.
+	transactions *startedTransactions
 }
 
 // NewTrinoDB creates a new TrinoDB instance, initializing the Trino database connection.
 func NewTrinoDB(config *config.Config) (*TrinoDB, error) {
-	dsn := fmt.Sprintf(
-		"http://user@%s?catalog=%s&schema=%s",
-		net.JoinHostPort(config.TrinoHost, config.TrinoPort),
-		config.TrinoCatalog,
-		config.TrinoSchema,
-	)
+	dsn, err := trinoDSN(config)
+	if err != nil {
+		return nil, err
+	}
+	header, err := parseExtraHeaders(config.TrinoExtraHeaders)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseTypeOverrides(config.TypeOverrides); err != nil {
+		return nil, err
+	}
+	if dsn, err = trinoAuth(dsn, config, header); err != nil {
+		return nil, err
+	}
+	// The custom_client of TRINO_DSN is kept unless it has to be replaced,
+	// giving up transactions.
+	var transactions *startedTransactions
+	if len(header) > 0 || config.TrinoHTTPTimeout > 0 || !hasCustomClient(dsn) {
+		transactions = &startedTransactions{}
+		if dsn, err = withClient(dsn, header, config.TrinoHTTPTimeout, transactions); err != nil {
+			return nil, err
+		}
+	}
 	db, err := sql.Open("trino", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open connection to Trino: %w", err)
 	}
-	return &TrinoDB{DB: db}, nil
+	tdb := newTrinoDB(db, config)
+	tdb.transactions = transactions
+	return tdb, nil
+}
+
+// newTrinoDB returns a TrinoDB sending queries to db.
+func newTrinoDB(db *sql.DB, config *config.Config) *TrinoDB {
+	tdb := newTrinoDBFromExecutor(sqlExecutor{db}, config)
+	tdb.DB = db
+	return tdb
+}
+
+// newTrinoDBFromExecutor returns a TrinoDB running the statements of
+// clients with executor.
+func newTrinoDBFromExecutor(executor QueryExecutor, config *config.Config) *TrinoDB {
+	// Invalid overrides are rejected by NewTrinoDB.
+	typeOverrides, _ := parseTypeOverrides(config.TypeOverrides)
+	return &TrinoDB{
+		Config:        config,
+		executor:      executor,
+		breaker:       newCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown),
+		limiter:       newRateLimiter(config.RateLimitQPS),
+		typeOverrides: typeOverrides,
+	}
+}
+
+// trinoDSN returns the Trino connection string: the configured TRINO_DSN
+// verbatim, or one assembled from the host, port, catalog and schema, whose
+// parameters are escaped so special characters cannot corrupt it.
+func trinoDSN(config *config.Config) (string, error) {
+	if config.TrinoDSN == "" {
+		dsn := url.URL{
+			Scheme:   "http",
+			User:     url.User("user"),
+			Host:     net.JoinHostPort(config.TrinoHost, config.TrinoPort),
+			RawQuery: url.Values{"catalog": {config.TrinoCatalog}, "schema": {config.TrinoSchema}}.Encode(),
+		}
+		return dsn.String(), nil
+	}
+	u, err := url.Parse(config.TrinoDSN)
+	if err != nil {
+		// The parse error quotes the DSN, which may hold the Trino password.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", fmt.Errorf("invalid TRINO_DSN: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid TRINO_DSN %q: expected http[s]://user@host:port?params", u.Redacted())
+	}
+	return config.TrinoDSN, nil
+}
+
+// QueryContext runs a query on Trino, guarded by the circuit breaker.
+func (tdb *TrinoDB) QueryContext(ctx context.Context, query string, args ...any) (Rows, error) {
+	if err := tdb.breaker.allow(); err != nil {
+		return nil, err
+	}
+	rows, err := tdb.executor.QueryContext(ctx, query, args...)
+	tdb.breaker.record(err)
+	return rows, err
+}
+
+// NewServer creates a Postgres wire server answering queries through the
+// given TrinoDB. Servers for several listeners can share the same TrinoDB.
+func NewServer(trinodb *TrinoDB, options ...wire.OptionFn) (*Server, error) {
+	allowlist, err := parseAllowlist(trinodb.Config.ClientAllowlist)
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{
+		allowlist:      allowlist,
+		logConnections: trinodb.Config.LogConnections,
+		keepAlive:      trinodb.Config.TCPKeepAlive,
+		backlog:        trinodb.Config.ListenBacklog,
+		tlsRequired:    trinodb.Config.TLSRequired,
+		conns:          map[*trackedConn]struct{}{},
+	}
+	authenticate := func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (context.Context, error) {
+		if err := server.checkTLS(writer.Writer); err != nil {
+			_ = wire.ErrorCode(writer, err)
+			return ctx, err
+		}
+		return acceptClient(ctx, writer, reader)
+	}
+	newSession := func(ctx context.Context) (context.Context, error) {
+		ctx, err := trinodb.newSession(ctx)
+		if err != nil {
+			return ctx, err
+		}
+		server.attach(SessionFromContext(ctx))
+		return ctx, nil
+	}
+	options = append([]wire.OptionFn{
+		wire.SessionAuthStrategy(authenticate),
+		wire.Session(newSession),
+		wire.Statements(sessionStatements{}),
+		wire.Portals(sessionPortals{}),
+		wire.Version(serverVersion),
+	}, options...)
+	wireServer, err := wire.NewServer(trinodb.handler, options...)
+	if err != nil {
+		return nil, err
+	}
+	server.Server = wireServer
+	return server, nil
+}
+
+// listenerOptions returns the wire server options of a listener, which
+// serve the configured certificate on TLS listeners.
+func listenerOptions(config *config.Config, listener config.Listener) ([]wire.OptionFn, error) {
+	if !listener.TLS {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the TLS certificate of %s: %w", listener.Addr, err)
+	}
+	return []wire.OptionFn{wire.Certificates([]tls.Certificate{cert})}, nil
 }
 
 func main() {
+	printVersion := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+	if *printVersion {
+		fmt.Println(buildInfo())
+		return
+	}
+	log.Printf("Starting %s", buildInfo())
 	config := config.NewConfig()
 	trinodb, err := NewTrinoDB(config)
 	if err != nil {
 		log.Fatalf("Failed to initialize TrinoDB: %s", err)
 	}
 	defer trinodb.DB.Close()
-	log.Println("PostgreSQL server is up and running at [127.0.0.1:5432]")
-	if err = wire.ListenAndServe("127.0.0.1:5432", trinodb.handler); err != nil {
-		log.Panic(err)
-	}
-}
-
-func convertTrinoTypeToOid(trinoType reflect.Type) oid.Oid {
-	switch trinoType {
-	case reflect.TypeOf(sql.NullBool{}):
-		return oid.T_bool
-	case reflect.TypeOf(sql.NullString{}):
-		return oid.T_text
-	case reflect.TypeOf(sql.NullInt32{}):
-		return oid.T_int4
-	case reflect.TypeOf(sql.NullInt64{}):
-		return oid.T_int8
-	case reflect.TypeOf(sql.NullFloat64{}):
-		return oid.T_float8
-	case reflect.TypeOf(sql.NullTime{}):
-		return oid.T_timestamp
-	default:
-		// For other types, return type as text/string
-		return oid.T_text
-	}
-}
-
-func trinoValue(v any) any {
-	switch val := v.(type) {
-	case trino.NullSliceBool:
-		return val.SliceBool
-	case trino.NullSliceString:
-		return val.SliceString
-	case trino.NullSliceInt64:
-		return val.SliceInt64
-	case trino.NullSliceFloat64:
-		return val.SliceFloat64
-	case trino.NullSliceTime:
-		return val.SliceTime
-	case trino.NullSliceMap:
-		return val.SliceMap
-	case trino.NullSlice2Bool:
-		return val.Slice2Bool
-	case trino.NullSlice2String:
-		return val.Slice2String
-	case trino.NullSlice2Int64:
-		return val.Slice2Int64
-	case trino.NullSlice2Float64:
-		return val.Slice2Float64
-	case trino.NullSlice2Time:
-		return val.Slice2Time
-	case trino.NullSlice2Map:
-		return val.Slice2Map
-	case trino.NullSlice3Bool:
-		return val.Slice3Bool
-	case trino.NullSlice3String:
-		return val.Slice3String
-	case trino.NullSlice3Int64:
-		return val.Slice3Int64
-	case trino.NullSlice3Float64:
-		return val.Slice3Float64
-	case trino.NullSlice3Time:
-		return val.Slice3Time
-	case trino.NullSlice3Map:
-		return val.Slice3Map
-	default:
-		return nil
+	if _, err := trinodb.probeVersion(context.Background()); err != nil {
+		if config.MinTrinoVersion > 0 {
+			log.Fatalf("Trino version check failed: %s", err)
+		}
+		log.Printf("Trino version check failed: %s", err)
 	}
-}
-
-// Get value of Null* types.
-func trinoTypeValue(nullstar any) any {
-	switch val := nullstar.(type) {
-	case sql.NullBool, sql.NullString, sql.NullInt32, sql.NullInt64, sql.NullFloat64, sql.NullTime:
-		value, err := val.(interface {
-			Value() (driver.Value, error)
-		}).Value()
+	servers := make([]*Server, len(config.Listeners))
+	for i, listener := range config.Listeners {
+		options, err := listenerOptions(config, listener)
 		if err != nil {
-			return nil
+			log.Fatalf("Failed to configure listener: %s", err)
+		}
+		servers[i], err = NewServer(trinodb, options...)
+		if err != nil {
+			log.Fatalf("Failed to create server: %s", err)
 		}
-		return value
-	default:
-		// For other types, return the value as a string
-		return fmt.Sprintf("%v", trinoValue(nullstar))
 	}
+	if config.MetricsAddr != "" {
+		// expvar serves the metrics at /debug/vars.
+		go func() {
+			log.Printf("Serving metrics at [%s]", config.MetricsAddr)
+			if err := http.ListenAndServe(config.MetricsAddr, nil); err != nil {
+				log.Printf("Failed to serve metrics: %s", err)
+			}
+		}()
+	}
+	stopDraining := watchDrainSignal(servers)
+	defer stopDraining()
+	stopped := make(chan struct{})
+	go func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-ctx.Done()
+		log.Println("Shutting down, draining connections")
+		var wg sync.WaitGroup
+		for _, server := range servers {
+			wg.Add(1)
+			go func(server *Server) {
+				defer wg.Done()
+				server.Shutdown(config.ShutdownTimeout)
+			}(server)
+		}
+		wg.Wait()
+		close(stopped)
+	}()
+	served := make(chan error, len(servers))
+	for i, server := range servers {
+		listener := config.Listeners[i]
+		if listener.TLS {
+			log.Printf("PostgreSQL server is up and running at [%s] with TLS", listener.Addr)
+		} else {
+			log.Printf("PostgreSQL server is up and running at [%s]", listener.Addr)
+		}
+		go func(server *Server, addr string) {
+			served <- server.ListenAndServe(addr)
+		}(server, listener.Addr)
+	}
+	for range servers {
+		if err := <-served; err != nil {
+			log.Panic(err)
+		}
+	}
+	<-stopped
 }
 
 // CheckValidProperty checks if a struct has a property "Valid" of type bool.
@@ -145,53 +300,518 @@ func CheckValidProperty(s any) (bool, bool) {
 	return false, false
 }
 
-// scanValuesToValues converts a slice of pointers to sql.Null* types to a slice of their values.
-func scanValuesToValues(scanValues []interface{}) []any {
-	values := make([]any, len(scanValues))
-	for i, v := range scanValues {
-		rv := reflect.ValueOf(v)
-		if rv.Kind() == reflect.Ptr && !rv.IsNil() {
-			val := rv.Elem().Interface() // Safely dereference the pointer
-			hasValidProperty, valid := CheckValidProperty(val)
-			if hasValidProperty {
-				if valid {
-					values[i] = trinoTypeValue(val)
-					continue
-				}
+// rowChunk is the number of rows whose values are allocated at once.
+const rowChunk = 256
+
+const (
+	// noValidField marks scan values without a Valid field, sent as NULL.
+	noValidField = -1
+	// nilIsNull marks interface{} scan values, which are NULL when nil.
+	nilIsNull = -2
+)
+
+// rowScanner converts the rows scanned into sql.Null* values to the values
+// sent to the client. The scan destinations are reused for every row and the
+// row values are allocated in chunks, so reading a row only allocates the
+// extracted values themselves.
+type rowScanner struct {
+	scanValues []any
+	// valid is the index of the Valid field of every scan value, or one of
+	// noValidField and nilIsNull if its type has none.
+	valid      []int
+	extractors []typeExtractor
+	session    *Session
+	chunk      []any
+	names      []string
+	// maxValueBytes is the size limit of a single value. Zero disables the
+	// limit.
+	maxValueBytes int
+	// maxFieldBytes is the size the values of the columns marked in
+	// truncate are truncated to. Zero disables truncation.
+	maxFieldBytes int
+	truncate      []bool
+	// truncated counts the values truncated so far.
+	truncated int
+}
+
+func newRowScanner(columnTypes []ColumnType, extractors []typeExtractor, session *Session) *rowScanner {
+	scanner := &rowScanner{
+		scanValues: GetScanValues(columnTypes),
+		valid:      make([]int, len(columnTypes)),
+		extractors: extractors,
+		session:    session,
+		names:      make([]string, len(columnTypes)),
+	}
+	scanner.maxValueBytes = session.Config().MaxValueBytes
+	scanner.maxFieldBytes = session.Config().MaxFieldBytes
+	scanner.truncate = make([]bool, len(columnTypes))
+	for i, col := range columnTypes {
+		scanner.names[i] = col.Name()
+		scanner.truncate[i] = truncatedTypes[col.DatabaseTypeName()]
+		scanner.valid[i] = noValidField
+		switch t := scanType(col); t.Kind() {
+		case reflect.Interface:
+			scanner.valid[i] = nilIsNull
+		case reflect.Struct:
+			if field, ok := t.FieldByName("Valid"); ok && field.Type.Kind() == reflect.Bool {
+				scanner.valid[i] = field.Index[0]
 			}
 		}
-		values[i] = nil
 	}
-	return values
+	return scanner
+}
+
+// scan reads the current row of rows.
+func (r *rowScanner) scan(rows Rows) ([]any, error) {
+	if err := rows.Scan(r.scanValues...); err != nil {
+		return nil, err
+	}
+	n := len(r.scanValues)
+	if len(r.chunk) < n {
+		r.chunk = make([]any, rowChunk*n)
+	}
+	values := r.chunk[:n:n]
+	r.chunk = r.chunk[n:]
+	for i, v := range r.scanValues {
+		if r.isNull(i, v) {
+			values[i] = nil
+			continue
+		}
+		values[i] = r.extractors[i].value(v, r.session)
+		values[i] = r.truncateField(i, values[i])
+		if err := r.checkSize(i, values[i]); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// truncatedTypes are the Trino types whose values MAX_FIELD_BYTES
+// truncates.
+var truncatedTypes = map[string]bool{"VARCHAR": true, "CHAR": true, "VARBINARY": true}
+
+// truncateField truncates the string value of column i to maxFieldBytes,
+// without splitting a UTF-8 sequence.
+func (r *rowScanner) truncateField(i int, value any) any {
+	s, ok := value.(string)
+	if !ok || r.maxFieldBytes <= 0 || !r.truncate[i] || len(s) <= r.maxFieldBytes {
+		return value
+	}
+	end := r.maxFieldBytes
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	r.truncated++
+	return s[:end]
+}
+
+// checkSize fails values of column i exceeding the size limit, which would
+// otherwise break the DataRow message they are sent in.
+func (r *rowScanner) checkSize(i int, value any) error {
+	if r.maxValueBytes <= 0 {
+		return nil
+	}
+	var size int
+	switch v := value.(type) {
+	case string:
+		size = len(v)
+	case []byte:
+		size = len(v)
+	}
+	if size <= r.maxValueBytes {
+		return nil
+	}
+	err := fmt.Errorf("value of column %q is %d bytes, exceeding the maximum of %d bytes", r.names[i], size, r.maxValueBytes)
+	return psqlerr.WithCode(err, codes.ProgramLimitExceeded)
+}
+
+// isNull reports whether the scan value v of column i holds NULL.
+func (r *rowScanner) isNull(i int, v any) bool {
+	switch r.valid[i] {
+	case noValidField:
+		return true
+	case nilIsNull:
+		return reflect.ValueOf(v).Elem().IsNil()
+	default:
+		return !reflect.ValueOf(v).Elem().Field(r.valid[i]).Bool()
+	}
 }
 
 // GetScanValues prepares a slice of pointers to sql.Null* types based on the provided column types.
-func GetScanValues(columnTypes []*sql.ColumnType) []interface{} {
+func GetScanValues(columnTypes []ColumnType) []interface{} {
 	scanValues := make([]interface{}, len(columnTypes))
 	for i, col := range columnTypes {
-		scanValues[i] = reflect.New(col.ScanType()).Interface()
+		scanValues[i] = reflect.New(scanType(col)).Interface()
 	}
 	return scanValues
 }
 
-func createColumns(columns []*sql.ColumnType) wire.Columns {
+// scanType returns the type a column is scanned into. Columns without a
+// scan type are scanned into interface{} and sent as text.
+func scanType(col ColumnType) reflect.Type {
+	if t := col.ScanType(); t != nil {
+		return t
+	}
+	return typeOf[any]()
+}
+
+// columnExtractors returns the extractor of every result column, reporting
+// the Postgres type of TYPE_OVERRIDES for overridden Trino types. With
+// STRICT_TYPES set, columns of Trino types without a Postgres mapping or an
+// override are an error instead of being sent as text.
+func columnExtractors(columns []ColumnType, cfg *config.Config, overrides map[string]oid.Oid) ([]typeExtractor, error) {
+	extractors := make([]typeExtractor, len(columns))
+	for i, col := range columns {
+		extractors[i] = columnExtractor(col, cfg)
+		typ, overridden := overrides[col.DatabaseTypeName()]
+		if overridden {
+			extractors[i] = overrideExtractor(extractors[i], typ)
+		}
+		if cfg.StrictTypes && !overridden && !isMappedType(col.DatabaseTypeName()) {
+			err := fmt.Errorf("column %q has the unsupported Trino type %s", col.Name(), strings.ToLower(col.DatabaseTypeName()))
+			return nil, psqlerr.WithCode(err, codes.FeatureNotSupported)
+		}
+	}
+	return extractors, nil
+}
+
+// columnExtractor returns the extractor of a result column by its Trino
+// type.
+func columnExtractor(col ColumnType, cfg *config.Config) typeExtractor {
+	if _, scale, ok := col.DecimalSize(); ok && col.DatabaseTypeName() == "DECIMAL" {
+		return decimalExtractor(scale)
+	}
+	if cfg.HstoreMaps && varcharMapType.MatchString(col.DatabaseTypeName()) {
+		return hstoreExtractor
+	}
+	return lookupExtractor(col.DatabaseTypeName(), scanType(col))
+}
+
+func createColumns(columns []ColumnType, extractors []typeExtractor) wire.Columns {
 	var wireColumns wire.Columns
-	for _, col := range columns {
-		scanType := col.ScanType()
-		oid := convertTrinoTypeToOid(scanType)
+	for i, col := range columns {
+		modifier := typeModifier(col, extractors[i].oid)
 		wireColumns = append(wireColumns, wire.Column{
-			Table: 0,
-			Name:  col.Name(),
-			Oid:   oid,
+			Name:         col.Name(),
+			Oid:          extractors[i].oid,
+			TypeModifier: modifier,
 		})
 	}
 	return wireColumns
 }
 
-func (tdb *TrinoDB) handler(_ context.Context, query string) (wire.PreparedStatements, error) {
+// hasSpatialColumns reports whether any of columns has a Trino spatial
+// type.
+func hasSpatialColumns(columns []ColumnType) bool {
+	for _, col := range columns {
+		if _, ok := geometryTypes[col.DatabaseTypeName()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchWKT runs a read query with spatial columns, which the Trino driver
+// cannot read, again selecting their WKT text instead. The spatial columns
+// are sent as text, or as the type of GEOMETRY_OID when it is set, such as
+// the geometry OID of PostGIS-aware clients.
+func (tdb *TrinoDB) fetchWKT(ctx context.Context, session *Session, query string, columns []ColumnType, headers ...any) (*queryResult, error) {
+	if !isRetryable(query) {
+		for _, col := range columns {
+			if _, ok := geometryTypes[col.DatabaseTypeName()]; ok {
+				err := fmt.Errorf("column %q has the Trino type %s, which cannot be read; select it with ST_AsText instead", col.Name(), strings.ToLower(col.DatabaseTypeName()))
+				return nil, psqlerr.WithCode(err, codes.FeatureNotSupported)
+			}
+		}
+	}
+	result, err := tdb.fetch(ctx, session, wktQuery(query, columns), headers...)
+	if err != nil {
+		return nil, err
+	}
+	for i, col := range columns {
+		if _, ok := geometryTypes[col.DatabaseTypeName()]; ok && tdb.Config.GeometryOid != 0 {
+			result.columns[i].Oid = oid.Oid(tdb.Config.GeometryOid)
+		}
+	}
+	result.tag = commandTag(query, int64(len(result.rows)))
+	return result, nil
+}
+
+// timingMessage describes the execution time of a query, including the CPU
+// time reported by Trino when known.
+func timingMessage(elapsed, cpu time.Duration) string {
+	message := fmt.Sprintf("Time: %.3f ms", float64(elapsed)/float64(time.Millisecond))
+	if cpu > 0 {
+		message += fmt.Sprintf(" (Trino CPU time: %.3f ms)", float64(cpu)/float64(time.Millisecond))
+	}
+	return message
+}
+
+// logSlowQuery logs a query whose execution took longer than the slow
+// query threshold.
+func (tdb *TrinoDB) logSlowQuery(query string, elapsed time.Duration) {
+	threshold := tdb.Config.SlowQueryThreshold
+	if threshold <= 0 || elapsed <= threshold {
+		return
+	}
+	log.Printf("Slow query: duration=%s query=%q", elapsed.Round(time.Millisecond), query)
+}
+
+// trimStatement removes the trailing semicolons of a statement, which Trino
+// rejects, along with the whitespace around them. Statements without them
+// are left untouched.
+func trimStatement(query string) string {
+	return strings.TrimRightFunc(query, func(r rune) bool {
+		return r == ';' || unicode.IsSpace(r)
+	})
+}
+
+// recoverPanic turns a panic of the statement into an internal error
+// returned to the client through err, logging its stack trace, so that the
+// connection and the server survive it.
+func recoverPanic(query string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Printf("Panic while running %q: %v\n%s", query, r, debug.Stack())
+	*err = psqlerr.WithCode(fmt.Errorf("internal error: %v", r), codes.Internal)
+}
+
+func (tdb *TrinoDB) handler(ctx context.Context, query string) (statements wire.PreparedStatements, err error) {
+	defer recoverPanic(query, &err)
+	if limit := tdb.Config.MaxStatementBytes; limit > 0 && len(query) > limit {
+		err := fmt.Errorf("statement of %d bytes exceeds the maximum of %d bytes", len(query), limit)
+		return nil, psqlerr.WithCode(err, codes.ProgramLimitExceeded)
+	}
 	log.Println("Incoming SQL query:", query)
-	query = query[:len(query)-1]
-	rows, err := tdb.DB.Query(query)
+	session := SessionFromContext(ctx)
+	if err := tdb.limiter.allow(session.ClientAddr()); err != nil {
+		return nil, err
+	}
+	if parts := splitStatements(query); len(parts) > 1 {
+		// Like Postgres, only simple queries may hold several statements,
+		// which are run as the query is handled.
+		if session.parsing() {
+			return nil, wire.NewErrMultipleCommandsStatements()
+		}
+		return tdb.statements(ctx, parts), nil
+	}
+	return tdb.statement(ctx, query)
+}
+
+// statement prepares a single statement of a query.
+func (tdb *TrinoDB) statement(ctx context.Context, query string) (wire.PreparedStatements, error) {
+	if !tdb.Config.KeepSemicolons {
+		query = trimStatement(query)
+	}
+	session := SessionFromContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	session.busy(cancel)
+	defer session.idle(tdb.Config.IdleInTransactionTimeout)
+	if historyStatement.MatchString(query) {
+		return history(ctx), nil
+	}
+	session.recordStatement(query)
+	if tag, ok, err := transactionTag(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		return tdb.transaction(ctx, tag)
+	}
+	if what, ok := parseDiscard(query); ok {
+		return discard(ctx, what)
+	}
+	if name, value, ok := parseSetSession(query); ok {
+		return setSessionProperty(ctx, name, value), nil
+	}
+	if name, ok := parseResetSession(query); ok {
+		return resetSessionProperty(ctx, name), nil
+	}
+	if name, value, ok := parseSet(query); ok {
+		return setting(ctx, name, value)
+	}
+	if name, ok := parseReset(query); ok {
+		return resetSetting(ctx, name)
+	}
+	if role, ok := parseRole(query); ok {
+		return setRole(ctx, role), nil
+	}
+	if statement, ok := setConfig(ctx, query); ok {
+		return statement, nil
+	}
+	if statement, ok, err := settingLookup(ctx, query); ok {
+		return statement, err
+	}
+	if tdb.Config.LocalCurrentUser {
+		if statement, ok := currentUser(ctx, query); ok {
+			return statement, nil
+		}
+	}
+	if statement, ok := pgTypeQuery(query); ok {
+		return statement, nil
+	}
+	if statement, ok, err := tdb.pgDatabaseQuery(ctx, query); ok {
+		return statement, err
+	}
+	if tdb.Config.LocalConstants {
+		if statement, ok := constantQuery(query); ok {
+			return statement, nil
+		}
+	}
+	if versionStatement.MatchString(query) {
+		return buildVersion(), nil
+	}
+	if lastQueryIDStatement.MatchString(query) {
+		return lastQueryID(ctx), nil
+	}
+	if delay, ok := parsePgSleep(query); ok {
+		return pgSleep(delay), nil
+	}
+	if name, statement, ok := parsePrepare(query); ok {
+		return prepare(ctx, name, statement)
+	}
+	if name, ok := parseDeallocate(query); ok {
+		return deallocate(ctx, name)
+	}
+	if name, ok := parseClose(query); ok {
+		return closeCursor(name)
+	}
+	if c, ok, err := parseCopyFrom(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		return tdb.copyFromStdin(c), nil
+	}
+	var args []any
+	kind, name, describe := parseDescribe(query)
+	if describe {
+		header, err := session.preparedHeader(name)
+		if err != nil {
+			return nil, err
+		}
+		query = "DESCRIBE " + kind + " " + name
+		args = append(args, header)
+	}
+	if name, params, ok := parseExecute(query); ok {
+		bound, err := session.bind(name, params)
+		if err != nil {
+			return nil, err
+		}
+		query = bound
+	}
+	arguments, typeNames := parsePgTypeof(query)
+	if typeNames {
+		query = arguments
+	}
+	explain, explainJSON := parseExplainJSON(query)
+	if explainJSON {
+		query = explain
+	}
+	if err := checkSelectInto(query); err != nil {
+		return nil, err
+	}
+	if err := checkSequenceFunctions(query); err != nil {
+		return nil, err
+	}
+	query = rewriteQuery(query, tdb.Config)
+	result, err := tdb.execute(ctx, session, query, args...)
+	if err != nil {
+		if statement, ok := tdb.missingSchemaResult(ctx, session, query, err); ok {
+			return statement, nil
+		}
+		return nil, err
+	}
+	session.setLastQueryID(result.progress.ID())
+	tdb.logSlowQuery(query, result.elapsed)
+	if describe {
+		renameDescribeColumns(result.columns)
+	}
+	if isIntrospection(query) {
+		renameIntrospectionColumns(result.columns)
+	}
+	if typeNames {
+		reportTypeNames(result)
+	}
+	if explainJSON {
+		reshapeExplainJSON(result)
+	}
+	rows := result.rows
+	if len(result.columns) == 0 {
+		// psql-wire describes a statement without columns as returning no
+		// rows, so the empty rows of such a result are only counted in its
+		// command tag.
+		rows = nil
+	}
+	handle := func(_ context.Context, writer wire.DataWriter, _ []wire.Parameter) (err error) {
+		defer recoverPanic(query, &err)
+		checksum := newResultChecksum(tdb.Config.DebugChecksums)
+		for _, row := range rows {
+			if err = writer.Row(row); err != nil {
+				return err
+			}
+			checksum.add(row)
+		}
+		checksum.log(query)
+		if result.truncated > 0 {
+			message := fmt.Sprintf("%d values were truncated to %d bytes", result.truncated, tdb.Config.MaxFieldBytes)
+			if err := session.Notice(message); err != nil {
+				return err
+			}
+		}
+		if tdb.Config.TimingNotices {
+			if err := session.Notice(timingMessage(result.elapsed, result.progress.CPUTime())); err != nil {
+				return err
+			}
+		}
+		return writer.Complete(result.tag)
+	}
+	return wire.Prepared(wire.NewStatement(handle, wire.WithColumns(result.columns))), nil
+}
+
+// queryResult is the complete result of a query read from Trino.
+type queryResult struct {
+	columns wire.Columns
+	rows    [][]any
+	// tag is the Postgres command tag the statement completes with.
+	tag      string
+	progress *queryProgress
+	elapsed  time.Duration
+	// truncated is the number of values truncated to MAX_FIELD_BYTES.
+	truncated int
+}
+
+// execute runs a query on Trino and reads its complete result. With
+// CASE_INSENSITIVE_NAMES, the catalog and schema names qualifying its
+// tables are first resolved to the names Trino knows them by. As no rows
+// have been sent to the client yet, a read query whose connection went bad
+// while reading the rows is retried once on a fresh connection. Other
+// statements are not retried, as they may have been applied.
+func (tdb *TrinoDB) execute(ctx context.Context, session *Session, query string, args ...any) (*queryResult, error) {
+	if tdb.Config.CaseInsensitiveNames {
+		query = tdb.resolveQueryNames(ctx, session, query)
+	}
+	if isDML(query) || isDDL(query) {
+		result, err := tdb.modify(ctx, session, query, args...)
+		return result, session.failTransaction(autocommitOnly(session, classifyError(err)))
+	}
+	result, err := tdb.fetch(ctx, session, query, args...)
+	if errors.Is(err, driver.ErrBadConn) && isRetryable(query) && session.transaction() == nil {
+		log.Println("Retrying query after bad connection:", err)
+		result, err = tdb.fetch(ctx, session, query, args...)
+	}
+	return result, session.failTransaction(autocommitOnly(session, classifyError(err)))
+}
+
+// fetch runs a query on Trino once and reads its complete result. The
+// given Trino headers are sent in addition to those of the session.
+func (tdb *TrinoDB) fetch(ctx context.Context, session *Session, query string, headers ...any) (*queryResult, error) {
+	start := time.Now()
+	progress := &queryProgress{}
+	args := append(tdb.queryArgs(ctx, session, query), headers...)
+	args = append(args, progress.args()...)
+	rows, err := tdb.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -200,26 +820,37 @@ func (tdb *TrinoDB) handler(_ context.Context, query string) (wire.PreparedState
 	if err != nil {
 		return nil, err
 	}
-	columns := createColumns(columnTypes)
-	scanValues := GetScanValues(columnTypes)
+	if hasSpatialColumns(columnTypes) {
+		_ = rows.Close()
+		return tdb.fetchWKT(ctx, session, query, columnTypes, headers...)
+	}
+	extractors, err := columnExtractors(columnTypes, tdb.Config, tdb.typeOverrides)
+	if err != nil {
+		return nil, err
+	}
+	columns := createColumns(columnTypes, extractors)
+	scanner := newRowScanner(columnTypes, extractors, session)
 	var rowsData [][]any
 	for rows.Next() {
-		if err := rows.Scan(scanValues...); err != nil {
+		values, err := scanner.scan(rows)
+		if err != nil {
 			return nil, err
 		}
-		values := scanValuesToValues(scanValues)
 		rowsData = append(rowsData, values)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	handle := func(_ context.Context, writer wire.DataWriter, _ []wire.Parameter) error {
-		for _, row := range rowsData {
-			if err = writer.Row(row); err != nil {
-				return err
-			}
-		}
-		return writer.Complete("")
+	// Closing the rows waits for the final progress report of the query.
+	if err := rows.Close(); err != nil {
+		return nil, err
 	}
-	return wire.Prepared(wire.NewStatement(handle, wire.WithColumns(columns))), nil
+	return &queryResult{
+		columns:   columns,
+		rows:      rowsData,
+		tag:       commandTag(query, int64(len(rowsData))),
+		progress:  progress,
+		elapsed:   time.Since(start),
+		truncated: scanner.truncated,
+	}, nil
 }
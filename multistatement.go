@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+)
+
+// splitStatements splits a query into its statements at the semicolons
+// outside of string literals, quoted identifiers, dollar-quoted strings and
+// comments. Empty statements are dropped.
+func splitStatements(query string) []string {
+	var (
+		parts []string
+		start int
+	)
+	add := func(end int) {
+		if part := strings.TrimSpace(query[start:end]); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				i = len(query)
+			} else {
+				// A doubled quote continues the literal on the next pass.
+				i += end + 1
+			}
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 3
+			}
+		case c == '$':
+			tag := dollarQuoteTag(query[i:])
+			if tag == "" {
+				continue
+			}
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += len(tag) + end + len(tag) - 1
+			}
+		case c == ';':
+			add(i)
+			start = i + 1
+		}
+	}
+	add(len(query))
+	return parts
+}
+
+// dollarQuoteTag returns the $tag$ opening a dollar-quoted string at the
+// start of s, or an empty string if there is none.
+func dollarQuoteTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '$':
+			return s[:i+1]
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 1 && '0' <= c && c <= '9':
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// statements prepares the statements of a simple query holding several of
+// them, which the client receives the results of one after the other, each
+// with its own row description and command completion. Like Postgres, the
+// statements following a failed statement are not run: its error is
+// returned once the results of the statements before it have been sent.
+func (tdb *TrinoDB) statements(ctx context.Context, parts []string) wire.PreparedStatements {
+	var statements wire.PreparedStatements
+	for _, part := range parts {
+		prepared, err := tdb.statement(ctx, part)
+		if err != nil {
+			fail := func(context.Context, wire.DataWriter, []wire.Parameter) error { return err }
+			return append(statements, wire.NewStatement(fail))
+		}
+		statements = append(statements, prepared...)
+	}
+	return statements
+}
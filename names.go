@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nameMissTTL is how long the names listed by a query are trusted to hold
+// no match for a name, before they are listed again.
+const nameMissTTL = 30 * time.Second
+
+// nameCache holds the catalog names of Trino and the schema names of its
+// catalogs, by the query listing them, to resolve the names clients fold to
+// lowercase.
+type nameCache struct {
+	mu    sync.Mutex
+	names map[string][]string
+	// listed is when each query was last run.
+	listed map[string]time.Time
+}
+
+// resolveQueryNames returns query with the unquoted catalog and schema
+// names qualifying its tables replaced by the names Trino knows them by,
+// when they differ.
+func (tdb *TrinoDB) resolveQueryNames(ctx context.Context, session *Session, query string) string {
+	catalog := session.Catalog
+	if catalog == "" {
+		catalog = tdb.Config.TrinoCatalog
+	}
+	literals := literalRanges(query)
+	matches := qualifiedTable.FindAllStringSubmatchIndex(query, -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		match := matches[i]
+		if insideRanges(literals, match[0]) {
+			continue
+		}
+		// The spans of the schema name and of the catalog name, if any.
+		schema, catalogName := match[2:4], []int(nil)
+		if match[6] >= 0 {
+			schema, catalogName = match[4:6], match[2:4]
+		}
+		schemaCatalog := tdb.resolveCatalog(ctx, catalog)
+		if catalogName != nil {
+			schemaCatalog = tdb.resolveCatalog(ctx, unquoteIdentifier(query[catalogName[0]:catalogName[1]]))
+		}
+		query = replaceName(query, schema, tdb.resolveSchema(ctx, schemaCatalog, unquoteIdentifier(query[schema[0]:schema[1]])))
+		if catalogName != nil {
+			query = replaceName(query, catalogName, schemaCatalog)
+		}
+	}
+	return query
+}
+
+// replaceName replaces the unquoted name at span of query with the quoted
+// name resolved, unless it already is that name.
+func replaceName(query string, span []int, resolved string) string {
+	name := query[span[0]:span[1]]
+	if name[0] == '"' || strings.ToLower(name) == resolved {
+		return query
+	}
+	return query[:span[0]] + quoteIdentifier(resolved) + query[span[1]:]
+}
+
+// unquoteIdentifier returns the name of an identifier, unquoting quoted
+// ones and folding others to lowercase.
+func unquoteIdentifier(name string) string {
+	if name[0] == '"' {
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	}
+	return strings.ToLower(name)
+}
+
+// queryArgs returns the Trino headers carrying the session state for
+// query. With CASE_INSENSITIVE_NAMES, the catalog and schema they select
+// are resolved to the names Trino knows them by.
+func (tdb *TrinoDB) queryArgs(ctx context.Context, session *Session, query string) []any {
+	args := session.queryArgs(query)
+	if !tdb.Config.CaseInsensitiveNames {
+		return args
+	}
+	catalog := session.Catalog
+	if catalog == "" {
+		catalog = tdb.Config.TrinoCatalog
+	}
+	for i, arg := range args {
+		named, ok := arg.(sql.NamedArg)
+		if !ok {
+			continue
+		}
+		switch named.Name {
+		case "X-Trino-Catalog":
+			catalog = tdb.resolveCatalog(ctx, named.Value.(string))
+			args[i] = sql.Named(named.Name, catalog)
+		case "X-Trino-Schema":
+			args[i] = sql.Named(named.Name, tdb.resolveSchema(ctx, catalog, named.Value.(string)))
+		}
+	}
+	return args
+}
+
+// resolveCatalog returns the name of the Trino catalog matching name,
+// exactly or else ignoring case, or name itself if there is none.
+func (tdb *TrinoDB) resolveCatalog(ctx context.Context, name string) string {
+	return tdb.resolveName(ctx, name, "SELECT catalog_name FROM system.metadata.catalogs")
+}
+
+// resolveSchema returns the name of the schema of catalog matching name,
+// exactly or else ignoring case, or name itself if there is none.
+func (tdb *TrinoDB) resolveSchema(ctx context.Context, catalog, name string) string {
+	return tdb.resolveName(ctx, name, "SELECT schema_name FROM "+quoteIdentifier(catalog)+".information_schema.schemata")
+}
+
+// resolveName resolves name among the names listed by query, which are
+// cached until they no longer hold name in any case. Names missing from
+// names listed less than nameMissTTL ago are not listed again.
+func (tdb *TrinoDB) resolveName(ctx context.Context, name, query string) string {
+	tdb.names.mu.Lock()
+	names, listed := tdb.names.names[query], tdb.names.listed[query]
+	tdb.names.mu.Unlock()
+	if resolved, ok := matchName(names, name); ok {
+		return resolved
+	}
+	if time.Since(listed) < nameMissTTL {
+		return name
+	}
+	names, err := tdb.listNames(ctx, query)
+	if err != nil {
+		log.Printf("Failed to resolve the Trino name %q: %s", name, err)
+		return name
+	}
+	tdb.names.mu.Lock()
+	if tdb.names.names == nil {
+		tdb.names.names = map[string][]string{}
+		tdb.names.listed = map[string]time.Time{}
+	}
+	tdb.names.names[query] = names
+	tdb.names.listed[query] = time.Now()
+	tdb.names.mu.Unlock()
+	if resolved, ok := matchName(names, name); ok {
+		return resolved
+	}
+	return name
+}
+
+// listNames returns the names selected by query.
+func (tdb *TrinoDB) listNames(ctx context.Context, query string) ([]string, error) {
+	rows, err := tdb.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// matchName returns the name of names equal to name, or else equal to it
+// ignoring case, or false if there is none.
+func matchName(names []string, name string) (string, bool) {
+	for _, n := range names {
+		if n == name {
+			return n, true
+		}
+	}
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+// quoteIdentifier quotes name as a Trino identifier.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
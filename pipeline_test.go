@@ -0,0 +1,306 @@
+package main_test
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// wireClient speaks the Postgres frontend protocol directly, for tests that
+// need control over the individual messages.
+type wireClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWire(addr, database string) *wireClient {
+	client := startWire(addr, database)
+	client.ReadUntil('Z')
+	return client
+}
+
+// startWire connects to addr and sends the startup message for database,
+// leaving the messages answering it to the caller.
+func startWire(addr, database string) *wireClient {
+	conn, err := net.Dial("tcp", addr)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(conn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+	client := &wireClient{conn: conn, reader: bufio.NewReader(conn)}
+
+	startup := binary.BigEndian.AppendUint32(nil, 196608)
+	startup = append(startup, cstring("user")...)
+	startup = append(startup, cstring("user")...)
+	startup = append(startup, cstring("database")...)
+	startup = append(startup, cstring(database)...)
+	startup = append(startup, 0)
+	_, err = conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(startup)+4)), startup...))
+	Expect(err).NotTo(HaveOccurred())
+	return client
+}
+
+func cstring(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+// Send writes a message of the given type.
+func (c *wireClient) Send(typ byte, body ...[]byte) {
+	var payload []byte
+	for _, b := range body {
+		payload = append(payload, b...)
+	}
+	message := append([]byte{typ}, binary.BigEndian.AppendUint32(nil, uint32(len(payload)+4))...)
+	_, err := c.conn.Write(append(message, payload...))
+	Expect(err).NotTo(HaveOccurred())
+}
+
+// Query sends the Parse, Bind and Execute messages of an unnamed statement
+// without parameters.
+func (c *wireClient) Query(query string) {
+	c.Send('P', cstring(""), cstring(query), []byte{0, 0})
+	c.Send('B', cstring(""), cstring(""), []byte{0, 0, 0, 0, 0, 0})
+	c.Send('E', cstring(""), []byte{0, 0, 0, 0})
+}
+
+// Read returns the type and body of the next message sent by the server.
+func (c *wireClient) Read() (byte, []byte) {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(c.reader, header)
+	Expect(err).NotTo(HaveOccurred())
+	body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	_, err = io.ReadFull(c.reader, body)
+	Expect(err).NotTo(HaveOccurred())
+	return header[0], body
+}
+
+// ReadUntil returns the types of the messages sent by the server up to and
+// including the first message of the given type.
+func (c *wireClient) ReadUntil(typ byte) string {
+	var types []byte
+	for {
+		t, _ := c.Read()
+		types = append(types, t)
+		if t == typ {
+			return string(types)
+		}
+	}
+}
+
+func (c *wireClient) Close() {
+	c.Send('X')
+	Expect(c.conn.Close()).To(Succeed())
+}
+
+var _ = Describe("Extended protocol", func() {
+	var (
+		fake   *fakeTrino
+		server *testServer
+	)
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		for query, value := range map[string]int64{"SELECT 1": 1, "SELECT 2": 2, "SELECT 3": 3} {
+			fake.On(query, fakeResult{
+				Columns: []fakeColumn{col("_col0", "bigint")},
+				Rows:    [][]driver.Value{{value}},
+			})
+		}
+		server = startServer(fake, &config.Config{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("answers a pipelined batch in order and ends it with ReadyForQuery", func() {
+		client := dialWire(server.addr, "hive")
+		defer client.Close()
+
+		client.Query("SELECT 1;")
+		client.Query("SELECT 2;")
+		client.Query("SELECT 3;")
+		client.Send('S')
+
+		Expect(client.ReadUntil('Z')).To(Equal("12DC12DC12DCZ"))
+		var order []string
+		for _, q := range fake.Queries() {
+			order = append(order, q.Query)
+		}
+		Expect(order).To(Equal([]string{"SELECT 1", "SELECT 2", "SELECT 3"}))
+	})
+
+	It("delivers the responses sent before a Flush without waiting for Sync", func() {
+		client := dialWire(server.addr, "hive")
+		defer client.Close()
+
+		client.Query("SELECT 1;")
+		client.Send('H')
+		Expect(client.ReadUntil('C')).To(Equal("12DC"))
+
+		client.Query("SELECT 2;")
+		client.Send('S')
+		Expect(client.ReadUntil('Z')).To(Equal("12DCZ"))
+	})
+
+	It("frees a statement closed by name", func() {
+		client := dialWire(server.addr, "hive")
+		defer client.Close()
+
+		client.Send('P', cstring("stmt"), cstring("SELECT 1;"), []byte{0, 0})
+		client.Send('C', []byte{'S'}, cstring("stmt"))
+		client.Send('D', []byte{'S'}, cstring("stmt"))
+		client.Send('S')
+		Expect(client.ReadUntil('Z')).To(Equal("13EZ"))
+	})
+
+	It("frees a portal closed by name", func() {
+		client := dialWire(server.addr, "hive")
+		defer client.Close()
+
+		client.Send('P', cstring("stmt"), cstring("SELECT 1;"), []byte{0, 0})
+		client.Send('B', cstring("portal"), cstring("stmt"), []byte{0, 0, 0, 0, 0, 0})
+		client.Send('C', []byte{'P'}, cstring("portal"))
+		client.Send('E', cstring("portal"), []byte{0, 0, 0, 0})
+		client.Send('S')
+		Expect(client.ReadUntil('Z')).To(Equal("123EZ"))
+		// psql-wire follows an error with ReadyForQuery, ahead of the one
+		// answering Sync.
+		Expect(client.ReadUntil('Z')).To(Equal("Z"))
+
+		client.Send('B', cstring("portal"), cstring("stmt"), []byte{0, 0, 0, 0, 0, 0})
+		client.Send('E', cstring("portal"), []byte{0, 0, 0, 0})
+		client.Send('S')
+		Expect(client.ReadUntil('Z')).To(Equal("2DCZ"))
+	})
+
+	It("keeps statement names apart per connection", func() {
+		first := dialWire(server.addr, "hive")
+		defer first.Close()
+		second := dialWire(server.addr, "hive")
+		defer second.Close()
+
+		first.Send('P', cstring("stmt"), cstring("SELECT 1;"), []byte{0, 0})
+		first.Send('S')
+		Expect(first.ReadUntil('Z')).To(Equal("1Z"))
+
+		second.Send('D', []byte{'S'}, cstring("stmt"))
+		second.Send('S')
+		Expect(second.ReadUntil('Z')).To(Equal("EZ"))
+	})
+
+	Describe("Describe", func() {
+		// describe sends the Describe message of a statement or portal and a
+		// Sync, and returns the messages answering the Describe.
+		describe := func(client *wireClient, target byte, name string) map[byte][]byte {
+			client.Send('D', []byte{target}, cstring(name))
+			client.Send('S')
+			messages := map[byte][]byte{}
+			for {
+				typ, body := client.Read()
+				if typ == 'Z' {
+					return messages
+				}
+				messages[typ] = body
+			}
+		}
+
+		// resultFormats returns the format codes of the columns of a
+		// RowDescription message.
+		resultFormats := func(body []byte) []int16 {
+			count := int(binary.BigEndian.Uint16(body))
+			body = body[2:]
+			formats := make([]int16, count)
+			for i := range formats {
+				// The column name is followed by the table oid, attribute
+				// number, type oid, type size and type modifier.
+				body = body[bytes.IndexByte(body, 0)+1:]
+				formats[i] = int16(binary.BigEndian.Uint16(body[16:]))
+				body = body[18:]
+			}
+			return formats
+		}
+
+		It("describes the parameters and columns of a statement", func() {
+			client := dialWire(server.addr, "hive")
+			defer client.Close()
+
+			client.Send('P', cstring("stmt"), cstring("SELECT 1;"), []byte{0, 0})
+			Expect(client.ReadUntil('1')).To(Equal("1"))
+
+			messages := describe(client, 'S', "stmt")
+			Expect(messages).To(HaveLen(2))
+			Expect(messages).To(HaveKeyWithValue(byte('t'), []byte{0, 0}))
+			Expect(messages).To(HaveKey(byte('T')))
+			Expect(resultFormats(messages['T'])).To(Equal([]int16{0}))
+		})
+
+		It("describes only the columns of a portal, in its result formats", func() {
+			client := dialWire(server.addr, "hive")
+			defer client.Close()
+
+			client.Send('P', cstring("stmt"), cstring("SELECT 1;"), []byte{0, 0})
+			// One binary result format code for every column.
+			client.Send('B', cstring("portal"), cstring("stmt"), []byte{0, 0, 0, 0, 0, 1, 0, 1})
+			Expect(client.ReadUntil('2')).To(Equal("12"))
+
+			messages := describe(client, 'P', "portal")
+			Expect(messages).To(HaveLen(1))
+			Expect(messages).To(HaveKey(byte('T')))
+			Expect(resultFormats(messages['T'])).To(Equal([]int16{1}))
+		})
+
+		It("reuses the described columns at execute time without querying Trino again", func() {
+			fake.On("SELECT id, name FROM orders", fakeResult{
+				Columns: []fakeColumn{col("id", "bigint"), col("name", "varchar")},
+				Rows:    [][]driver.Value{{int64(1), "tea"}, {int64(2), "cake"}},
+			})
+			client := dialWire(server.addr, "hive")
+			defer client.Close()
+
+			client.Send('P', cstring("stmt"), cstring("SELECT id, name FROM orders;"), []byte{0, 0})
+			client.Send('B', cstring("portal"), cstring("stmt"), []byte{0, 0, 0, 0, 0, 0})
+			Expect(client.ReadUntil('2')).To(Equal("12"))
+			messages := describe(client, 'P', "portal")
+			Expect(messages).To(HaveKey(byte('T')))
+			described := int(binary.BigEndian.Uint16(messages['T']))
+			Expect(described).To(Equal(2))
+
+			client.Send('E', cstring("portal"), []byte{0, 0, 0, 0})
+			client.Send('S')
+			var rows int
+			for {
+				typ, body := client.Read()
+				if typ == 'Z' {
+					break
+				}
+				Expect(typ).To(BeElementOf(byte('D'), byte('C')))
+				if typ == 'D' {
+					Expect(int(binary.BigEndian.Uint16(body))).To(Equal(described))
+					rows++
+				}
+			}
+			Expect(rows).To(Equal(2))
+			Expect(fake.Queries()).To(HaveLen(1))
+		})
+
+		It("answers NoData for statements without columns", func() {
+			client := dialWire(server.addr, "hive")
+			defer client.Close()
+
+			client.Send('P', cstring("set"), cstring("SET application_name = 'x';"), []byte{0, 0})
+			client.Send('B', cstring("portal"), cstring("set"), []byte{0, 0, 0, 0, 0, 0})
+			Expect(client.ReadUntil('2')).To(Equal("12"))
+
+			Expect(describe(client, 'S', "set")).To(HaveKey(byte('n')))
+			Expect(describe(client, 'P', "portal")).To(Equal(map[byte][]byte{'n': {}}))
+		})
+	})
+})
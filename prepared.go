@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"pg2trino/config"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+var (
+	// prepareStatement matches the Postgres PREPARE name [ ( type [, ...] ) ] AS statement statement.
+	prepareStatement = regexp.MustCompile(`(?is)^\s*PREPARE\s+([a-z_][a-z0-9_]*)\s*(?:\((.*?)\))?\s*AS\s+(.*?)\s*$`)
+	// executeStatement matches the Postgres EXECUTE name [ ( parameter [, ...] ) ] statement.
+	executeStatement = regexp.MustCompile(`(?is)^\s*EXECUTE\s+([a-z_][a-z0-9_]*)\s*(?:\((.*)\))?\s*$`)
+	// deallocateStatement matches the Postgres DEALLOCATE [ PREPARE ] { name | ALL } statement.
+	deallocateStatement = regexp.MustCompile(`(?is)^\s*DEALLOCATE\s+(?:PREPARE\s+)?([a-z_][a-z0-9_]*)\s*$`)
+	// describeStatement matches the Trino DESCRIBE { INPUT | OUTPUT } name statement.
+	describeStatement = regexp.MustCompile(`(?is)^\s*DESCRIBE\s+(INPUT|OUTPUT)\s+([a-z_][a-z0-9_]*)\s*$`)
+	// closeStatement matches the Postgres CLOSE { name | ALL } statement.
+	closeStatement = regexp.MustCompile(`(?is)^\s*CLOSE\s+([a-z_][a-z0-9_]*)\s*$`)
+	// placeholder matches a positional parameter such as $1.
+	placeholder = regexp.MustCompile(`^\$(\d+)`)
+)
+
+// preparedStatement is a statement prepared with the SQL PREPARE statement.
+type preparedStatement struct {
+	query  string
+	params int
+}
+
+// parsePrepare returns the name and prepared statement of a PREPARE
+// statement, or false if query is not one.
+func parsePrepare(query string) (string, preparedStatement, bool) {
+	match := prepareStatement.FindStringSubmatch(query)
+	if match == nil {
+		return "", preparedStatement{}, false
+	}
+	statement := preparedStatement{query: match[3], params: len(splitList(match[2]))}
+	if statement.params == 0 {
+		statement.params = highestPlaceholder(statement.query)
+	}
+	return strings.ToLower(match[1]), statement, true
+}
+
+// parseExecute returns the statement name and parameters of an EXECUTE
+// statement, or false if query is not one.
+func parseExecute(query string) (string, []string, bool) {
+	match := executeStatement.FindStringSubmatch(query)
+	if match == nil {
+		return "", nil, false
+	}
+	return strings.ToLower(match[1]), splitList(match[2]), true
+}
+
+// parseDeallocate returns the statement name of a DEALLOCATE statement, or
+// false if query is not one.
+func parseDeallocate(query string) (string, bool) {
+	match := deallocateStatement.FindStringSubmatch(query)
+	if match == nil {
+		return "", false
+	}
+	return strings.ToLower(match[1]), true
+}
+
+// parseDescribe returns the kind, INPUT or OUTPUT, and statement name of a
+// DESCRIBE INPUT or DESCRIBE OUTPUT statement, or false if query is not one.
+func parseDescribe(query string) (string, string, bool) {
+	match := describeStatement.FindStringSubmatch(query)
+	if match == nil {
+		return "", "", false
+	}
+	return strings.ToUpper(match[1]), strings.ToLower(match[2]), true
+}
+
+// parseClose returns the cursor name of a CLOSE statement, or false if query
+// is not one.
+func parseClose(query string) (string, bool) {
+	match := closeStatement.FindStringSubmatch(query)
+	if match == nil {
+		return "", false
+	}
+	return strings.ToLower(match[1]), true
+}
+
+// prepare answers a PREPARE statement by storing the statement in the session.
+func prepare(ctx context.Context, name string, statement preparedStatement) (wire.PreparedStatements, error) {
+	if err := SessionFromContext(ctx).Prepare(name, statement); err != nil {
+		return nil, err
+	}
+	return commandComplete("PREPARE"), nil
+}
+
+// deallocate answers a DEALLOCATE statement by removing the statement, or
+// all statements, from the session.
+func deallocate(ctx context.Context, name string) (wire.PreparedStatements, error) {
+	session := SessionFromContext(ctx)
+	if name == "all" {
+		session.deallocateAll()
+		return commandComplete("DEALLOCATE ALL"), nil
+	}
+	if err := session.Deallocate(name); err != nil {
+		return nil, err
+	}
+	return commandComplete("DEALLOCATE"), nil
+}
+
+// closeCursor answers a CLOSE statement. Cursors are not supported, so only
+// CLOSE ALL succeeds.
+func closeCursor(name string) (wire.PreparedStatements, error) {
+	if name != "all" {
+		err := fmt.Errorf("cursor %q does not exist", name)
+		return nil, psqlerr.WithCode(err, codes.InvalidCursorName)
+	}
+	return commandComplete("CLOSE CURSOR ALL"), nil
+}
+
+// Prepare stores a prepared statement in the session under the given name.
+func (s *Session) Prepare(name string, statement preparedStatement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.prepared[name]; ok {
+		err := fmt.Errorf("prepared statement %q already exists", name)
+		return psqlerr.WithCode(err, codes.DuplicatePreparedStatement)
+	}
+	if err := checkPreparedLimit(s.Config(), len(s.prepared)); err != nil {
+		return err
+	}
+	if s.prepared == nil {
+		s.prepared = map[string]preparedStatement{}
+	}
+	s.prepared[name] = statement
+	return nil
+}
+
+// checkPreparedLimit returns an error if a session keeping prepared
+// statements prepared may not prepare another one, so clients leaking
+// statements do not grow its memory without bounds.
+func checkPreparedLimit(cfg *config.Config, prepared int) error {
+	limit := cfg.MaxPreparedStatements
+	if limit <= 0 || prepared < limit {
+		return nil
+	}
+	err := fmt.Errorf("too many prepared statements: the session keeps the maximum of %d", limit)
+	err = psqlerr.WithCode(err, codes.ProgramLimitExceeded)
+	return psqlerr.WithHint(err, "Deallocate or close prepared statements that are no longer used.")
+}
+
+// Deallocate removes the named prepared statement from the session.
+func (s *Session) Deallocate(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.prepared[name]; !ok {
+		return errUnknownStatement(name)
+	}
+	delete(s.prepared, name)
+	return nil
+}
+
+func (s *Session) deallocateAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prepared = nil
+}
+
+// bind returns the query of the named prepared statement with its
+// positional parameters replaced by the given parameters.
+func (s *Session) bind(name string, params []string) (string, error) {
+	s.mu.Lock()
+	statement, ok := s.prepared[name]
+	s.mu.Unlock()
+	if !ok {
+		return "", errUnknownStatement(name)
+	}
+	if len(params) != statement.params {
+		err := fmt.Errorf("wrong number of parameters for prepared statement %q: expected %d, got %d", name, statement.params, len(params))
+		return "", psqlerr.WithCode(err, codes.Syntax)
+	}
+	return substituteParams(statement.query, params), nil
+}
+
+// preparedHeader returns the Trino header declaring the named prepared
+// statement, with its positional parameters replaced by Trino's ? markers,
+// so Trino can describe it.
+func (s *Session) preparedHeader(name string) (any, error) {
+	s.mu.Lock()
+	statement, ok := s.prepared[name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errUnknownStatement(name)
+	}
+	query := replacePlaceholders(rewriteQuery(statement.query, s.Config()), func(int) (string, bool) { return "?", true })
+	return sql.Named("X-Trino-Prepared-Statement", url.QueryEscape(name)+"="+url.QueryEscape(query)), nil
+}
+
+// renameDescribeColumns renames the columns of Trino's DESCRIBE INPUT and
+// DESCRIBE OUTPUT results, such as "Column Name", to Postgres-friendly
+// names, such as column_name.
+func renameDescribeColumns(columns wire.Columns) {
+	for i := range columns {
+		columns[i].Name = strings.ReplaceAll(strings.ToLower(columns[i].Name), " ", "_")
+	}
+}
+
+func errUnknownStatement(name string) error {
+	err := fmt.Errorf("prepared statement %q does not exist", name)
+	return psqlerr.WithCode(err, codes.InvalidSQLStatementName)
+}
+
+// substituteParams replaces the positional parameters of query outside of
+// quoted literals and identifiers with the given parameters.
+func substituteParams(query string, params []string) string {
+	return replacePlaceholders(query, func(n int) (string, bool) {
+		if n < 1 || n > len(params) {
+			return "", false
+		}
+		return params[n-1], true
+	})
+}
+
+// highestPlaceholder returns the highest positional parameter used in query.
+func highestPlaceholder(query string) int {
+	highest := 0
+	replacePlaceholders(query, func(n int) (string, bool) {
+		if n > highest {
+			highest = n
+		}
+		return "", false
+	})
+	return highest
+}
+
+// replacePlaceholders replaces the positional parameters of query outside
+// of quoted literals and identifiers with the text returned by replace.
+// Parameters for which replace returns false are kept.
+func replacePlaceholders(query string, replace func(n int) (string, bool)) string {
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '$':
+			if match := placeholder.FindStringSubmatch(query[i:]); match != nil {
+				n, _ := strconv.Atoi(match[1])
+				if text, ok := replace(n); ok {
+					b.WriteString(text)
+					i += len(match[0]) - 1
+					continue
+				}
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// splitList splits a comma separated list, ignoring commas inside quotes and
+// parentheses. An empty list has no elements.
+func splitList(list string) []string {
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+	var (
+		items []string
+		quote byte
+		depth int
+		start int
+	)
+	for i := 0; i < len(list); i++ {
+		c := list[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+	}
+	return append(items, strings.TrimSpace(list[start:]))
+}
@@ -0,0 +1,158 @@
+package main_test
+
+import (
+	"database/sql/driver"
+	"net/url"
+
+	"pg2trino/config"
+
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Prepared statements", func() {
+	var (
+		fake   *fakeTrino
+		server *testServer
+	)
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		fake.On("SELECT name FROM users WHERE id = 42 AND name <> '$1'", fakeResult{
+			Columns: []fakeColumn{col("name", "varchar")},
+			Rows:    [][]driver.Value{{"alice"}},
+		})
+		server = startServer(fake, &config.Config{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("prepares, executes and deallocates statements", func() {
+		db := server.Connect("hive")
+		defer db.Close()
+
+		_, err := db.Exec("PREPARE by_id (bigint) AS SELECT name FROM users WHERE id = $1 AND name <> '$1';")
+		Expect(err).NotTo(HaveOccurred())
+
+		var name string
+		Expect(db.QueryRow("EXECUTE by_id (42);").Scan(&name)).To(Succeed())
+		Expect(name).To(Equal("alice"))
+		Expect(fake.LastQuery().Query).To(Equal("SELECT name FROM users WHERE id = 42 AND name <> '$1'"))
+
+		_, err = db.Exec("DEALLOCATE by_id;")
+		Expect(err).NotTo(HaveOccurred())
+		err = db.QueryRow("EXECUTE by_id (42);").Scan(&name)
+		Expect(err).To(BeAssignableToTypeOf(&pq.Error{}))
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("26000"))
+	})
+
+	It("rejects duplicate statement names and wrong parameter counts", func() {
+		db := server.Connect("hive")
+		defer db.Close()
+
+		_, err := db.Exec("PREPARE by_id AS SELECT name FROM users WHERE id = $1;")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = db.Exec("PREPARE by_id AS SELECT 1;")
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("42P05"))
+		_, err = db.Exec("EXECUTE by_id (1, 2);")
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("42601"))
+
+		_, err = db.Exec("DEALLOCATE ALL;")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = db.Exec("PREPARE by_id AS SELECT 1;")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("only closes all cursors", func() {
+		db := server.Connect("hive")
+		defer db.Close()
+
+		_, err := db.Exec("CLOSE ALL;")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = db.Exec("CLOSE my_cursor;")
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("34000"))
+	})
+
+	It("describes the input and output of prepared statements through Trino", func() {
+		fake.On("DESCRIBE INPUT by_id", fakeResult{
+			Columns: []fakeColumn{col("Position", "bigint"), col("Type", "varchar")},
+			Rows:    [][]driver.Value{{int64(0), "bigint"}},
+		})
+		fake.On("DESCRIBE OUTPUT by_id", fakeResult{
+			Columns: []fakeColumn{
+				col("Column Name", "varchar"), col("Catalog", "varchar"), col("Schema", "varchar"),
+				col("Table", "varchar"), col("Type", "varchar"), col("Type Size", "bigint"), col("Aliased", "boolean"),
+			},
+			Rows: [][]driver.Value{{"name", "hive", "default", "users", "varchar", int64(0), false}},
+		})
+		db := server.Connect("hive")
+		defer db.Close()
+		_, err := db.Exec("PREPARE by_id AS SELECT name FROM users WHERE id = $1 AND name <> '$1';")
+		Expect(err).NotTo(HaveOccurred())
+
+		var (
+			position int
+			typ      string
+		)
+		Expect(db.QueryRow("DESCRIBE INPUT by_id;").Scan(&position, &typ)).To(Succeed())
+		Expect(position).To(Equal(0))
+		Expect(typ).To(Equal("bigint"))
+		Expect(fake.LastQuery().Header("X-Trino-Prepared-Statement")).To(Equal(
+			"by_id=" + url.QueryEscape("SELECT name FROM users WHERE id = ? AND name <> '$1'")))
+
+		rows, err := db.Query("DESCRIBE OUTPUT by_id;")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		columns, err := rows.Columns()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(columns).To(Equal([]string{"column_name", "catalog", "schema", "table", "type", "type_size", "aliased"}))
+	})
+
+	It("rejects describing unknown statements", func() {
+		db := server.Connect("hive")
+		defer db.Close()
+		_, err := db.Exec("DESCRIBE OUTPUT missing;")
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("26000"))
+	})
+
+	It("limits the statements a session keeps prepared", func() {
+		limited := startServer(fake, &config.Config{MaxPreparedStatements: 2})
+		defer limited.Close()
+		db := limited.Connect("hive")
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+
+		for _, name := range []string{"a", "b"} {
+			_, err := db.Exec("PREPARE " + name + " AS SELECT 1;")
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err := db.Exec("PREPARE c AS SELECT 1;")
+		Expect(err).To(BeAssignableToTypeOf(&pq.Error{}))
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("54000"))
+		_, err = db.Exec("DEALLOCATE a;")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = db.Exec("PREPARE c AS SELECT 1;")
+		Expect(err).NotTo(HaveOccurred())
+
+		// Named statements of the extended protocol count against the limit
+		// too, until the client closes them.
+		for _, query := range []string{"SELECT 1", "SELECT 2", "SELECT 3"} {
+			fake.On(query, fakeResult{Columns: []fakeColumn{col("_col0", "integer")}})
+		}
+		first, err := db.Prepare("SELECT 1;")
+		Expect(err).NotTo(HaveOccurred())
+		second, err := db.Prepare("SELECT 2;")
+		Expect(err).NotTo(HaveOccurred())
+		defer second.Close()
+		_, err = db.Prepare("SELECT 3;")
+		Expect(err).To(BeAssignableToTypeOf(&pq.Error{}))
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("54000"))
+		Expect(first.Close()).To(Succeed())
+		third, err := db.Prepare("SELECT 3;")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(third.Close()).To(Succeed())
+	})
+})
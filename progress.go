@@ -0,0 +1,49 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	trino "github.com/trinodb/trino-go-client/trino"
+)
+
+// progressPeriod is how often Trino reports the progress of running queries.
+const progressPeriod = 100 * time.Millisecond
+
+// queryProgress collects the progress Trino reports for a single query.
+type queryProgress struct {
+	mu  sync.Mutex
+	id  string
+	cpu time.Duration
+}
+
+// Update implements trino.ProgressUpdater.
+func (p *queryProgress) Update(info trino.QueryProgressInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.id = info.QueryId
+	p.cpu = time.Duration(info.QueryStats.CPUTimeMillis) * time.Millisecond
+}
+
+// ID returns the Trino query id, or an empty string if unknown.
+func (p *queryProgress) ID() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.id
+}
+
+// CPUTime returns the CPU time last reported by Trino, or zero if unknown.
+func (p *queryProgress) CPUTime() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cpu
+}
+
+// args returns the query arguments registering p as progress callback.
+func (p *queryProgress) args() []any {
+	return []any{
+		sql.Named("X-Trino-Progress-Callback", trino.ProgressUpdater(p)),
+		sql.Named("X-Trino-Progress-Callback-Period", progressPeriod),
+	}
+}
@@ -0,0 +1,76 @@
+package main_test
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"log"
+	"os"
+	"regexp"
+	"time"
+
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Query progress", func() {
+	var fake *fakeTrino
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		fake.CPUTime = 12 * time.Millisecond
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+	})
+
+	It("sends the execution time as a notice when enabled", func() {
+		server := startServer(fake, &config.Config{TimingNotices: true})
+		defer server.Close()
+		db, notices := server.ConnectWithNotices("memory")
+		defer db.Close()
+
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(notices()).To(ConsistOf(MatchRegexp(`^Time: \d+\.\d{3} ms \(Trino CPU time: 12\.000 ms\)$`)))
+	})
+
+	It("sends no timing notice by default", func() {
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		db, notices := server.ConnectWithNotices("memory")
+		defer db.Close()
+
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(notices()).To(BeEmpty())
+	})
+
+	It("logs queries slower than the slow query threshold", func() {
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		defer log.SetOutput(os.Stderr)
+
+		fake.On("SELECT count(*) FROM events", fakeResult{
+			Columns: []fakeColumn{col("_col0", "bigint")},
+			Rows:    [][]driver.Value{{int64(7)}},
+			Delay:   100 * time.Millisecond,
+		})
+		server := startServer(fake, &config.Config{SlowQueryThreshold: 50 * time.Millisecond})
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(db.QueryRow("SELECT count(*) FROM events;").Scan(&value)).To(Succeed())
+		slow := regexp.MustCompile(`Slow query: duration=(\S+) query="(.*)"`).FindAllStringSubmatch(logs.String(), -1)
+		Expect(slow).To(HaveLen(1))
+		Expect(slow[0][2]).To(Equal("SELECT count(*) FROM events"))
+		duration, err := time.ParseDuration(slow[0][1])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(duration).To(BeNumerically(">=", 100*time.Millisecond))
+	})
+})
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+)
+
+var (
+	// setSessionStatement matches the Trino SET SESSION [catalog.]name = value statement.
+	setSessionStatement = regexp.MustCompile(`(?is)^\s*SET\s+SESSION\s+([a-z_][a-z0-9_]*(?:\.[a-z_][a-z0-9_]*)?)\s*=\s*(.*?)\s*$`)
+	// resetSessionStatement matches the Trino RESET SESSION [catalog.]name statement.
+	resetSessionStatement = regexp.MustCompile(`(?is)^\s*RESET\s+SESSION\s+([a-z_][a-z0-9_]*(?:\.[a-z_][a-z0-9_]*)?)\s*$`)
+	// dataSize matches a Trino data size such as 10GB or 1.5 TB.
+	dataSize = regexp.MustCompile(`(?i)^\s*(\d+(?:\.\d+)?)\s*(B|kB|MB|GB|TB|PB)\s*$`)
+)
+
+// dataSizeUnits are the factors of the units of Trino data sizes.
+var dataSizeUnits = map[string]float64{"B": 1, "KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "TB": 1 << 40, "PB": 1 << 50}
+
+// parseDataSize returns the number of bytes of a Trino data size, or false
+// if size is not one.
+func parseDataSize(size string) (float64, bool) {
+	match := dataSize.FindStringSubmatch(size)
+	if match == nil {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return value * dataSizeUnits[strings.ToUpper(match[2])], true
+}
+
+// parseSetSession returns the property name and value of a Trino SET
+// SESSION statement, or false if query is not one. SET SESSION statements
+// of Postgres settings, such as SET SESSION timezone = 'UTC', are left to
+// parseSet.
+func parseSetSession(query string) (string, string, bool) {
+	match := setSessionStatement.FindStringSubmatch(query)
+	if match == nil || isEmulatedSetting(match[1]) {
+		return "", "", false
+	}
+	return strings.ToLower(match[1]), unquote(match[2]), true
+}
+
+// parseResetSession returns the property name of a Trino RESET SESSION
+// statement, or false if query is not one.
+func parseResetSession(query string) (string, bool) {
+	match := resetSessionStatement.FindStringSubmatch(query)
+	if match == nil {
+		return "", false
+	}
+	return strings.ToLower(match[1]), true
+}
+
+// isEmulatedSetting reports whether name is a Postgres setting emulated by
+// pg2trino.
+func isEmulatedSetting(name string) bool {
+	for _, setting := range emulatedSettings {
+		if strings.EqualFold(setting.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// setSessionProperty answers SET SESSION by storing the Trino session
+// property in the session, which sends it with the following queries.
+func setSessionProperty(ctx context.Context, name, value string) wire.PreparedStatements {
+	SessionFromContext(ctx).SetProperty(name, value)
+	return commandComplete("SET")
+}
+
+// resetSessionProperty answers RESET SESSION by removing the Trino session
+// property from the session.
+func resetSessionProperty(ctx context.Context, name string) wire.PreparedStatements {
+	SessionFromContext(ctx).ResetProperty(name)
+	return commandComplete("RESET")
+}
+
+// SetProperty sets a Trino session property for the following queries.
+func (s *Session) SetProperty(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.properties == nil {
+		s.properties = map[string]string{}
+	}
+	s.properties[name] = value
+}
+
+// ResetProperty restores the default of a Trino session property.
+func (s *Session) ResetProperty(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.properties, name)
+}
+
+// propertiesHeader returns the Trino header carrying the session
+// properties, or false if none is set. The query_max_memory of
+// QUERY_MAX_MEMORY is sent unless the client set a lower one of its own.
+func (s *Session) propertiesHeader() (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := map[string]string{}
+	for name, value := range s.properties {
+		values[name] = value
+	}
+	if limit := s.Config().QueryMaxMemory; limit != "" {
+		maximum, _ := parseDataSize(limit)
+		size, ok := parseDataSize(values["query_max_memory"])
+		if !ok || size > maximum {
+			values["query_max_memory"] = limit
+		}
+	}
+	if len(values) == 0 {
+		return nil, false
+	}
+	properties := make([]string, 0, len(values))
+	for name, value := range values {
+		properties = append(properties, name+"="+url.QueryEscape(value))
+	}
+	sort.Strings(properties)
+	return sql.Named("X-Trino-Session", strings.Join(properties, ",")), true
+}
@@ -0,0 +1,439 @@
+package main_test
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Query", func() {
+	var (
+		fake   *fakeTrino
+		server *testServer
+	)
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		server = startServer(fake, &config.Config{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("retries a query once when its connection goes bad while reading rows", func() {
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+			NextErr: driver.ErrBadConn,
+		})
+		db := server.Connect("hive")
+		defer db.Close()
+
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(value).To(Equal(1))
+		Expect(fake.Queries()).To(HaveLen(2))
+	})
+
+	It("does not retry other errors while reading rows", func() {
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+			NextErr: errors.New("query exceeded memory limit"),
+		})
+		db := server.Connect("hive")
+		defer db.Close()
+
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(MatchError(ContainSubstring("query exceeded memory limit")))
+		Expect(fake.Queries()).To(HaveLen(1))
+	})
+
+	It("retries only statements free of side effects", func() {
+		for query, retryable := range map[string]bool{
+			"SELECT * FROM orders":                                 true,
+			"(SELECT 1) UNION (SELECT 2)":                          true,
+			"show tables":                                          true,
+			"EXPLAIN SELECT * FROM orders":                         true,
+			"WITH t AS (SELECT 1) SELECT * FROM t":                 true,
+			"SELECT * FROM audit WHERE action = 'DELETE'":          true,
+			"EXPLAIN ANALYZE INSERT INTO orders VALUES (1)":        false,
+			"INSERT INTO orders VALUES (1)":                        false,
+			"WITH t AS (DELETE FROM orders RETURNING *) SELECT 1":  false,
+			"CREATE TABLE orders (id bigint)":                      false,
+			"CALL system.sync_partition_metadata('s', 't', 'ADD')": false,
+			"EXECUTE by_id USING 1":                                false,
+		} {
+			Expect(pgtrino.IsRetryable(query)).To(Equal(retryable), query)
+		}
+	})
+
+	It("does not retry statements with side effects when their connection goes bad", func() {
+		fake.On("CALL system.flush_metadata_cache()", fakeResult{
+			Columns: []fakeColumn{col("result", "boolean")},
+			Rows:    [][]driver.Value{{true}},
+			NextErr: driver.ErrBadConn,
+		})
+		db := server.Connect("hive")
+		defer db.Close()
+
+		_, err := db.Exec("CALL system.flush_metadata_cache();")
+		Expect(err).To(HaveOccurred())
+		Expect(fake.Queries()).To(HaveLen(1))
+	})
+
+	It("strips only the trailing semicolons of statements", func() {
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		db := server.Connect("hive")
+		defer db.Close()
+
+		var sent []string
+		for _, query := range []string{"SELECT 1", "SELECT 1;", "SELECT 1 ;\n", "SELECT 1;;"} {
+			var value int
+			Expect(db.QueryRow(query).Scan(&value)).To(Succeed(), query)
+			sent = append(sent, fake.LastQuery().Query)
+		}
+		Expect(sent).To(Equal([]string{"SELECT 1", "SELECT 1", "SELECT 1", "SELECT 1"}))
+	})
+
+	It("sends statements verbatim with KEEP_SEMICOLONS", func() {
+		fake.On("SELECT 1;", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		verbatim := startServer(fake, &config.Config{KeepSemicolons: true})
+		defer verbatim.Close()
+		db := verbatim.Connect("hive")
+		defer db.Close()
+
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(fake.LastQuery().Query).To(Equal("SELECT 1;"))
+	})
+
+	It("rejects statements over the maximum size without forwarding them", func() {
+		fake.On("SELECT 'short'", fakeResult{
+			Columns: []fakeColumn{col("_col0", "varchar")},
+			Rows:    [][]driver.Value{{"short"}},
+		})
+		limited := startServer(fake, &config.Config{MaxStatementBytes: 64})
+		defer limited.Close()
+		db := limited.Connect("hive")
+		defer db.Close()
+
+		var value string
+		Expect(db.QueryRow("SELECT 'short';").Scan(&value)).To(Succeed())
+		err := db.QueryRow("SELECT '" + strings.Repeat("x", 100) + "';").Scan(&value)
+		Expect(err).To(BeAssignableToTypeOf(&pq.Error{}))
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("54000"))
+		Expect(fake.Queries()).To(HaveLen(1))
+	})
+
+	It("rejects values larger than the configured maximum with a descriptive error", func() {
+		fake.On("SELECT id, payload FROM documents", fakeResult{
+			Columns: []fakeColumn{col("id", "bigint"), col("payload", "varchar")},
+			Rows:    [][]driver.Value{{int64(1), strings.Repeat("x", 100)}},
+		})
+		fake.On("SELECT id FROM documents", fakeResult{
+			Columns: []fakeColumn{col("id", "bigint")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		limited := startServer(fake, &config.Config{MaxValueBytes: 64})
+		defer limited.Close()
+		db := limited.Connect("hive")
+		defer db.Close()
+
+		var id int64
+		var payload string
+		err := db.QueryRow("SELECT id, payload FROM documents;").Scan(&id, &payload)
+		Expect(err).To(BeAssignableToTypeOf(&pq.Error{}))
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("54000"))
+		Expect(err.(*pq.Error).Message).To(Equal(`value of column "payload" is 100 bytes, exceeding the maximum of 64 bytes`))
+
+		Expect(db.QueryRow("SELECT id FROM documents;").Scan(&id)).To(Succeed())
+	})
+
+	It("truncates text values longer than the maximum field size with a notice", func() {
+		fake.On("SELECT id, title, payload FROM documents", fakeResult{
+			Columns: []fakeColumn{col("id", "bigint"), col("title", "varchar"), col("payload", "varchar")},
+			Rows: [][]driver.Value{
+				{int64(1), "short", strings.Repeat("x", 100)},
+				{int64(2), "naïve", "ok"},
+			},
+		})
+		limited := startServer(fake, &config.Config{MaxFieldBytes: 3})
+		defer limited.Close()
+		db, notices := limited.ConnectWithNotices("hive")
+		defer db.Close()
+
+		rows, err := db.Query("SELECT id, title, payload FROM documents;")
+		Expect(err).NotTo(HaveOccurred())
+		var values [][]string
+		for rows.Next() {
+			var id, title, payload string
+			Expect(rows.Scan(&id, &title, &payload)).To(Succeed())
+			values = append(values, []string{id, title, payload})
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		Expect(rows.Close()).To(Succeed())
+		// The multi-byte ï is dropped whole rather than split.
+		Expect(values).To(Equal([][]string{{"1", "sho", "xxx"}, {"2", "na", "ok"}}))
+		Expect(notices()).To(ConsistOf("3 values were truncated to 3 bytes"))
+	})
+
+	It("sends the scalar columns of UNNEST through the normal type mapping", func() {
+		fake.On("SELECT x FROM UNNEST(ARRAY[1,2,3]) AS t(x)", fakeResult{
+			Columns: []fakeColumn{col("x", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}, {int64(2)}, {int64(3)}},
+		})
+		local := startServer(fake, &config.Config{LocalConstants: true})
+		defer local.Close()
+		db := local.Connect("hive")
+		defer db.Close()
+
+		rows, err := db.Query("SELECT x FROM UNNEST(ARRAY[1,2,3]) AS t(x);")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		columns, err := rows.ColumnTypes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(columns[0].DatabaseTypeName()).To(Equal("INT4"))
+		var values []int
+		for rows.Next() {
+			var x int
+			Expect(rows.Scan(&x)).To(Succeed())
+			values = append(values, x)
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		Expect(values).To(Equal([]int{1, 2, 3}))
+		Expect(fake.LastQuery().Query).To(Equal("SELECT x FROM UNNEST(ARRAY[1,2,3]) AS t(x)"))
+	})
+
+	Describe("Introspection", func() {
+		// columns returns the column names and first row of query.
+		columns := func(query string) ([]string, []string) {
+			db := server.Connect("hive")
+			defer db.Close()
+			rows, err := db.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+			defer rows.Close()
+			names, err := rows.Columns()
+			Expect(err).NotTo(HaveOccurred())
+			values := make([]string, len(names))
+			pointers := make([]any, len(names))
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+			Expect(rows.Next()).To(BeTrue())
+			Expect(rows.Scan(pointers...)).To(Succeed())
+			return names, values
+		}
+
+		It("names the columns of SHOW FUNCTIONS for Postgres clients", func() {
+			fake.On("SHOW FUNCTIONS LIKE 'abs'", fakeResult{
+				Columns: []fakeColumn{
+					col("Function", "varchar"), col("Return Type", "varchar"), col("Argument Types", "varchar"),
+					col("Function Type", "varchar"), col("Deterministic", "boolean"), col("Description", "varchar"),
+				},
+				Rows: [][]driver.Value{{"abs", "bigint", "bigint", "scalar", true, "Absolute value"}},
+			})
+			names, values := columns("SHOW FUNCTIONS LIKE 'abs';")
+			Expect(names).To(Equal([]string{"function", "return_type", "argument_types", "function_type", "deterministic", "description"}))
+			Expect(values).To(Equal([]string{"abs", "bigint", "bigint", "scalar", "true", "Absolute value"}))
+		})
+
+		It("names the columns of SHOW SESSION for Postgres clients", func() {
+			fake.On("SHOW SESSION", fakeResult{
+				Columns: []fakeColumn{
+					col("Name", "varchar"), col("Value", "varchar"), col("Default", "varchar"),
+					col("Type", "varchar"), col("Description", "varchar"),
+				},
+				Rows: [][]driver.Value{{"query_max_run_time", "100.00d", "100.00d", "varchar", "Maximum run time of a query"}},
+			})
+			names, values := columns("SHOW SESSION;")
+			Expect(names).To(Equal([]string{"name", "value", "default_value", "type", "description"}))
+			Expect(values[0]).To(Equal("query_max_run_time"))
+		})
+	})
+
+	Describe("DML", func() {
+		BeforeEach(func() {
+			fake.On("INSERT INTO orders SELECT * FROM staged_orders", fakeResult{RowsAffected: 5})
+			fake.On("DELETE FROM orders WHERE status = 'void'", fakeResult{RowsAffected: 3})
+		})
+
+		It("reports the rows affected by INSERT and DELETE", func() {
+			db := server.Connect("hive")
+			defer db.Close()
+
+			result, err := db.Exec("INSERT INTO orders SELECT * FROM staged_orders;")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RowsAffected()).To(Equal(int64(5)))
+
+			result, err = db.Exec("DELETE FROM orders WHERE status = 'void';")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RowsAffected()).To(Equal(int64(3)))
+		})
+
+		It("completes INSERT with the Postgres command tag", func() {
+			client := dialWire(server.addr, "hive")
+			defer client.Close()
+			client.Query("INSERT INTO orders SELECT * FROM staged_orders;")
+			client.Send('S')
+
+			var tags []string
+			for typ, body := client.Read(); typ != 'Z'; typ, body = client.Read() {
+				if typ == 'C' {
+					tags = append(tags, strings.TrimRight(string(body), "\x00"))
+				}
+			}
+			Expect(tags).To(Equal([]string{"INSERT 0 5"}))
+		})
+	})
+
+	Describe("Command tags", func() {
+		It("formats the tags of every command the way Postgres does", func() {
+			for query, tag := range map[string]string{
+				"SELECT * FROM orders":                       "SELECT 2",
+				"  with t AS (SELECT 1) SELECT * FROM t":     "SELECT 2",
+				"INSERT INTO orders VALUES (1)":              "INSERT 0 2",
+				"update orders SET status = 'paid'":          "UPDATE 2",
+				"DELETE FROM orders":                         "DELETE 2",
+				"CREATE TABLE orders (id bigint)":            "CREATE TABLE",
+				"create or replace view v AS SELECT 1":       "CREATE VIEW",
+				"CREATE TABLE copy AS SELECT * FROM orders":  "SELECT 2",
+				"DROP TABLE IF EXISTS orders":                "DROP TABLE",
+				"ALTER TABLE orders ADD COLUMN note varchar": "ALTER TABLE",
+				"CREATE MATERIALIZED VIEW mv AS SELECT 1":    "CREATE MATERIALIZED VIEW",
+				"EXPLAIN SELECT 1":                           "EXPLAIN",
+			} {
+				Expect(pgtrino.CommandTag(query, 2)).To(Equal(tag), query)
+			}
+		})
+
+		It("completes queries and DDL with their tags", func() {
+			fake.On("SELECT id FROM orders", fakeResult{
+				Columns: []fakeColumn{col("id", "bigint")},
+				Rows:    [][]driver.Value{{int64(1)}, {int64(2)}},
+			})
+			fake.On("CREATE TABLE orders (id bigint)", fakeResult{})
+			fake.On("UPDATE orders SET id = 3", fakeResult{RowsAffected: 2})
+			client := dialWire(server.addr, "hive")
+			defer client.Close()
+			client.Query("SELECT id FROM orders;")
+			client.Query("CREATE TABLE orders (id bigint);")
+			client.Query("UPDATE orders SET id = 3;")
+			client.Send('S')
+
+			var tags []string
+			for typ, body := client.Read(); typ != 'Z'; typ, body = client.Read() {
+				if typ == 'C' {
+					tags = append(tags, strings.TrimRight(string(body), "\x00"))
+				}
+			}
+			Expect(tags).To(Equal([]string{"SELECT 2", "CREATE TABLE", "UPDATE 2"}))
+		})
+
+		It("completes DDL without the boolean row Trino answers queries running it with", func() {
+			fake.On("CREATE SCHEMA sales", fakeResult{
+				Columns: []fakeColumn{col("result", "boolean")},
+				Rows:    [][]driver.Value{{true}},
+			})
+			client := dialWire(server.addr, "hive")
+			defer client.Close()
+
+			client.Send('Q', cstring("CREATE SCHEMA sales;"))
+			typ, body := client.Read()
+			Expect(typ).To(Equal(byte('C')))
+			Expect(string(body)).To(Equal("CREATE SCHEMA\x00"))
+			Expect(client.ReadUntil('Z')).To(Equal("Z"))
+			Expect(fake.Committed()).To(Equal([]string{"CREATE SCHEMA sales"}))
+		})
+
+		It("counts the rows of results without columns without sending them", func() {
+			fake.On("SELECT FROM orders", fakeResult{Rows: [][]driver.Value{{}, {}, {}}})
+			client := dialWire(server.addr, "hive")
+			defer client.Close()
+
+			client.Send('Q', cstring("SELECT FROM orders;"))
+			typ, body := client.Read()
+			Expect(typ).To(Equal(byte('C')))
+			Expect(string(body)).To(Equal("SELECT 3\x00"))
+			Expect(client.ReadUntil('Z')).To(Equal("Z"))
+
+			client.Send('P', cstring(""), cstring("SELECT FROM orders;"), []byte{0, 0})
+			client.Send('B', cstring(""), cstring(""), []byte{0, 0, 0, 0, 0, 0})
+			client.Send('D', []byte{'P'}, cstring(""))
+			client.Send('E', cstring(""), []byte{0, 0, 0, 0})
+			client.Send('S')
+			Expect(client.ReadUntil('Z')).To(Equal("12nCZ"))
+		})
+
+		It("sends a complete result for every statement of a simple query", func() {
+			fake.On("SELECT id FROM orders", fakeResult{
+				Columns: []fakeColumn{col("id", "bigint")},
+				Rows:    [][]driver.Value{{int64(1)}, {int64(2)}},
+			})
+			fake.On("SELECT name FROM users WHERE note = 'a;b'", fakeResult{
+				Columns: []fakeColumn{col("name", "varchar")},
+				Rows:    [][]driver.Value{{"ada"}},
+			})
+			client := dialWire(server.addr, "hive")
+			defer client.Close()
+
+			client.Send('Q', cstring("SELECT id FROM orders; SELECT name FROM users WHERE note = 'a;b';"))
+			var (
+				types []byte
+				tags  []string
+			)
+			for typ, body := client.Read(); ; typ, body = client.Read() {
+				types = append(types, typ)
+				if typ == 'C' {
+					tags = append(tags, strings.TrimRight(string(body), "\x00"))
+				}
+				if typ == 'Z' {
+					break
+				}
+			}
+			Expect(string(types)).To(Equal("TDDCTDCZ"))
+			Expect(tags).To(Equal([]string{"SELECT 2", "SELECT 1"}))
+		})
+
+		It("stops the statements of a simple query at the first failing one", func() {
+			fake.On("SELECT id FROM orders", fakeResult{
+				Columns: []fakeColumn{col("id", "bigint")},
+				Rows:    [][]driver.Value{{int64(1)}},
+			})
+			client := dialWire(server.addr, "hive")
+			defer client.Close()
+
+			client.Send('Q', cstring("SELECT id FROM orders; SELECT * FROM missing; SELECT id FROM orders"))
+			Expect(client.ReadUntil('Z')).To(Equal("TDCEZ"))
+			Expect(fake.Queries()).To(HaveLen(2))
+		})
+
+		It("refuses to prepare several statements without running any of them", func() {
+			fake.On("INSERT INTO orders VALUES (1)", fakeResult{RowsAffected: 1})
+			client := dialWire(server.addr, "hive")
+			defer client.Close()
+
+			client.Query("INSERT INTO orders VALUES (1); INSERT INTO orders VALUES (1)")
+			client.Send('S')
+			typ, body := client.Read()
+			Expect(typ).To(Equal(byte('E')))
+			Expect(string(body)).To(ContainSubstring("cannot insert multiple commands into a prepared statement"))
+			Expect(client.ReadUntil('Z')).To(Equal("Z"))
+			Expect(fake.Queries()).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+// errRateLimited is returned for queries rejected by the rate limiter.
+var errRateLimited = psqlerr.WithCode(
+	errors.New("too many queries: client exceeded the rate limit"),
+	codes.ConfigurationLimitExceeded,
+)
+
+// maxIdleBuckets is the number of buckets kept before full buckets of idle
+// clients are dropped.
+const maxIdleBuckets = 1024
+
+// rateLimiter limits the queries per second of every client address with a
+// token bucket. A bucket holds up to one second of queries and refills at
+// the configured rate.
+type rateLimiter struct {
+	rate float64
+	now  func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// newRateLimiter returns a rate limiter allowing qps queries per second per
+// client, or nil when qps disables it. A nil limiter allows every query.
+func newRateLimiter(qps int) *rateLimiter {
+	if qps <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(qps), now: time.Now, buckets: map[string]*tokenBucket{}}
+}
+
+// allow takes a token from the bucket of the client, failing with
+// errRateLimited if it is empty.
+func (l *rateLimiter) allow(client string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if len(l.buckets) > maxIdleBuckets {
+		l.dropFull(now)
+	}
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.rate, updated: now}
+		l.buckets[client] = bucket
+	}
+	l.refill(bucket, now)
+	if bucket.tokens < 1 {
+		return errRateLimited
+	}
+	bucket.tokens--
+	return nil
+}
+
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.updated).Seconds()
+	bucket.tokens = math.Min(l.rate, bucket.tokens+elapsed*l.rate)
+	bucket.updated = now
+}
+
+// dropFull removes the buckets that refilled completely, as their clients
+// would start over with a full bucket anyway.
+func (l *rateLimiter) dropFull(now time.Time) {
+	for client, bucket := range l.buckets {
+		l.refill(bucket, now)
+		if bucket.tokens >= l.rate {
+			delete(l.buckets, client)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main_test
+
+import (
+	"database/sql/driver"
+	"time"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rate limiter", func() {
+	var (
+		now     time.Time
+		limiter *pgtrino.RateLimiter
+	)
+
+	BeforeEach(func() {
+		now = time.Now()
+		limiter = pgtrino.NewRateLimiter(2, func() time.Time { return now })
+	})
+
+	It("allows a second of queries at once and refills at the configured rate", func() {
+		Expect(limiter.Allow("10.0.0.1")).To(Succeed())
+		Expect(limiter.Allow("10.0.0.1")).To(Succeed())
+		Expect(limiter.Allow("10.0.0.1")).To(MatchError(pgtrino.ErrRateLimited))
+
+		now = now.Add(500 * time.Millisecond)
+		Expect(limiter.Allow("10.0.0.1")).To(Succeed())
+		Expect(limiter.Allow("10.0.0.1")).To(MatchError(pgtrino.ErrRateLimited))
+	})
+
+	It("limits every client address separately", func() {
+		Expect(limiter.Allow("10.0.0.1")).To(Succeed())
+		Expect(limiter.Allow("10.0.0.1")).To(Succeed())
+		Expect(limiter.Allow("10.0.0.2")).To(Succeed())
+	})
+
+	It("is disabled when the rate is zero", func() {
+		disabled := pgtrino.NewRateLimiter(0, time.Now)
+		for i := 0; i < 100; i++ {
+			Expect(disabled.Allow("10.0.0.1")).To(Succeed())
+		}
+	})
+
+	It("throttles a client over the limit without forwarding its queries", func() {
+		fake := newFakeTrino()
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		server := startServer(fake, &config.Config{RateLimitQPS: 3})
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		var (
+			value int
+			err   error
+		)
+		for i := 0; i < 10 && err == nil; i++ {
+			err = db.QueryRow("SELECT 1;").Scan(&value)
+		}
+		Expect(err).To(BeAssignableToTypeOf(&pq.Error{}))
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("53400"))
+		Expect(len(fake.Queries())).To(BeNumerically("<", 10))
+	})
+})
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"pg2trino/config"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+// rewrites returns the rewrites translating Postgres syntax Trino lacks
+// into its Trino equivalent, which are applied in order to every query
+// forwarded to Trino. The JSON operators are only rewritten when the
+// configuration asks for it, as x -> 'a' is a lambda expression in Trino.
+func rewrites(cfg *config.Config) []func(query string) string {
+	rewrites := []func(query string) string{rewriteRowFields}
+	if cfg.JSONOperators {
+		rewrites = append(rewrites, rewriteJSONOperators)
+	}
+	return append(rewrites, rewriteCasts, rewriteSelectInto)
+}
+
+// rewriteQuery applies all rewrites to query. Unquoted identifiers are
+// folded to lowercase first when the configuration asks for it.
+func rewriteQuery(query string, cfg *config.Config) string {
+	if cfg.FoldIdentifiers {
+		query = foldIdentifiers(query)
+	}
+	for _, rewrite := range rewrites(cfg) {
+		query = rewrite(query)
+	}
+	return query
+}
+
+// foldIdentifiers lowercases query outside of string literals, quoted
+// identifiers and comments, the way Postgres folds unquoted identifiers.
+// Keywords are folded too, which does not change their meaning.
+func foldIdentifiers(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(query[i:], "--") || strings.HasPrefix(query[i:], "/*"):
+			end := commentEnd(query, i)
+			b.WriteString(query[i:end])
+			i = end - 1
+			continue
+		case 'A' <= c && c <= 'Z':
+			c += 'a' - 'A'
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+var (
+	// jsonOperand matches a possibly qualified column followed by a chain of
+	// -> and ->> operators with string keys or array indexes.
+	jsonOperand = regexp.MustCompile(`((?:"(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*)(?:\.(?:"(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*))*)((?:\s*->>?\s*(?:'(?:[^']|'')*'|\d+))+)`)
+	// jsonStep matches a single -> or ->> operator and its key or index.
+	jsonStep = regexp.MustCompile(`(->>?)\s*(?:'((?:[^']|'')*)'|(\d+))`)
+	// plainKey matches JSON object keys usable in dot notation.
+	plainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// commentEnd returns the offset following the -- or /* */ comment starting
+// at offset start of query, which is the end of query if it is not closed.
+func commentEnd(query string, start int) int {
+	closing := "*/"
+	if strings.HasPrefix(query[start:], "--") {
+		closing = "\n"
+	}
+	end := strings.Index(query[start+2:], closing)
+	if end < 0 {
+		return len(query)
+	}
+	return start + 2 + end + len(closing)
+}
+
+// rewriteJSONOperators translates the Postgres JSON operators into Trino's
+// JSON functions: col->'a'->'b' becomes json_extract(col, '$.a.b') and a
+// chain ending in ->> becomes json_extract_scalar.
+func rewriteJSONOperators(query string) string {
+	return replaceOutsideLiterals(query, jsonOperand, func(match []string, _ int) string {
+		path := "$"
+		function := "json_extract"
+		for _, step := range jsonStep.FindAllStringSubmatch(match[2], -1) {
+			key := strings.ReplaceAll(step[2], "''", "'")
+			switch {
+			case step[3] != "":
+				path += "[" + step[3] + "]"
+			case plainKey.MatchString(key):
+				path += "." + key
+			default:
+				path += `["` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(key) + `"]`
+			}
+			if step[1] == "->>" {
+				function = "json_extract_scalar"
+			} else {
+				function = "json_extract"
+			}
+		}
+		return function + "(" + match[1] + ", '" + strings.ReplaceAll(path, "'", "''") + "')"
+	})
+}
+
+// rowFieldAccess matches the Postgres (col).field access of a field of a
+// composite column, with the character preceding the parenthesis, which
+// must not end a function name.
+var rowFieldAccess = regexp.MustCompile(`(^|[^A-Za-z0-9_$"\])])\(\s*((?:"(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*)(?:\.(?:"(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*))*)\s*\)\.("(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*)`)
+
+// rewriteRowFields translates the Postgres (col).field access of a ROW
+// field into Trino's col.field. Nested accesses such as ((col).a).b are
+// rewritten from the inside out.
+func rewriteRowFields(query string) string {
+	for {
+		rewritten := replaceOutsideLiterals(query, rowFieldAccess, func(match []string, _ int) string {
+			return match[1] + match[2] + "." + match[3]
+		})
+		if rewritten == query {
+			return query
+		}
+		query = rewritten
+	}
+}
+
+// replaceOutsideLiterals replaces the matches of pattern in query that do
+// not start inside a string literal with the result of replace, which is
+// passed the submatches and the offset of the match.
+func replaceOutsideLiterals(query string, pattern *regexp.Regexp, replace func(match []string, at int) string) string {
+	literals := literalRanges(query)
+	var b strings.Builder
+	last := 0
+	for _, loc := range pattern.FindAllStringSubmatchIndex(query, -1) {
+		if insideRanges(literals, loc[0]) {
+			continue
+		}
+		match := make([]string, len(loc)/2)
+		for i := range match {
+			if loc[2*i] >= 0 {
+				match[i] = query[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		b.WriteString(query[last:loc[0]])
+		b.WriteString(replace(match, loc[0]))
+		last = loc[1]
+	}
+	b.WriteString(query[last:])
+	return b.String()
+}
+
+// literalRanges returns the start and end offsets of the single-quoted
+// string literals of query.
+func literalRanges(query string) [][2]int {
+	var ranges [][2]int
+	start := -1
+	for i := 0; i < len(query); i++ {
+		if query[i] != '\'' {
+			continue
+		}
+		switch {
+		case start < 0:
+			start = i
+		case i+1 < len(query) && query[i+1] == '\'':
+			i++
+		default:
+			ranges = append(ranges, [2]int{start, i + 1})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		ranges = append(ranges, [2]int{start, len(query)})
+	}
+	return ranges
+}
+
+// insideRanges reports whether offset lies within one of the ranges.
+func insideRanges(ranges [][2]int, offset int) bool {
+	for _, r := range ranges {
+		if offset >= r[0] && offset < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// intoKeyword matches the INTO keyword of SELECT ... INTO.
+	intoKeyword = regexp.MustCompile(`(?i)^INTO\b`)
+	// intoTarget matches the table created by SELECT ... INTO, with its
+	// optional TEMPORARY or UNLOGGED modifier and TABLE keyword.
+	intoTarget = regexp.MustCompile(`(?is)^INTO\s+(?:((?:(?:LOCAL|GLOBAL)\s+)?TEMP(?:ORARY)?)\s+|UNLOGGED\s+)?(?:TABLE\s+)?((?:"(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*)(?:\.(?:"(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*))*)`)
+)
+
+// errSelectIntoTemp rejects SELECT ... INTO a temporary table.
+var errSelectIntoTemp = psqlerr.WithCode(errors.New("SELECT INTO a temporary table is not supported, as Trino has no temporary tables"), codes.FeatureNotSupported)
+
+// parseSelectInto returns the CREATE TABLE AS statement equivalent to a
+// SELECT ... INTO table statement, and whether the table is temporary, or
+// false if query is not one.
+func parseSelectInto(query string) (string, bool, bool) {
+	match := selectKeyword.FindStringIndex(query)
+	if match == nil {
+		return "", false, false
+	}
+	list := query[match[1]:]
+	into := topLevelIndex(list, intoKeyword)
+	if into < 0 {
+		return "", false, false
+	}
+	if from := topLevelIndex(list, fromKeyword); from >= 0 && from < into {
+		return "", false, false
+	}
+	target := intoTarget.FindStringSubmatch(list[into:])
+	if target == nil {
+		return "", false, false
+	}
+	rest := list[into+len(target[0]):]
+	selection := strings.TrimRightFunc(query[:match[1]]+list[:into], unicode.IsSpace)
+	return "CREATE TABLE " + target[2] + " AS " + strings.TrimLeftFunc(selection, unicode.IsSpace) + rest, target[1] != "", true
+}
+
+// rewriteSelectInto translates SELECT ... INTO table, which Trino lacks,
+// into CREATE TABLE table AS SELECT .... Temporary tables are rejected
+// before rewriting, by checkSelectInto.
+func rewriteSelectInto(query string) string {
+	if ctas, temp, ok := parseSelectInto(query); ok && !temp {
+		return ctas
+	}
+	return query
+}
+
+// checkSelectInto fails SELECT ... INTO a temporary table, which Trino has
+// no equivalent of.
+func checkSelectInto(query string) error {
+	if _, temp, ok := parseSelectInto(query); ok && temp {
+		return errSelectIntoTemp
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package main_test
+
+import (
+	"database/sql/driver"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Query rewrites", func() {
+	Describe("JSON operators", func() {
+		It("rewrites -> to json_extract", func() {
+			Expect(pgtrino.RewriteQuery("SELECT data->'user' FROM events", &config.Config{JSONOperators: true})).
+				To(Equal("SELECT json_extract(data, '$.user') FROM events"))
+		})
+
+		It("rewrites ->> to json_extract_scalar", func() {
+			Expect(pgtrino.RewriteQuery("SELECT e.data ->> 'name' FROM events e WHERE e.data->>'kind' = 'click'", &config.Config{JSONOperators: true})).
+				To(Equal("SELECT json_extract_scalar(e.data, '$.name') FROM events e WHERE json_extract_scalar(e.data, '$.kind') = 'click'"))
+		})
+
+		It("rewrites chained operators into a single path", func() {
+			Expect(pgtrino.RewriteQuery(`SELECT "Data"->'items'->0->>'first name' FROM events`, &config.Config{JSONOperators: true})).
+				To(Equal(`SELECT json_extract_scalar("Data", '$.items[0]["first name"]') FROM events`))
+		})
+
+		It("leaves the operators alone without JSON_OPERATORS", func() {
+			for _, query := range []string{
+				"SELECT data->'user' FROM events",
+				"SELECT filter(tags, t -> 'a') FROM t",
+			} {
+				Expect(pgtrino.RewriteQuery(query, &config.Config{})).To(Equal(query))
+			}
+		})
+
+		It("leaves string literals alone", func() {
+			query := "SELECT 'data->''key''' FROM events"
+			Expect(pgtrino.RewriteQuery(query, &config.Config{JSONOperators: true})).To(Equal(query))
+		})
+
+		It("sends the rewritten query to Trino", func() {
+			fake := newFakeTrino()
+			fake.On("SELECT json_extract_scalar(data, '$.user.id') FROM events", fakeResult{
+				Columns: []fakeColumn{col("_col0", "varchar")},
+				Rows:    [][]driver.Value{{"42"}},
+			})
+			server := startServer(fake, &config.Config{JSONOperators: true})
+			defer server.Close()
+			db := server.Connect("hive")
+			defer db.Close()
+
+			var id string
+			Expect(db.QueryRow("SELECT data->'user'->>'id' FROM events;").Scan(&id)).To(Succeed())
+			Expect(id).To(Equal("42"))
+		})
+	})
+
+	Describe("ROW field access", func() {
+		It("rewrites (col).field to col.field", func() {
+			Expect(pgtrino.RewriteQuery(`SELECT (address).city, ( o.address ).zip, ((item).price)."Net" FROM orders o`, &config.Config{})).
+				To(Equal(`SELECT address.city, o.address.zip, item.price."Net" FROM orders o`))
+		})
+
+		It("leaves function calls and string literals alone", func() {
+			query := "SELECT max(t).x, '(a).b' FROM t"
+			Expect(pgtrino.RewriteQuery(query, &config.Config{})).To(Equal(query))
+		})
+
+		It("sends the field access of a ROW column to Trino", func() {
+			fake := newFakeTrino()
+			fake.On("SELECT address.city FROM customers WHERE address.zip = '10115'", fakeResult{
+				Columns: []fakeColumn{col("city", "varchar")},
+				Rows:    [][]driver.Value{{"Berlin"}},
+			})
+			server := startServer(fake, &config.Config{})
+			defer server.Close()
+			db := server.Connect("hive")
+			defer db.Close()
+
+			var city string
+			Expect(db.QueryRow("SELECT (address).city FROM customers WHERE (address).zip = '10115';").Scan(&city)).To(Succeed())
+			Expect(city).To(Equal("Berlin"))
+		})
+	})
+
+	Describe("casts", func() {
+		It("rewrites :: casts to Postgres type names into CAST", func() {
+			Expect(pgtrino.RewriteQuery("SELECT id::int4, name::text FROM users", &config.Config{})).
+				To(Equal("SELECT CAST(id AS integer), CAST(name AS varchar) FROM users"))
+		})
+
+		It("normalizes the type names of CAST", func() {
+			Expect(pgtrino.RewriteQuery("SELECT CAST(paid AS bool), try_cast(at AS timestamptz) FROM orders", &config.Config{})).
+				To(Equal("SELECT CAST(paid AS boolean), try_cast(at AS timestamp with time zone) FROM orders"))
+		})
+
+		It("rewrites casts of literals, calls and expressions", func() {
+			Expect(pgtrino.RewriteQuery("SELECT '1.5'::numeric(10, 2), count(*)::float8, (a + b)::int8::text, ARRAY[1]::int2[] FROM t", &config.Config{})).
+				To(Equal("SELECT CAST('1.5' AS decimal(10,2)), CAST(count(*) AS double), CAST(CAST((a + b) AS bigint) AS varchar), CAST(ARRAY[1] AS array(smallint)) FROM t"))
+		})
+
+		It("leaves string literals and aliases alone", func() {
+			query := "SELECT 'a::int4' AS text, x AS bool FROM t"
+			Expect(pgtrino.RewriteQuery(query, &config.Config{})).To(Equal(query))
+		})
+
+		It("sends the rewritten query to Trino", func() {
+			fake := newFakeTrino()
+			fake.On("SELECT CAST('42' AS integer)", fakeResult{
+				Columns: []fakeColumn{col("_col0", "integer")},
+				Rows:    [][]driver.Value{{int64(42)}},
+			})
+			server := startServer(fake, &config.Config{})
+			defer server.Close()
+			db := server.Connect("hive")
+			defer db.Close()
+
+			var value int
+			Expect(db.QueryRow("SELECT '42'::int4;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal(42))
+		})
+	})
+
+	Describe("identifier folding", func() {
+		fold := &config.Config{FoldIdentifiers: true}
+
+		It("lowercases mixed-case unquoted identifiers", func() {
+			Expect(pgtrino.RewriteQuery("SELECT OrderId, o.CustomerName FROM Sales.Orders o", fold)).
+				To(Equal("select orderid, o.customername from sales.orders o"))
+		})
+
+		It("keeps quoted identifiers and string literals", func() {
+			Expect(pgtrino.RewriteQuery(`SELECT "OrderId", "Say ""Hi""" FROM Orders WHERE Status = 'Open''S'`, fold)).
+				To(Equal(`select "OrderId", "Say ""Hi""" from orders where status = 'Open''S'`))
+		})
+
+		It("is disabled by default", func() {
+			query := "SELECT OrderId FROM Orders"
+			Expect(pgtrino.RewriteQuery(query, &config.Config{})).To(Equal(query))
+		})
+
+		It("leaves comments alone, even with apostrophes", func() {
+			Expect(pgtrino.RewriteQuery("SELECT OrderId -- don't fold Me\nFROM Orders /* it's Kept */ WHERE Status = 'Open'", fold)).
+				To(Equal("select orderid -- don't fold Me\nfrom orders /* it's Kept */ where status = 'Open'"))
+		})
+
+		It("sends the folded query to Trino", func() {
+			fake := newFakeTrino()
+			fake.On(`select orderid, "Total" from orders`, fakeResult{
+				Columns: []fakeColumn{col("orderid", "bigint"), col("Total", "bigint")},
+				Rows:    [][]driver.Value{{int64(1), int64(9)}},
+			})
+			server := startServer(fake, fold)
+			defer server.Close()
+			db := server.Connect("hive")
+			defer db.Close()
+
+			var id, total int64
+			Expect(db.QueryRow(`SELECT OrderId, "Total" FROM Orders;`).Scan(&id, &total)).To(Succeed())
+			Expect([]int64{id, total}).To(Equal([]int64{1, 9}))
+		})
+	})
+
+	Describe("SELECT INTO", func() {
+		It("rewrites SELECT ... INTO to CREATE TABLE AS", func() {
+			Expect(pgtrino.RewriteQuery("SELECT id, total INTO archive.old_orders FROM orders WHERE year < 2020", &config.Config{})).
+				To(Equal("CREATE TABLE archive.old_orders AS SELECT id, total FROM orders WHERE year < 2020"))
+			Expect(pgtrino.RewriteQuery(`select * into table "Copy" from orders`, &config.Config{})).
+				To(Equal(`CREATE TABLE "Copy" AS select * from orders`))
+		})
+
+		It("leaves INTO in subqueries, literals and INSERT alone", func() {
+			for _, query := range []string{
+				"SELECT 'x INTO y' FROM orders",
+				"INSERT INTO orders SELECT * FROM staged",
+				"SELECT * FROM (SELECT 1) t",
+			} {
+				Expect(pgtrino.RewriteQuery(query, &config.Config{})).To(Equal(query))
+			}
+		})
+
+		It("creates the table on Trino and rejects temporary tables", func() {
+			fake := newFakeTrino()
+			fake.On("CREATE TABLE copy AS SELECT * FROM orders", fakeResult{RowsAffected: 7})
+			server := startServer(fake, &config.Config{})
+			defer server.Close()
+			db := server.Connect("hive")
+			defer db.Close()
+
+			result, err := db.Exec("SELECT * INTO copy FROM orders;")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RowsAffected()).To(Equal(int64(7)))
+
+			_, err = db.Exec("SELECT * INTO TEMP scratch FROM orders;")
+			Expect(err).To(MatchError(ContainSubstring("SELECT INTO a temporary table is not supported")))
+			Expect(err.(*pq.Error).Code).To(BeEquivalentTo("0A000"))
+			Expect(fake.Queries()).To(HaveLen(1))
+		})
+	})
+})
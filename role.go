@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"regexp"
+	"strings"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+)
+
+// roleStatement matches the Postgres SET ROLE { name | NONE } and RESET ROLE
+// statements.
+var roleStatement = regexp.MustCompile(`(?is)^\s*(?:SET\s+(?:SESSION\s+|LOCAL\s+)?ROLE\s+(.+?)|RESET\s+ROLE)\s*$`)
+
+// parseRole returns the role of a SET ROLE statement, empty for SET ROLE
+// NONE and RESET ROLE, or false if query is not one. Unquoted role names are
+// folded to lowercase.
+func parseRole(query string) (string, bool) {
+	match := roleStatement.FindStringSubmatch(query)
+	if match == nil {
+		return "", false
+	}
+	role := match[1]
+	switch {
+	case role == "" || strings.EqualFold(role, "NONE"):
+		return "", true
+	case len(role) >= 2 && role[0] == '"' && role[len(role)-1] == '"':
+		return strings.ReplaceAll(role[1:len(role)-1], `""`, `"`), true
+	case role[0] == '\'':
+		return unquote(role), true
+	default:
+		return strings.ToLower(role), true
+	}
+}
+
+// setRole answers SET ROLE and RESET ROLE by storing the role in the
+// session, which enables it in the session catalog for the following
+// queries.
+func setRole(ctx context.Context, role string) wire.PreparedStatements {
+	SessionFromContext(ctx).SetRole(role)
+	if role == "" {
+		return commandComplete("RESET")
+	}
+	return commandComplete("SET")
+}
+
+// SetRole changes the Trino role of the session. An empty role restores the
+// default roles of the user.
+func (s *Session) SetRole(role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.role = role
+}
+
+// Role returns the Trino role set with SET ROLE, or an empty string if none
+// is set.
+func (s *Session) Role() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.role
+}
+
+// roleHeader returns the Trino header enabling the session role in its
+// catalog, the equivalent of SET ROLE name IN catalog, or false if no role
+// is set.
+func (s *Session) roleHeader() (any, bool) {
+	role := s.Role()
+	if role == "" {
+		return nil, false
+	}
+	catalog := s.Catalog
+	if catalog == "" {
+		catalog = s.Config().TrinoCatalog
+	}
+	return sql.Named("X-Trino-Role", url.QueryEscape(catalog)+"="+url.QueryEscape("ROLE{"+role+"}")), true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+)
+
+// qualifiedTable matches a schema-qualified table name following FROM,
+// JOIN, INTO, UPDATE or TABLE. The third group is set when the name has a
+// third part, as in catalog.schema.table.
+var qualifiedTable = regexp.MustCompile(`(?i)\b(?:FROM|JOIN|INTO|UPDATE|TABLE)\s+("(?:[^"]|"")+"|[a-z_][a-z0-9_$]*)\s*\.\s*("(?:[^"]|"")+"|[a-z_][a-z0-9_$]*)(\s*\.)?`)
+
+// querySchema returns the schema of the first two-part schema.table name of
+// query, or false if it has none. Unquoted names are folded to lowercase.
+func querySchema(query string) (string, bool) {
+	for _, match := range qualifiedTable.FindAllStringSubmatch(query, -1) {
+		if match[3] != "" {
+			continue
+		}
+		schema := match[1]
+		if schema[0] == '"' {
+			return strings.ReplaceAll(schema[1:len(schema)-1], `""`, `"`), true
+		}
+		return strings.ToLower(schema), true
+	}
+	return "", false
+}
+
+// schemaHeader returns the Trino header selecting the session schema of
+// query, or false if the schema of the connection applies. For the catalogs
+// listed in SCHEMA_PER_QUERY_CATALOGS that require one, it is the schema of
+// the schema-qualified names of query. Otherwise it is the first schema of
+// the search_path the session set, so that resetting search_path restores
+// the configured schema. The header only applies to that query.
+func (s *Session) schemaHeader(query string) (any, bool) {
+	catalog := s.Catalog
+	if catalog == "" {
+		catalog = s.Config().TrinoCatalog
+	}
+	required := false
+	for _, c := range s.Config().SchemaPerQueryCatalogs {
+		required = required || strings.EqualFold(c, catalog)
+	}
+	if required {
+		if schema, ok := querySchema(query); ok {
+			return sql.Named("X-Trino-Schema", schema), true
+		}
+	}
+	if schema, ok := s.searchPathSchema(); ok {
+		return sql.Named("X-Trino-Schema", schema), true
+	}
+	return nil, false
+}
+
+// searchPathSchema returns the first schema of the search_path set in the
+// session, or false if there is none. The "$user", public and pg_catalog
+// schemas Postgres clients default to are skipped, so that the configured
+// schema applies in their place. Unquoted names are folded to lowercase.
+func (s *Session) searchPathSchema() (string, bool) {
+	for _, schema := range strings.Split(s.Setting("search_path"), ",") {
+		schema = strings.TrimSpace(schema)
+		switch {
+		case len(schema) >= 2 && schema[0] == '"' && schema[len(schema)-1] == '"':
+			schema = strings.ReplaceAll(schema[1:len(schema)-1], `""`, `"`)
+		case stringLiteral.MatchString(schema):
+			schema = unquote(schema)
+		default:
+			schema = strings.ToLower(schema)
+		}
+		switch schema {
+		case "", "$user", "public", "pg_catalog":
+		default:
+			return schema, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+// sequenceCall matches a call of a Postgres sequence function.
+var sequenceCall = regexp.MustCompile(`(?i)\b(?:pg_catalog\.)?(nextval|currval|setval|lastval)\s*\(`)
+
+// checkSequenceFunctions fails queries calling the sequence functions of
+// Postgres, such as nextval('x'), as Trino has no sequences. Without the
+// check, Trino reports them as unknown functions, which does not tell
+// clients probing for sequences that they are unsupported.
+func checkSequenceFunctions(query string) error {
+	literals := literalRanges(query)
+	for _, call := range sequenceCall.FindAllStringSubmatchIndex(query, -1) {
+		if insideRanges(literals, call[0]) {
+			continue
+		}
+		name := strings.ToLower(query[call[2]:call[3]])
+		err := psqlerr.WithCode(fmt.Errorf("function %s is not supported, as Trino has no sequences", name), codes.FeatureNotSupported)
+		return psqlerr.WithHint(err, "Generate the values in the client, or use a column computed from the data, such as row_number().")
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+var (
+	// drainedConnections counts the connections closed after their queries
+	// completed during shutdown.
+	drainedConnections = expvar.NewInt("pg2trino_drained_connections")
+	// forceClosedConnections counts the connections closed during shutdown
+	// while still running a query.
+	forceClosedConnections = expvar.NewInt("pg2trino_force_closed_connections")
+	// activeConnections is the number of open client connections.
+	activeConnections = expvar.NewInt("pg2trino_active_connections")
+)
+
+// Server is a Postgres wire server keeping track of its client connections,
+// so they can be drained on shutdown.
+type Server struct {
+	*wire.Server
+
+	// allowlist are the address ranges clients may connect from. An empty
+	// allowlist allows every client.
+	allowlist []netip.Prefix
+	// logConnections logs every accepted and closed connection.
+	logConnections bool
+	// keepAlive is the TCP keepalive period of client connections, with
+	// zero keeping the default and a negative period disabling them.
+	keepAlive time.Duration
+	// backlog is the length of the accept queue of the listeners opened
+	// by ListenAndServe, with zero keeping the system default.
+	backlog int
+	// tlsRequired refuses the clients of TLS listeners that did not
+	// upgrade their connection to TLS.
+	tlsRequired bool
+	// draining refuses new connections while the open ones keep being
+	// served.
+	draining atomic.Bool
+
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+}
+
+// trackedConn is a client connection registered with its server until it
+// is closed.
+type trackedConn struct {
+	net.Conn
+	server   *Server
+	session  *Session
+	accepted time.Time
+	once     sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.server.mu.Lock()
+		delete(c.server.conns, c)
+		session := c.session
+		c.server.mu.Unlock()
+		activeConnections.Add(-1)
+		// A Trino transaction left open by the client is rolled back.
+		if session != nil {
+			if _, err := session.endTransaction(context.Background(), false); err != nil {
+				log.Printf("Failed to roll back the transaction of %s: %s", c.RemoteAddr(), err)
+			}
+		}
+		if c.server.logConnections {
+			log.Printf("Closed connection from %s after %s", c.RemoteAddr(), time.Since(c.accepted).Round(time.Millisecond))
+		}
+	})
+	return c.Conn.Close()
+}
+
+// running reports whether the connection is running a query.
+func (c *trackedConn) running() bool {
+	c.server.mu.Lock()
+	session := c.session
+	c.server.mu.Unlock()
+	return session != nil && session.Running()
+}
+
+// cancel cancels the query the connection is running, so Trino stops it
+// instead of running it for a client that is gone.
+func (c *trackedConn) cancel() {
+	c.server.mu.Lock()
+	session := c.session
+	c.server.mu.Unlock()
+	if session != nil && session.cancelStatement() {
+		log.Printf("Canceled the running query of %s on shutdown", c.RemoteAddr())
+	}
+}
+
+// trackingListener registers every accepted connection with its server.
+type trackingListener struct {
+	net.Listener
+	server *Server
+}
+
+// Accept returns the next connection from an allowed client address.
+// Connections from other addresses are closed before the handshake, and
+// every connection while the server is draining is refused, see
+// refuseDraining.
+func (l trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	for err == nil {
+		if l.server.Draining() {
+			log.Printf("Refused connection from %s: draining", conn.RemoteAddr())
+			go l.server.refuseDraining(conn)
+		} else if !l.server.allows(conn.RemoteAddr()) {
+			log.Printf("Refused connection from %s: address not in CLIENT_ALLOWLIST", conn.RemoteAddr())
+			_ = conn.Close()
+		} else {
+			break
+		}
+		conn, err = l.Listener.Accept()
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.server.setKeepAlive(conn)
+	tracked := &trackedConn{Conn: conn, server: l.server, accepted: time.Now()}
+	l.server.mu.Lock()
+	l.server.conns[tracked] = struct{}{}
+	l.server.mu.Unlock()
+	activeConnections.Add(1)
+	if l.server.logConnections {
+		log.Printf("Accepted connection from %s", conn.RemoteAddr())
+	}
+	return tracked, nil
+}
+
+// refuseTimeout bounds the handshake of the connections refused while the
+// server is draining.
+const refuseTimeout = 5 * time.Second
+
+// errDraining tells the clients connecting while the server is draining
+// why their connection is refused.
+var errDraining = psqlerr.WithSeverity(
+	psqlerr.WithCode(errors.New("the database system is not accepting connections"), codes.CannotConnectNow),
+	psqlerr.LevelFatal,
+)
+
+// refuseDraining answers the startup message of a connection accepted
+// while the server is draining with the cannot_connect_now error, as
+// Postgres does while shutting down, so the client reports why it is
+// refused and may retry elsewhere, and closes the connection.
+func (s *Server) refuseDraining(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(refuseTimeout))
+	conn, version, _, err := s.Server.Handshake(conn)
+	if err != nil || version == types.VersionCancel {
+		return
+	}
+	_ = wire.ErrorCode(buffer.NewWriter(slog.Default(), conn), errDraining)
+}
+
+// parseAllowlist parses the CIDR ranges of CLIENT_ALLOWLIST. Single
+// addresses are accepted as ranges of one address.
+func parseAllowlist(ranges []string) ([]netip.Prefix, error) {
+	allowlist := make([]netip.Prefix, 0, len(ranges))
+	for _, r := range ranges {
+		prefix, err := netip.ParsePrefix(r)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(r)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid CLIENT_ALLOWLIST range %q: %w", r, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		allowlist = append(allowlist, prefix.Masked())
+	}
+	return allowlist, nil
+}
+
+// allows reports whether a client may connect from addr.
+func (s *Server) allows(addr net.Addr) bool {
+	if len(s.allowlist) == 0 {
+		return true
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := addrPort.Addr().Unmap()
+	for _, prefix := range s.allowlist {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListenAndServe opens a listener on the given address and serves clients
+// on it.
+func (s *Server) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if s.backlog > 0 {
+		if err := setBacklog(listener, s.backlog); err != nil {
+			_ = listener.Close()
+			return fmt.Errorf("failed to set the listen backlog of %s: %w", address, err)
+		}
+	}
+	return s.Serve(listener)
+}
+
+// setKeepAlive applies the keepalive period of TCP_KEEPALIVE to a client
+// connection. Other connections than TCP ones are left as they are.
+func (s *Server) setKeepAlive(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok || s.keepAlive == 0 {
+		return
+	}
+	err := tcp.SetKeepAlive(s.keepAlive > 0)
+	if err == nil && s.keepAlive > 0 {
+		err = tcp.SetKeepAlivePeriod(s.keepAlive)
+	}
+	if err != nil {
+		log.Printf("Failed to set the keepalive of %s: %s", conn.RemoteAddr(), err)
+	}
+}
+
+// Serve accepts and serves client connections on listener until the server
+// is closed.
+func (s *Server) Serve(listener net.Listener) error {
+	return s.Server.Serve(trackingListener{Listener: listener, server: s})
+}
+
+// ActiveConnections returns the number of open client connections of the
+// server.
+func (s *Server) ActiveConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// SetDraining turns the draining mode of the server on or off. A draining
+// server refuses new connections and keeps serving the open ones, so a
+// rolling deploy can move clients to another instance without
+// interrupting their queries.
+func (s *Server) SetDraining(draining bool) {
+	s.draining.Store(draining)
+}
+
+// Draining reports whether the server refuses new connections.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// watchDrainSignal toggles the draining mode of servers on every drain
+// signal, SIGUSR1 where the platform has it, until the returned function
+// is called.
+func watchDrainSignal(servers []*Server) func() {
+	if len(drainSignals) == 0 {
+		return func() {}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, drainSignals...)
+	done := make(chan struct{})
+	go func() {
+		draining := false
+		for {
+			select {
+			case <-signals:
+				draining = !draining
+				for _, server := range servers {
+					server.SetDraining(draining)
+				}
+				if draining {
+					log.Println("Draining: refusing new connections")
+				} else {
+					log.Println("Stopped draining: accepting new connections")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// checkTLS refuses a client connection of a TLS listener that did not
+// upgrade to TLS with TLS_REQUIRED. It runs before the client is
+// authenticated, so plaintext clients never get to send queries. Clients
+// choose whether they ask for TLS with their sslmode: require and prefer
+// upgrade the connection, while disable keeps it plaintext.
+func (s *Server) checkTLS(conn io.Writer) error {
+	if !s.tlsRequired || len(s.Server.Certificates) == 0 {
+		return nil
+	}
+	if _, ok := conn.(*tls.Conn); ok {
+		return nil
+	}
+	addr := "unknown address"
+	if conn, ok := conn.(net.Conn); ok {
+		addr = conn.RemoteAddr().String()
+	}
+	err := fmt.Errorf("connection from %s refused: TLS is required", addr)
+	err = psqlerr.WithCode(err, codes.InvalidAuthorizationSpecification)
+	return psqlerr.WithHint(err, "Connect with sslmode=require.")
+}
+
+// attach links a session to the tracked connection it is served on.
+func (s *Server) attach(session *Session) {
+	conn := session.conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tracked, ok := conn.(*trackedConn)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tracked.session = session
+}
+
+// Shutdown gracefully closes the server. It stops accepting connections,
+// waits up to timeout for running queries to complete and closes the
+// remaining connections. Connections still running a query when the
+// timeout expires have their Trino query canceled and are force-closed. It
+// returns the number of drained and force-closed connections.
+func (s *Server) Shutdown(timeout time.Duration) (int, int) {
+	s.mu.Lock()
+	conns := make([]*trackedConn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+
+	closed := make(chan struct{})
+	go func() {
+		_ = s.Server.Close()
+		close(closed)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-closed:
+	case <-timer.C:
+	}
+
+	var drained, forced int
+	for _, conn := range conns {
+		if conn.running() {
+			conn.cancel()
+			forced++
+		} else {
+			drained++
+		}
+		_ = conn.Close()
+	}
+	drainedConnections.Add(int64(drained))
+	forceClosedConnections.Add(int64(forced))
+	log.Printf("Shutdown complete: connections=%d drained=%d force_closed=%d timeout=%s", len(conns), drained, forced, timeout)
+	return drained, forced
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"maps"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"pg2trino/config"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// Session holds the state of a single Postgres client connection.
+type Session struct {
+	// Catalog is the Trino catalog selected by the client's startup database.
+	Catalog string
+	// ApplicationName is the application_name the client connected with.
+	ApplicationName string
+
+	config        *config.Config
+	conn          net.Conn
+	writer        *buffer.Writer
+	reader        *buffer.Reader
+	mu            sync.Mutex
+	inTransaction bool
+	tx            *trinoTransaction
+	failed        bool
+	running       bool
+	cancel        context.CancelFunc
+	idleTimer     *time.Timer
+	settings      map[string]string
+	location      *time.Location
+	precision     int
+	startup       startupState
+	local         *localState
+	prepared      map[string]preparedStatement
+	role          string
+	properties    map[string]string
+	lastQueryID   string
+	extended      extendedCache
+	history       statementHistory
+	// message is the type of the client message being handled.
+	message types.ClientMessage
+}
+
+type (
+	sessionKey struct{}
+	connKey    struct{}
+	writerKey  struct{}
+	readerKey  struct{}
+)
+
+// acceptClient is the wire authentication strategy accepting every client
+// while recording its connection, message writer and message reader for the
+// session.
+func acceptClient(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (context.Context, error) {
+	if conn, ok := writer.Writer.(net.Conn); ok {
+		ctx = context.WithValue(ctx, connKey{}, conn)
+	}
+	ctx = context.WithValue(ctx, writerKey{}, writer)
+	closer := &closeReader{BufferedReader: reader.Buffer}
+	reader.Buffer = closer
+	ctx = context.WithValue(ctx, readerKey{}, reader)
+	writer.Start(types.ServerAuth)
+	writer.AddInt32(0) // AuthenticationOk
+	return ctx, writer.End()
+}
+
+// paramOptions is the startup parameter carrying command-line options,
+// such as -c name=value settings.
+const paramOptions wire.ParameterStatus = "options"
+
+// newSession initializes the session state of a freshly connected client
+// from its startup parameters, applying the settings of its options
+// parameter.
+func (tdb *TrinoDB) newSession(ctx context.Context) (context.Context, error) {
+	params := wire.ClientParameters(ctx)
+	session := &Session{
+		Catalog:         params[wire.ParamDatabase],
+		ApplicationName: params[wire.ParamApplicationName],
+		config:          tdb.Config,
+	}
+	session.conn, _ = ctx.Value(connKey{}).(net.Conn)
+	session.writer, _ = ctx.Value(writerKey{}).(*buffer.Writer)
+	if session.writer != nil {
+		session.writer.Status = session.transactionStatus
+	}
+	session.reader, _ = ctx.Value(readerKey{}).(*buffer.Reader)
+	if session.reader != nil {
+		if closer, ok := session.reader.Buffer.(*closeReader); ok {
+			closer.session = session
+		}
+	}
+	settings, err := parseOptions(params[paramOptions])
+	if err == nil {
+		for _, setting := range settings {
+			if err = session.Set(setting.name, setting.value); err != nil {
+				break
+			}
+		}
+	}
+	session.startup = startupState{
+		settings:  maps.Clone(session.settings),
+		location:  session.location,
+		precision: session.precision,
+	}
+	if err != nil {
+		// Tell the client why the connection is refused before it is closed.
+		if session.writer != nil {
+			_ = wire.ErrorCode(session.writer, err)
+		}
+		return ctx, err
+	}
+	return context.WithValue(ctx, sessionKey{}, session), nil
+}
+
+// SessionFromContext returns the session stored in ctx, or an empty session
+// if none has been set.
+func SessionFromContext(ctx context.Context) *Session {
+	if session, ok := ctx.Value(sessionKey{}).(*Session); ok {
+		return session
+	}
+	return &Session{}
+}
+
+// Config returns the proxy configuration the session was opened with.
+func (s *Session) Config() *config.Config {
+	if s.config == nil {
+		return &config.Config{}
+	}
+	return s.config
+}
+
+// ClientAddr returns the IP address of the client, or an empty string if
+// it is unknown.
+func (s *Session) ClientAddr() string {
+	if s.conn == nil {
+		return ""
+	}
+	addr := s.conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// queryArgs returns the Trino headers carrying the session state for
+// query, passed as named arguments so they only apply to that query.
+func (s *Session) queryArgs(query string) []any {
+	var args []any
+	if s.Catalog != "" {
+		args = append(args, sql.Named("X-Trino-Catalog", s.Catalog))
+	}
+	if tags := s.clientTags(); len(tags) > 0 {
+		args = append(args, sql.Named("X-Trino-Client-Tags", strings.Join(tags, ",")))
+	}
+	if name := s.applicationName(); name != "" {
+		args = append(args, sql.Named("X-Trino-Client-Info", name))
+	}
+	if header, ok := s.roleHeader(); ok {
+		args = append(args, header)
+	}
+	if header, ok := s.timeZoneHeader(); ok {
+		args = append(args, header)
+	}
+	if header, ok := s.propertiesHeader(); ok {
+		args = append(args, header)
+	}
+	if header, ok := s.schemaHeader(query); ok {
+		args = append(args, header)
+	}
+	if tx := s.transaction(); tx != nil {
+		args = append(args, tx.header())
+	}
+	return args
+}
+
+// clientTags returns the Trino client tags of the session: the configured
+// tags followed by the client's application name.
+func (s *Session) clientTags() []string {
+	tags := append([]string(nil), s.Config().ClientTags...)
+	if name := s.applicationName(); name != "" {
+		tags = append(tags, strings.ReplaceAll(name, ",", "_"))
+	}
+	return tags
+}
+
+// applicationName returns the application_name of the session: the value
+// last set with SET application_name, or else the one the client connected
+// with.
+func (s *Session) applicationName() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name, ok := s.settings["application_name"]; ok {
+		return name
+	}
+	return s.ApplicationName
+}
+
+// Notice sends a NoticeResponse message with the given text to the client.
+func (s *Session) Notice(message string) error {
+	if s.writer == nil {
+		return nil
+	}
+	s.writer.Start(types.ServerNoticeResponse)
+	for _, field := range []struct {
+		typ   byte
+		value string
+	}{{'S', "NOTICE"}, {'V', "NOTICE"}, {'C', "00000"}, {'M', message}} {
+		s.writer.AddByte(field.typ)
+		s.writer.AddString(field.value)
+		s.writer.AddNullTerminate()
+	}
+	s.writer.AddNullTerminate()
+	return s.writer.End()
+}
+
+// InTransaction reports whether the client has an open transaction block.
+func (s *Session) InTransaction() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inTransaction
+}
+
+// Running reports whether the session is running a statement.
+func (s *Session) Running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running
+}
+
+// busy marks the session as running a statement, which cancel cancels,
+// and stops the idle-in-transaction timer meanwhile.
+func (s *Session) busy(cancel context.CancelFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = true
+	s.cancel = cancel
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+}
+
+// idle marks the statement of the session as complete and arms the
+// idle-in-transaction timer when the client is waiting inside a transaction
+// block. Once it fires, the transaction is rolled back and the
+// connection is closed.
+func (s *Session) idle(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	s.cancel = nil
+	if timeout <= 0 || !s.inTransaction || s.conn == nil {
+		return
+	}
+	s.idleTimer = time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		open := s.inTransaction
+		s.inTransaction = false
+		s.mu.Unlock()
+		if !open {
+			return
+		}
+		// Closing the connection rolls back its Trino transaction, which
+		// locks the session.
+		log.Printf("Terminating connection %s due to idle-in-transaction timeout", s.conn.RemoteAddr())
+		if err := s.conn.Close(); err != nil {
+			log.Printf("Failed to close idle connection: %s", err)
+		}
+	})
+}
+
+// cancelStatement cancels the running statement of the session, which
+// cancels its Trino query, and reports whether one was running.
+func (s *Session) cancelStatement() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running || s.cancel == nil {
+		return false
+	}
+	s.cancel()
+	return true
+}
+
+// LastQueryID returns the Trino query id of the last statement the session
+// ran on Trino, or an empty string if it has not run any.
+func (s *Session) LastQueryID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastQueryID
+}
+
+func (s *Session) setLastQueryID(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastQueryID = id
+}
+
+func (s *Session) setMessage(typ types.ClientMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.message = typ
+}
+
+// parsing reports whether the session is handling a Parse message of the
+// extended query protocol.
+func (s *Session) parsing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.message == types.ClientParse
+}
@@ -0,0 +1,253 @@
+package main_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session", func() {
+	var (
+		fake   *fakeTrino
+		server *testServer
+	)
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		server = startServer(fake, &config.Config{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("sends the configured client tags and the application name", func() {
+		tagged := startServer(fake, &config.Config{ClientTags: []string{"etl", "batch"}})
+		defer tagged.Close()
+
+		db := tagged.Connect("memory", "application_name=reporting")
+		defer db.Close()
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(fake.LastQuery().Header("X-Trino-Client-Tags")).To(Equal("etl,batch,reporting"))
+	})
+
+	It("derives the client tag from the application name the client sets", func() {
+		db := server.Connect("memory", "application_name=reporting")
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(fake.LastQuery().Header("X-Trino-Client-Tags")).To(Equal("reporting"))
+
+		_, err := db.Exec("SET application_name = 'etl,nightly';")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(fake.LastQuery().Header("X-Trino-Client-Tags")).To(Equal("etl_nightly"))
+		Expect(fake.LastQuery().Header("X-Trino-Client-Info")).To(Equal("etl,nightly"))
+
+		_, err = db.Exec("RESET application_name;")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(fake.LastQuery().Header("X-Trino-Client-Tags")).To(Equal("reporting"))
+	})
+
+	It("sends no client tags when none are configured", func() {
+		db := server.Connect("memory")
+		defer db.Close()
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(fake.LastQuery().Header("X-Trino-Client-Tags")).To(BeEmpty())
+	})
+
+	It("sends the application name of every session as Trino client info", func() {
+		reporting := server.Connect("memory", "application_name=reporting")
+		defer reporting.Close()
+		etl := server.Connect("memory", "application_name=etl")
+		defer etl.Close()
+		anonymous := server.Connect("memory")
+		defer anonymous.Close()
+
+		var value int
+		for _, db := range []*sql.DB{reporting, etl, anonymous, reporting} {
+			Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		}
+		var infos []string
+		for _, q := range fake.Queries() {
+			infos = append(infos, q.Header("X-Trino-Client-Info"))
+		}
+		Expect(infos).To(Equal([]string{"reporting", "etl", "", "reporting"}))
+	})
+
+	It("selects the Trino catalog from the startup database", func() {
+		for _, catalog := range []string{"hive", "memory"} {
+			db := server.Connect(catalog)
+			var value int
+			Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal(1))
+			Expect(fake.LastQuery().Header("X-Trino-Catalog")).To(Equal(catalog))
+			Expect(db.Close()).To(Succeed())
+		}
+	})
+
+	It("enables the role set with SET ROLE in the session catalog", func() {
+		db := server.Connect("hive")
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+
+		var value int
+		_, err := db.Exec("SET ROLE Analyst;")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(fake.LastQuery().Header("X-Trino-Role")).To(Equal("hive=ROLE%7Banalyst%7D"))
+
+		_, err = db.Exec(`SET ROLE "Data Team";`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(fake.LastQuery().Header("X-Trino-Role")).To(Equal("hive=ROLE%7BData+Team%7D"))
+
+		for _, reset := range []string{"RESET ROLE;", "SET ROLE NONE;"} {
+			_, err = db.Exec("SET ROLE analyst;")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = db.Exec(reset)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+			Expect(fake.LastQuery().Header("X-Trino-Role")).To(BeEmpty())
+		}
+	})
+
+	It("clears the session state on DISCARD ALL", func() {
+		db := server.Connect("hive")
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+
+		for _, statement := range []string{
+			"SET search_path = analytics;",
+			"SET SESSION query_max_run_time = '1h';",
+			"SET ROLE analyst;",
+			"PREPARE one AS SELECT 1;",
+		} {
+			_, err := db.Exec(statement)
+			Expect(err).NotTo(HaveOccurred(), statement)
+		}
+		_, err := db.Exec("DISCARD ALL;")
+		Expect(err).NotTo(HaveOccurred())
+
+		var setting string
+		Expect(db.QueryRow("SELECT current_setting('search_path');").Scan(&setting)).To(Succeed())
+		Expect(setting).To(Equal(`"$user", public`))
+		var value int
+		Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(fake.LastQuery().Header("X-Trino-Session")).To(BeEmpty())
+		Expect(fake.LastQuery().Header("X-Trino-Role")).To(BeEmpty())
+		_, err = db.Exec("EXECUTE one;")
+		Expect(err).To(MatchError(ContainSubstring(`prepared statement "one" does not exist`)))
+	})
+
+	It("refuses DISCARD ALL inside a transaction block", func() {
+		ctx := context.Background()
+		db := server.Connect("hive")
+		defer db.Close()
+		conn, err := db.Conn(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		_, err = conn.ExecContext(ctx, "BEGIN;")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.ExecContext(ctx, "DISCARD ALL;")
+		Expect(err).To(MatchError(ContainSubstring("DISCARD ALL cannot run inside a transaction block")))
+	})
+
+	It("runs schema-qualified queries on the catalogs requiring it with their schema", func() {
+		fake.On("SELECT * FROM sales.orders", fakeResult{
+			Columns: []fakeColumn{col("id", "bigint")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		fake.On(`SELECT * FROM "HR".staff s JOIN sales.orders o ON s.id = o.id`, fakeResult{
+			Columns: []fakeColumn{col("id", "bigint")},
+			Rows:    [][]driver.Value{{int64(2)}},
+		})
+		fake.On("SELECT * FROM memory.sales.orders", fakeResult{
+			Columns: []fakeColumn{col("id", "bigint")},
+			Rows:    [][]driver.Value{{int64(3)}},
+		})
+		scoped := startServer(fake, &config.Config{SchemaPerQueryCatalogs: []string{"jdbc"}})
+		defer scoped.Close()
+		db := scoped.Connect("jdbc")
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+
+		var schemas []string
+		for _, query := range []string{
+			"SELECT * FROM sales.orders;",
+			`SELECT * FROM "HR".staff s JOIN sales.orders o ON s.id = o.id;`,
+			"SELECT 1;",
+			"SELECT * FROM memory.sales.orders;",
+		} {
+			var value int
+			Expect(db.QueryRow(query).Scan(&value)).To(Succeed(), query)
+			schemas = append(schemas, fake.LastQuery().Header("X-Trino-Schema"))
+		}
+		Expect(schemas).To(Equal([]string{"sales", "HR", "", ""}))
+
+		other := scoped.Connect("hive")
+		defer other.Close()
+		var value int
+		Expect(other.QueryRow("SELECT * FROM sales.orders;").Scan(&value)).To(Succeed())
+		Expect(fake.LastQuery().Header("X-Trino-Schema")).To(BeEmpty())
+	})
+
+	It("resolves lowercased catalog and schema names to mixed-case Trino names", func() {
+		fake.On("SELECT catalog_name FROM system.metadata.catalogs", fakeResult{
+			Columns: []fakeColumn{col("catalog_name", "varchar")},
+			Rows:    [][]driver.Value{{"hive"}, {"Warehouse"}},
+		})
+		fake.On(`SELECT schema_name FROM "Warehouse".information_schema.schemata`, fakeResult{
+			Columns: []fakeColumn{col("schema_name", "varchar")},
+			Rows:    [][]driver.Value{{"information_schema"}, {"Sales"}, {"sales_archive"}},
+		})
+		fake.On(`SELECT count(*) FROM "Sales".orders`, fakeResult{
+			Columns: []fakeColumn{col("_col0", "bigint")},
+			Rows:    [][]driver.Value{{int64(3)}},
+		})
+		fake.On(`SELECT count(*) FROM "Warehouse"."Sales".orders o JOIN sales_archive.orders a ON o.id = a.id WHERE o.note = 'sales.orders'`, fakeResult{
+			Columns: []fakeColumn{col("_col0", "bigint")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		fake.On("SELECT count(*) FROM missing.orders", fakeResult{
+			Columns: []fakeColumn{col("_col0", "bigint")},
+			Rows:    [][]driver.Value{{int64(0)}},
+		})
+		resolving := startServer(fake, &config.Config{
+			CaseInsensitiveNames:   true,
+			SchemaPerQueryCatalogs: []string{"warehouse"},
+		})
+		defer resolving.Close()
+		db := resolving.Connect("warehouse")
+		defer db.Close()
+
+		var count int
+		for i := 0; i < 2; i++ {
+			Expect(db.QueryRow("SELECT count(*) FROM sales.orders;").Scan(&count)).To(Succeed())
+			Expect(fake.LastQuery().Header("X-Trino-Catalog")).To(Equal("Warehouse"))
+			Expect(fake.LastQuery().Header("X-Trino-Schema")).To(Equal("Sales"))
+		}
+		Expect(db.QueryRow("SELECT count(*) FROM warehouse.sales.orders o JOIN sales_archive.orders a ON o.id = a.id WHERE o.note = 'sales.orders';").Scan(&count)).To(Succeed())
+		Expect(count).To(Equal(1))
+		// The names are listed once and cached, and so are the names
+		// missing from them.
+		for i := 0; i < 2; i++ {
+			Expect(db.QueryRow("SELECT count(*) FROM missing.orders;").Scan(&count)).To(Succeed())
+		}
+		Expect(fake.Queries()).To(HaveLen(7))
+	})
+})
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/lib/pq/oid"
+)
+
+// serverVersion and serverVersionNum are the Postgres version pg2trino
+// presents itself as.
+const (
+	serverVersion    = "14.0"
+	serverVersionNum = "140000"
+)
+
+// emulatedSetting is a Postgres setting pg2trino answers locally, so
+// clients probing it on connect don't fail.
+type emulatedSetting struct {
+	name        string
+	value       string
+	description string
+}
+
+// emulatedSettings are the settings answered by current_setting, SHOW and
+// pg_settings lookups, unless the session changed them with SET.
+var emulatedSettings = []emulatedSetting{
+	{"application_name", "", "Sets the application name to be reported in statistics and logs."},
+	{"client_encoding", "UTF8", "Sets the client's character set encoding."},
+	{"datestyle", "ISO, MDY", "Sets the display format for date and time values."},
+	{"default_transaction_isolation", "read committed", "Sets the transaction isolation level of each new transaction."},
+	{"default_transaction_read_only", "off", "Sets the default read-only status of new transactions."},
+	{"extra_float_digits", "1", "Sets the number of digits displayed for floating-point values."},
+	{"integer_datetimes", "on", "Shows whether datetimes are integer based."},
+	{"intervalstyle", "postgres", "Sets the display format for interval values."},
+	{"is_superuser", "off", "Shows whether the current user is a superuser."},
+	{"lc_collate", "en_US.UTF-8", "Shows the collation order locale."},
+	{"lc_ctype", "en_US.UTF-8", "Shows the character classification and case conversion locale."},
+	{"max_identifier_length", "63", "Shows the maximum identifier length."},
+	{"search_path", `"$user", public`, "Sets the schema search order for names that are not schema-qualified."},
+	{"server_encoding", "UTF8", "Shows the server (database) character set encoding."},
+	{"server_version", serverVersion, "Shows the server version."},
+	{"server_version_num", serverVersionNum, "Shows the server version as an integer."},
+	{"standard_conforming_strings", "on", "Causes '...' strings to treat backslashes literally."},
+	{"timezone", "UTC", "Sets the time zone for displaying and interpreting time stamps."},
+	{"transaction_isolation", "read committed", "Sets the current transaction's isolation level."},
+	{"transaction_read_only", "off", "Sets the current transaction's read-only status."},
+}
+
+var (
+	// setStatement matches the Postgres SET name { TO | = } value statement,
+	// whose name may be a dotted custom setting such as app.tenant_id.
+	setStatement = regexp.MustCompile(`(?is)^\s*SET\s+(?:SESSION\s+|LOCAL\s+)?([a-z_][a-z0-9_]*(?:\.[a-z_][a-z0-9_]*)?)\s*(?:=|\sTO\s)\s*(.*?)\s*$`)
+	// resetStatement matches the Postgres RESET { name | ALL } statement.
+	resetStatement = regexp.MustCompile(`(?is)^\s*RESET\s+([a-z_][a-z0-9_]*(?:\.[a-z_][a-z0-9_]*)?)\s*$`)
+	// timeZoneStatement matches the Postgres SET TIME ZONE { value | LOCAL |
+	// DEFAULT } statement.
+	timeZoneStatement = regexp.MustCompile(`(?is)^\s*SET\s+(?:SESSION\s+|LOCAL\s+)?TIME\s+ZONE\s+(.+?)\s*$`)
+	// currentSettingStatement matches SELECT current_setting('name'), or
+	// SELECT current_setting($1) with the name as a parameter.
+	currentSettingStatement = regexp.MustCompile(`(?is)^\s*SELECT\s+(?:pg_catalog\.)?current_setting\s*\(\s*(?:'([^']+)'|\$1)\s*\)\s*$`)
+	// showStatement matches the Postgres SHOW name statement.
+	showStatement = regexp.MustCompile(`(?is)^\s*SHOW\s+([a-z_][a-z0-9_]*(?:\.[a-z_][a-z0-9_]*)?)\s*$`)
+	// showAllStatement matches the Postgres SHOW ALL statement.
+	showAllStatement = regexp.MustCompile(`(?is)^\s*SHOW\s+ALL\s*$`)
+	// pgSettingsStatement matches SELECT setting FROM pg_settings WHERE name
+	// = 'name', or WHERE name = $1 with the name as a parameter.
+	pgSettingsStatement = regexp.MustCompile(`(?is)^\s*SELECT\s+setting\s+FROM\s+(?:pg_catalog\.)?pg_settings\s+WHERE\s+name\s*=\s*(?:'([^']+)'|\$1)\s*$`)
+	// setConfigStatement matches SELECT set_config(name, value, is_local),
+	// with an optional column alias, whose arguments are string literals,
+	// booleans or parameters.
+	setConfigStatement = regexp.MustCompile(`(?is)^\s*SELECT\s+(?:pg_catalog\.)?set_config\s*\(\s*('(?:[^']|'')*'|\$\d+)\s*,\s*('(?:[^']|'')*'|\$\d+)\s*,\s*('[^']*'|\$\d+|TRUE|FALSE)\s*\)(?:\s+(?:AS\s+)?([a-z_][a-z0-9_]*))?\s*$`)
+)
+
+// parseSet returns the setting name and value of a SET statement, or false
+// if query is not one. SET TIME ZONE sets timezone, and its LOCAL value
+// restores the default.
+func parseSet(query string) (string, string, bool) {
+	if match := timeZoneStatement.FindStringSubmatch(query); match != nil {
+		if strings.EqualFold(match[1], "LOCAL") {
+			return "timezone", "DEFAULT", true
+		}
+		return "timezone", unquote(match[1]), true
+	}
+	match := setStatement.FindStringSubmatch(query)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], unquote(match[2]), true
+}
+
+// parseReset returns the setting name of a RESET statement, which is ALL
+// for RESET ALL, or false if query is not one. RESET ROLE is left to
+// parseRole.
+func parseReset(query string) (string, bool) {
+	match := resetStatement.FindStringSubmatch(query)
+	if match == nil || strings.EqualFold(match[1], "ROLE") {
+		return "", false
+	}
+	return match[1], true
+}
+
+// settingLookup answers the current_setting, SHOW and pg_settings lookups
+// of a setting locally, or returns false if query is not one. SHOW
+// statements for unknown settings are left to Trino, which has SHOW
+// statements of its own. The current_setting and pg_settings lookups may
+// pass the setting name as the parameter $1.
+func settingLookup(ctx context.Context, query string) (wire.PreparedStatements, bool, error) {
+	session := SessionFromContext(ctx)
+	if match := currentSettingStatement.FindStringSubmatch(query); match != nil {
+		if match[1] == "" {
+			return settingParamLookup(session, "current_setting", true), true, nil
+		}
+		value, ok := session.settingValue(match[1])
+		if !ok {
+			return nil, true, errUnknownSetting(match[1])
+		}
+		return textRows([]string{"current_setting"}, [][]string{{value}}, "SELECT 1"), true, nil
+	}
+	if showAllStatement.MatchString(query) {
+		return textRows([]string{"name", "setting", "description"}, session.allSettings(), "SHOW"), true, nil
+	}
+	if match := showStatement.FindStringSubmatch(query); match != nil {
+		if value, ok := session.settingValue(match[1]); ok {
+			return textRows([]string{strings.ToLower(match[1])}, [][]string{{value}}, "SHOW"), true, nil
+		}
+	}
+	if match := pgSettingsStatement.FindStringSubmatch(query); match != nil {
+		if match[1] == "" {
+			return settingParamLookup(session, "setting", false), true, nil
+		}
+		var rows [][]string
+		if value, ok := session.settingValue(match[1]); ok {
+			rows = append(rows, []string{value})
+		}
+		return textRows([]string{"setting"}, rows, fmt.Sprintf("SELECT %d", len(rows))), true, nil
+	}
+	return nil, false, nil
+}
+
+// settingParamLookup returns a statement answering a lookup of the setting
+// named by the text parameter $1 in a column of the given name, which is
+// only known once the statement is bound. Unknown settings are an error
+// when strict, as for current_setting, and otherwise return no rows.
+func settingParamLookup(session *Session, column string, strict bool) wire.PreparedStatements {
+	handle := func(_ context.Context, writer wire.DataWriter, params []wire.Parameter) error {
+		var name string
+		if len(params) > 0 {
+			name = string(params[0].Value())
+		}
+		value, ok := session.settingValue(name)
+		if !ok && strict {
+			return errUnknownSetting(name)
+		}
+		rows := 0
+		if ok {
+			if err := writer.Row([]any{value}); err != nil {
+				return err
+			}
+			rows++
+		}
+		return writer.Complete(fmt.Sprintf("SELECT %d", rows))
+	}
+	return wire.Prepared(wire.NewStatement(handle,
+		wire.WithParameters([]oid.Oid{oid.T_text}),
+		wire.WithColumns(wire.Columns{{Name: column, Oid: oid.T_text, TypeModifier: -1}}),
+	))
+}
+
+// setConfig answers SELECT set_config(name, value, is_local) like the
+// statement setting name: role sets the Trino role as SET ROLE does, the
+// emulated Postgres settings and dotted custom settings such as
+// app.tenant_id are set as by SET, and any other name sets the Trino
+// session property as SET SESSION does. When is_local is true, the change
+// only lasts until the end of the transaction block, or of the statement
+// outside of one. The value is returned as the only row. It returns false
+// if query is not a set_config call.
+func setConfig(ctx context.Context, query string) (wire.PreparedStatements, bool) {
+	match := setConfigStatement.FindStringSubmatch(query)
+	if match == nil {
+		return nil, false
+	}
+	session := SessionFromContext(ctx)
+	column := "set_config"
+	if match[4] != "" {
+		column = match[4]
+	}
+	var params []oid.Oid
+	for _, arg := range match[1:4] {
+		if n, err := strconv.Atoi(strings.TrimPrefix(arg, "$")); err == nil {
+			for len(params) < n {
+				params = append(params, oid.T_text)
+			}
+		}
+	}
+	handle := func(_ context.Context, writer wire.DataWriter, values []wire.Parameter) error {
+		// arg returns the value of a literal or parameter argument.
+		arg := func(arg string) string {
+			if !strings.HasPrefix(arg, "$") {
+				return unquote(arg)
+			}
+			n, _ := strconv.Atoi(arg[1:])
+			if n < 1 || n > len(values) {
+				return ""
+			}
+			return string(values[n-1].Value())
+		}
+		name, value := arg(match[1]), arg(match[2])
+		set := func() error { return session.setConfig(name, value) }
+		var err error
+		if local, _ := strconv.ParseBool(unquote(arg(match[3]))); local {
+			err = session.setLocal(set)
+		} else {
+			err = set()
+		}
+		if err != nil {
+			return err
+		}
+		if err := writer.Row([]any{value}); err != nil {
+			return err
+		}
+		return writer.Complete("SELECT 1")
+	}
+	return wire.Prepared(wire.NewStatement(handle,
+		wire.WithParameters(params),
+		wire.WithColumns(wire.Columns{{Name: column, Oid: oid.T_text, TypeModifier: -1}}),
+	)), true
+}
+
+// setConfig sets the role, setting or Trino session property name to
+// value. Dotted names are custom settings, as in Postgres, rather than the
+// properties of Trino catalogs.
+func (s *Session) setConfig(name, value string) error {
+	switch {
+	case strings.EqualFold(name, "role"):
+		if strings.EqualFold(value, "none") {
+			value = ""
+		}
+		s.SetRole(value)
+	case isEmulatedSetting(name), strings.Contains(name, "."):
+		return s.Set(name, value)
+	default:
+		s.SetProperty(strings.ToLower(name), value)
+	}
+	return nil
+}
+
+func errUnknownSetting(name string) error {
+	err := fmt.Errorf("unrecognized configuration parameter %q", name)
+	return psqlerr.WithCode(err, codes.UndefinedObject)
+}
+
+// startupSetting is a setting passed in the options startup parameter.
+type startupSetting struct {
+	name  string
+	value string
+}
+
+// parseOptions returns the settings passed as -c name=value or
+// --name=value in the options startup parameter, such as
+// options='-c search_path=foo'. Backslashes escape the following character,
+// so values may contain spaces.
+func parseOptions(options string) ([]startupSetting, error) {
+	var (
+		args    []string
+		arg     strings.Builder
+		escaped bool
+		pending bool
+	)
+	for _, c := range options {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped, pending = true, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if pending {
+				args = append(args, arg.String())
+				arg.Reset()
+				pending = false
+			}
+		default:
+			arg.WriteRune(c)
+			pending = true
+		}
+	}
+	if pending {
+		args = append(args, arg.String())
+	}
+
+	var settings []startupSetting
+	for i := 0; i < len(args); i++ {
+		var option string
+		switch {
+		case args[i] == "-c" && i+1 < len(args):
+			i++
+			option = args[i]
+		case strings.HasPrefix(args[i], "-c") && args[i] != "-c":
+			option = args[i][2:]
+		case strings.HasPrefix(args[i], "--"):
+			if name, value, ok := strings.Cut(args[i][2:], "="); ok {
+				option = strings.ReplaceAll(name, "-", "_") + "=" + value
+			}
+		}
+		name, value, ok := strings.Cut(option, "=")
+		if !ok || name == "" {
+			err := fmt.Errorf("invalid command-line argument for server process: %s", args[i])
+			return nil, psqlerr.WithCode(err, codes.Syntax)
+		}
+		settings = append(settings, startupSetting{name: name, value: value})
+	}
+	return settings, nil
+}
+
+// unquote strips the quotes around a single-quoted literal.
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+	return value
+}
+
+// setting answers a SET statement by storing the value in the session.
+func setting(ctx context.Context, name, value string) (wire.PreparedStatements, error) {
+	if err := SessionFromContext(ctx).Set(name, value); err != nil {
+		return nil, err
+	}
+	return commandComplete("SET"), nil
+}
+
+// resetSetting answers a RESET statement by restoring the default of the
+// setting, or of every setting for RESET ALL.
+func resetSetting(ctx context.Context, name string) (wire.PreparedStatements, error) {
+	session := SessionFromContext(ctx)
+	if strings.EqualFold(name, "ALL") {
+		session.resetSettings()
+	} else if err := session.Set(name, "DEFAULT"); err != nil {
+		return nil, err
+	}
+	return commandComplete("RESET"), nil
+}
+
+// startupState is the state of the session settings once the settings of
+// the options startup parameter are applied, which RESET restores.
+type startupState struct {
+	settings  map[string]string
+	location  *time.Location
+	precision int
+}
+
+// localState is the state of the session before the first
+// transaction-local change of a transaction block, which the end of the
+// block restores.
+type localState struct {
+	startupState
+	role       string
+	properties map[string]string
+}
+
+// setLocal runs set, which changes the session state, so that its changes
+// only last until the end of the transaction block, or are undone at once
+// outside of one.
+func (s *Session) setLocal(set func() error) error {
+	s.mu.Lock()
+	block := s.inTransaction
+	if s.local == nil {
+		s.local = &localState{
+			startupState: startupState{
+				settings:  maps.Clone(s.settings),
+				location:  s.location,
+				precision: s.precision,
+			},
+			role:       s.role,
+			properties: maps.Clone(s.properties),
+		}
+	}
+	s.mu.Unlock()
+	err := set()
+	if !block {
+		s.restoreLocal()
+	}
+	return err
+}
+
+// restoreLocal undoes the transaction-local changes of the session.
+func (s *Session) restoreLocal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.local == nil {
+		return
+	}
+	s.settings = s.local.settings
+	s.location = s.local.location
+	s.precision = s.local.precision
+	s.role = s.local.role
+	s.properties = s.local.properties
+	s.local = nil
+}
+
+// resetSettings restores every session setting to its value at startup.
+func (s *Session) resetSettings() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings = maps.Clone(s.startup.settings)
+	s.location = s.startup.location
+	s.precision = s.startup.precision
+}
+
+// Set changes a session setting. Setting names are case-insensitive and the
+// value DEFAULT restores the value the session started with.
+func (s *Session) Set(name, value string) error {
+	name = strings.ToLower(name)
+	reset := strings.EqualFold(value, "DEFAULT")
+	if startup, ok := s.startup.settings[name]; ok && reset {
+		return s.Set(name, startup)
+	}
+
+	var location *time.Location
+	if name == "timezone" && !reset {
+		var ok bool
+		if location, ok = loadLocation(value); !ok {
+			err := fmt.Errorf("invalid value for parameter \"TimeZone\": %q", value)
+			return psqlerr.WithCode(err, codes.InvalidParameterValue)
+		}
+		// Like Postgres, the canonical name of the zone is shown.
+		value = location.String()
+	}
+	var precision int
+	if name == "extra_float_digits" && !reset {
+		digits, err := strconv.Atoi(value)
+		if err != nil || digits < -15 || digits > 3 {
+			err = fmt.Errorf("invalid value for parameter \"extra_float_digits\": %q", value)
+			return psqlerr.WithCode(err, codes.InvalidParameterValue)
+		}
+		// Like Postgres, positive values select the shortest exact form and
+		// others lower the 15 significant digits of a double.
+		if digits <= 0 {
+			precision = max(15+digits, 1)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.settings == nil {
+		s.settings = map[string]string{}
+	}
+	if reset {
+		delete(s.settings, name)
+	} else {
+		s.settings[name] = value
+	}
+	if name == "timezone" {
+		s.location = location
+	}
+	if name == "extra_float_digits" {
+		s.precision = precision
+	}
+	return nil
+}
+
+// floatPrecision returns the significant digits of the float values sent
+// to the client, or zero for the fewest digits that round-trip.
+func (s *Session) floatPrecision() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.precision
+}
+
+// Setting returns the value of a session setting, or an empty string if it
+// has not been set.
+func (s *Session) Setting(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settings[strings.ToLower(name)]
+}
+
+// settingValue returns the value of a setting as set in the session or
+// emulated by default, or false if the setting is unknown.
+func (s *Session) settingValue(name string) (string, bool) {
+	name = strings.ToLower(name)
+	s.mu.Lock()
+	value, ok := s.settings[name]
+	s.mu.Unlock()
+	if ok {
+		return value, true
+	}
+	for _, setting := range emulatedSettings {
+		if setting.name == name {
+			return setting.value, true
+		}
+	}
+	return "", false
+}
+
+// allSettings returns the name, value and description of every emulated
+// setting and every setting changed in the session, sorted by name.
+func (s *Session) allSettings() [][]string {
+	descriptions := map[string]string{}
+	for _, setting := range emulatedSettings {
+		descriptions[setting.name] = setting.description
+	}
+	s.mu.Lock()
+	for name := range s.settings {
+		if _, ok := descriptions[name]; !ok {
+			descriptions[name] = ""
+		}
+	}
+	s.mu.Unlock()
+
+	rows := make([][]string, 0, len(descriptions))
+	for name, description := range descriptions {
+		value, _ := s.settingValue(name)
+		rows = append(rows, []string{name, value, description})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	return rows
+}
+
+// timeZoneHeader returns the Trino header setting the session time zone to
+// the one selected by the TimeZone setting, so Trino evaluates functions
+// such as current_timestamp in it too, or false if it is not set.
+func (s *Session) timeZoneHeader() (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.location == nil {
+		return nil, false
+	}
+	return sql.Named("X-Trino-Time-Zone", s.location.String()), true
+}
+
+// Location returns the time zone timestamptz values are rendered in, as
+// selected by the TimeZone setting. It defaults to UTC.
+func (s *Session) Location() *time.Location {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.location == nil {
+		return time.UTC
+	}
+	return s.location
+}
@@ -0,0 +1,487 @@
+package main_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sort"
+	"time"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Settings", func() {
+	It("parses SET statements", func() {
+		name, value, ok := pgtrino.ParseSet("SET TimeZone = 'Europe/Berlin'")
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal("TimeZone"))
+		Expect(value).To(Equal("Europe/Berlin"))
+
+		name, value, ok = pgtrino.ParseSet("set session application_name to psql")
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal("application_name"))
+		Expect(value).To(Equal("psql"))
+
+		name, value, ok = pgtrino.ParseSet("SET TIME ZONE 'America/New_York'")
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal("timezone"))
+		Expect(value).To(Equal("America/New_York"))
+
+		_, value, ok = pgtrino.ParseSet("set time zone local")
+		Expect(ok).To(BeTrue())
+		Expect(value).To(Equal("DEFAULT"))
+
+		_, _, ok = pgtrino.ParseSet("SELECT 1")
+		Expect(ok).To(BeFalse())
+	})
+
+	Describe("TimeZone", func() {
+		var (
+			fake   *fakeTrino
+			server *testServer
+		)
+
+		BeforeEach(func() {
+			fake = newFakeTrino()
+			fake.On("SELECT ts", fakeResult{
+				Columns: []fakeColumn{col("ts", "timestamp with time zone")},
+				Rows:    [][]driver.Value{{time.Date(2001, 8, 22, 3, 4, 5, 0, time.UTC)}},
+			})
+			server = startServer(fake, &config.Config{})
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("renders timestamptz values in the session time zone", func() {
+			ctx := context.Background()
+			db := server.Connect("memory")
+			defer db.Close()
+			conn, err := db.Conn(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			var ts time.Time
+			Expect(conn.QueryRowContext(ctx, "SELECT ts;").Scan(&ts)).To(Succeed())
+			_, offset := ts.Zone()
+			Expect(offset).To(Equal(0))
+
+			_, err = conn.ExecContext(ctx, "SET TimeZone = 'Asia/Kolkata';")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.QueryRowContext(ctx, "SELECT ts;").Scan(&ts)).To(Succeed())
+			_, offset = ts.Zone()
+			Expect(offset).To(Equal(5*3600 + 30*60))
+			Expect(ts.Equal(time.Date(2001, 8, 22, 3, 4, 5, 0, time.UTC))).To(BeTrue())
+		})
+
+		It("applies SET TIME ZONE to the rendered values and the Trino session", func() {
+			ctx := context.Background()
+			db := server.Connect("memory")
+			defer db.Close()
+			conn, err := db.Conn(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			var ts time.Time
+			Expect(conn.QueryRowContext(ctx, "SELECT ts;").Scan(&ts)).To(Succeed())
+			Expect(fake.LastQuery().Header("X-Trino-Time-Zone")).To(BeEmpty())
+
+			_, err = conn.ExecContext(ctx, "SET TIME ZONE 'America/New_York';")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.QueryRowContext(ctx, "SELECT ts;").Scan(&ts)).To(Succeed())
+			_, offset := ts.Zone()
+			Expect(offset).To(Equal(-4 * 3600))
+			Expect(fake.LastQuery().Header("X-Trino-Time-Zone")).To(Equal("America/New_York"))
+
+			_, err = conn.ExecContext(ctx, "SET TIME ZONE LOCAL;")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.QueryRowContext(ctx, "SELECT ts;").Scan(&ts)).To(Succeed())
+			_, offset = ts.Zone()
+			Expect(offset).To(Equal(0))
+			Expect(fake.LastQuery().Header("X-Trino-Time-Zone")).To(BeEmpty())
+		})
+
+		It("rejects unknown time zones and the time zone of the proxy host", func() {
+			db := server.Connect("memory")
+			defer db.Close()
+			for _, statement := range []string{
+				"SET TimeZone = 'Mars/Olympus';",
+				"SET TimeZone = 'Local';",
+				"SET TimeZone = 'local';",
+				"SET TIME ZONE '+15';",
+				"SET TIME ZONE INTERVAL '+02:75' HOUR TO MINUTE;",
+			} {
+				_, err := db.Exec(statement)
+				Expect(err).To(MatchError(ContainSubstring("invalid value for parameter")), statement)
+			}
+		})
+
+		It("accepts time zone names in any case and UTC offsets", func() {
+			ctx := context.Background()
+			db := server.Connect("memory")
+			defer db.Close()
+			conn, err := db.Conn(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			for statement, zone := range map[string]struct {
+				name   string
+				offset int
+			}{
+				"SET TimeZone = 'europe/berlin';":                       {"Europe/Berlin", 2 * 3600},
+				"SET TimeZone = 'utc';":                                 {"UTC", 0},
+				"SET TimeZone = '+02';":                                 {"+02:00", 2 * 3600},
+				"SET TIME ZONE -7.5;":                                   {"-07:30", -(7*3600 + 30*60)},
+				"SET TIME ZONE INTERVAL '-08:00' HOUR TO MINUTE;":       {"-08:00", -8 * 3600},
+				"SET TIME ZONE INTERVAL '+05:45' HOUR TO MINUTE;":       {"+05:45", 5*3600 + 45*60},
+				"SELECT set_config('timezone', 'asia/kolkata', false);": {"Asia/Kolkata", 5*3600 + 30*60},
+			} {
+				_, err = conn.ExecContext(ctx, statement)
+				Expect(err).NotTo(HaveOccurred(), statement)
+				var name string
+				Expect(conn.QueryRowContext(ctx, "SHOW TimeZone;").Scan(&name)).To(Succeed())
+				Expect(name).To(Equal(zone.name), statement)
+				var ts time.Time
+				Expect(conn.QueryRowContext(ctx, "SELECT ts;").Scan(&ts)).To(Succeed())
+				_, offset := ts.Zone()
+				Expect(offset).To(Equal(zone.offset), statement)
+				Expect(fake.LastQuery().Header("X-Trino-Time-Zone")).To(Equal(zone.name), statement)
+			}
+		})
+	})
+
+	Describe("Emulated settings", func() {
+		var (
+			fake   *fakeTrino
+			server *testServer
+		)
+
+		BeforeEach(func() {
+			fake = newFakeTrino()
+			server = startServer(fake, &config.Config{})
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("answers current_setting lookups", func() {
+			db := server.Connect("memory")
+			defer db.Close()
+
+			var value string
+			Expect(db.QueryRow("SELECT current_setting('server_version_num');").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("140000"))
+			Expect(db.QueryRow("SELECT pg_catalog.current_setting('standard_conforming_strings');").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("on"))
+			Expect(fake.Queries()).To(BeEmpty())
+		})
+
+		It("answers SHOW and pg_settings lookups, including session changes", func() {
+			db := server.Connect("memory")
+			defer db.Close()
+
+			var value string
+			Expect(db.QueryRow("SHOW max_identifier_length;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("63"))
+			Expect(db.QueryRow("SELECT setting FROM pg_settings WHERE name = 'TimeZone';").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("UTC"))
+
+			_, err := db.Exec("SET TimeZone = 'Europe/Berlin';")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(db.QueryRow("SHOW TimeZone;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("Europe/Berlin"))
+		})
+
+		It("selects the Trino schema with search_path until RESET restores the default", func() {
+			fake.On("SELECT count(*) FROM orders", fakeResult{
+				Columns: []fakeColumn{col("_col0", "bigint")},
+				Rows:    [][]driver.Value{{int64(3)}},
+			})
+			db := server.Connect("memory")
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+
+			var count int
+			schema := func() string {
+				Expect(db.QueryRow("SELECT count(*) FROM orders;").Scan(&count)).To(Succeed())
+				return fake.LastQuery().Header("X-Trino-Schema")
+			}
+			Expect(schema()).To(BeEmpty())
+			_, err := db.Exec(`SET search_path TO "$user", "Sales", public;`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schema()).To(Equal("Sales"))
+			for _, path := range []string{`"$user", public`, `'$user', "public"`, "pg_catalog, public"} {
+				_, err = db.Exec("SET search_path TO " + path + ";")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(schema()).To(BeEmpty(), path)
+			}
+
+			_, err = db.Exec("RESET search_path;")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schema()).To(BeEmpty())
+			var value string
+			Expect(db.QueryRow("SHOW search_path;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal(`"$user", public`))
+		})
+
+		It("answers lookups passing the setting name as a parameter", func() {
+			db := server.Connect("memory")
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+
+			var value string
+			Expect(db.QueryRow("SELECT setting FROM pg_catalog.pg_settings WHERE name = $1;", "max_identifier_length").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("63"))
+			Expect(db.QueryRow("SELECT setting FROM pg_settings WHERE name = $1;", "no_such_setting").Scan(&value)).To(Equal(sql.ErrNoRows))
+
+			_, err := db.Exec("SET search_path = analytics;")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(db.QueryRow("SELECT current_setting($1);", "search_path").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("analytics"))
+			err = db.QueryRow("SELECT current_setting($1);", "no_such_setting").Scan(&value)
+			Expect(err).To(MatchError(ContainSubstring(`unrecognized configuration parameter "no_such_setting"`)))
+			Expect(fake.Queries()).To(BeEmpty())
+		})
+
+		It("changes settings, roles and session properties with set_config", func() {
+			fake.On("SELECT 1", fakeResult{
+				Columns: []fakeColumn{col("_col0", "integer")},
+				Rows:    [][]driver.Value{{int64(1)}},
+			})
+			db := server.Connect("memory")
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+
+			var value string
+			Expect(db.QueryRow("SELECT set_config('search_path', 'analytics', false);").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("analytics"))
+			Expect(db.QueryRow("SHOW search_path;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("analytics"))
+
+			Expect(db.QueryRow("SELECT pg_catalog.set_config($1, $2, $3);", "role", "analyst", false).Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("analyst"))
+			Expect(db.QueryRow("SELECT set_config('query_max_run_time', '1h', false) AS previous;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("1h"))
+			var one int
+			Expect(db.QueryRow("SELECT 1;").Scan(&one)).To(Succeed())
+			Expect(fake.LastQuery().Header("X-Trino-Role")).To(Equal("memory=ROLE%7Banalyst%7D"))
+			Expect(fake.LastQuery().Header("X-Trino-Session")).To(Equal("query_max_run_time=1h"))
+
+			err := db.QueryRow("SELECT set_config('timezone', 'Mars/Olympus', false);").Scan(&value)
+			Expect(err).To(MatchError(ContainSubstring(`invalid value for parameter "TimeZone"`)))
+			Expect(fake.Queries()).To(HaveLen(1))
+		})
+
+		It("keeps custom settings and scopes local ones to the transaction block", func() {
+			fake.On("SELECT 1", fakeResult{
+				Columns: []fakeColumn{col("_col0", "integer")},
+				Rows:    [][]driver.Value{{int64(1)}},
+			})
+			db := server.Connect("memory")
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+
+			var value string
+			Expect(db.QueryRow("SELECT set_config('app.tenant_id', '42', false);").Scan(&value)).To(Succeed())
+			Expect(db.QueryRow("SELECT current_setting('app.tenant_id');").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("42"))
+			_, err := db.Exec("SET app.region = 'eu';")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(db.QueryRow("SHOW app.region;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("eu"))
+
+			Expect(db.QueryRow("SELECT set_config('app.tenant_id', '7', true);").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("7"))
+			Expect(db.QueryRow("SELECT current_setting('app.tenant_id');").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("42"))
+
+			for _, commit := range []string{"COMMIT;", "ROLLBACK;"} {
+				_, err = db.Exec("BEGIN;")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(db.QueryRow("SELECT set_config('app.tenant_id', '7', true);").Scan(&value)).To(Succeed())
+				Expect(db.QueryRow("SELECT set_config('role', 'tenant', true);").Scan(&value)).To(Succeed())
+				Expect(db.QueryRow("SELECT current_setting('app.tenant_id');").Scan(&value)).To(Succeed())
+				Expect(value).To(Equal("7"))
+				var one int
+				Expect(db.QueryRow("SELECT 1;").Scan(&one)).To(Succeed())
+				Expect(fake.LastQuery().Header("X-Trino-Role")).To(Equal("memory=ROLE%7Btenant%7D"))
+				_, err = db.Exec(commit)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(db.QueryRow("SELECT current_setting('app.tenant_id');").Scan(&value)).To(Succeed())
+				Expect(value).To(Equal("42"))
+				Expect(db.QueryRow("SELECT 1;").Scan(&one)).To(Succeed())
+				Expect(fake.LastQuery().Header("X-Trino-Role")).To(BeEmpty())
+			}
+			Expect(fake.LastQuery().Header("X-Trino-Session")).NotTo(ContainSubstring("app."))
+		})
+
+		It("lists every setting with SHOW ALL, consistent with SHOW", func() {
+			db := server.Connect("memory")
+			defer db.Close()
+			_, err := db.Exec("SET application_name = 'reporting';")
+			Expect(err).NotTo(HaveOccurred())
+
+			rows, err := db.Query("SHOW ALL;")
+			Expect(err).NotTo(HaveOccurred())
+			defer rows.Close()
+			settings := map[string]string{}
+			var names []string
+			for rows.Next() {
+				var name, setting, description string
+				Expect(rows.Scan(&name, &setting, &description)).To(Succeed())
+				Expect(description).NotTo(BeEmpty())
+				settings[name] = setting
+				names = append(names, name)
+			}
+			Expect(rows.Err()).NotTo(HaveOccurred())
+			Expect(len(names)).To(BeNumerically(">=", 10))
+			Expect(sort.StringsAreSorted(names)).To(BeTrue(), "settings are not sorted")
+			Expect(settings).To(HaveKeyWithValue("server_version_num", "140000"))
+			Expect(settings).To(HaveKeyWithValue("timezone", "UTC"))
+			Expect(settings).To(HaveKeyWithValue("application_name", "reporting"))
+
+			for name, setting := range settings {
+				var value string
+				Expect(db.QueryRow("SHOW " + name + ";").Scan(&value)).To(Succeed())
+				Expect(value).To(Equal(setting), name)
+			}
+		})
+
+		It("applies the settings of the options startup parameter", func() {
+			db := server.Connect("memory", "options=-c%20search_path%3Dfoo%20--TimeZone%3DEurope/Berlin%20-capplication_name%3Dmy%5C%20app")
+			defer db.Close()
+
+			var value string
+			Expect(db.QueryRow("SHOW search_path;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("foo"))
+			Expect(db.QueryRow("SHOW TimeZone;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("Europe/Berlin"))
+			Expect(db.QueryRow("SHOW application_name;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("my app"))
+		})
+
+		It("restores the settings of the options startup parameter on RESET and DISCARD ALL", func() {
+			db := server.Connect("memory", "options=-c%20search_path%3Dfoo%20--TimeZone%3DEurope/Berlin")
+			defer db.Close()
+
+			for _, reset := range []string{"RESET ALL;", "DISCARD ALL;", "RESET search_path;"} {
+				for _, statement := range []string{"SET search_path = bar;", "SET TimeZone = 'Asia/Tokyo';", reset} {
+					_, err := db.Exec(statement)
+					Expect(err).NotTo(HaveOccurred(), statement)
+				}
+				var value string
+				Expect(db.QueryRow("SHOW search_path;").Scan(&value)).To(Succeed())
+				Expect(value).To(Equal("foo"), reset)
+				if reset != "RESET search_path;" {
+					Expect(db.QueryRow("SHOW TimeZone;").Scan(&value)).To(Succeed())
+					Expect(value).To(Equal("Europe/Berlin"), reset)
+				}
+			}
+		})
+
+		It("refuses connections with invalid options", func() {
+			for options, message := range map[string]string{
+				"-c%20search_path":             "invalid command-line argument",
+				"-c%20TimeZone%3DMars/Olympus": "invalid value for parameter",
+			} {
+				db := server.Connect("memory", "options="+options)
+				Expect(db.Ping()).To(MatchError(ContainSubstring(message)), options)
+				Expect(db.Close()).To(Succeed())
+			}
+		})
+
+		It("rejects unknown settings and leaves other SHOW statements to Trino", func() {
+			fake.On("SHOW TABLES", fakeResult{
+				Columns: []fakeColumn{col("Table", "varchar")},
+				Rows:    [][]driver.Value{{"users"}},
+			})
+			db := server.Connect("memory")
+			defer db.Close()
+
+			var value string
+			err := db.QueryRow("SELECT current_setting('no_such_setting');").Scan(&value)
+			Expect(err).To(MatchError(ContainSubstring("unrecognized configuration parameter")))
+			Expect(db.QueryRow("SELECT setting FROM pg_settings WHERE name = 'no_such_setting';").Scan(&value)).To(MatchError(sql.ErrNoRows))
+			Expect(db.QueryRow("SHOW TABLES;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("users"))
+		})
+	})
+
+	Describe("Trino session properties", func() {
+		It("applies SET SESSION and RESET SESSION to the Trino session", func() {
+			fake := newFakeTrino()
+			fake.On("SELECT 1", fakeResult{
+				Columns: []fakeColumn{col("_col0", "integer")},
+				Rows:    [][]driver.Value{{int64(1)}},
+			})
+			server := startServer(fake, &config.Config{})
+			defer server.Close()
+			db := server.Connect("hive")
+			defer db.Close()
+
+			run := func(statement string) string {
+				_, err := db.Exec(statement)
+				Expect(err).NotTo(HaveOccurred(), statement)
+				var value int
+				Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+				return fake.LastQuery().Header("X-Trino-Session")
+			}
+
+			Expect(run("SET SESSION query_max_run_time = '1h 30m';")).To(Equal("query_max_run_time=1h+30m"))
+			Expect(run("SET SESSION hive.bucket_execution_enabled = false;")).
+				To(Equal("hive.bucket_execution_enabled=false,query_max_run_time=1h+30m"))
+			Expect(run("RESET SESSION query_max_run_time;")).To(Equal("hive.bucket_execution_enabled=false"))
+			Expect(run("RESET SESSION hive.bucket_execution_enabled;")).To(BeEmpty())
+		})
+
+		It("limits the memory of every query to QUERY_MAX_MEMORY", func() {
+			fake := newFakeTrino()
+			fake.On("SELECT 1", fakeResult{
+				Columns: []fakeColumn{col("_col0", "integer")},
+				Rows:    [][]driver.Value{{int64(1)}},
+			})
+			server := startServer(fake, &config.Config{QueryMaxMemory: "10GB"})
+			defer server.Close()
+			db := server.Connect("hive")
+			defer db.Close()
+
+			var value int
+			Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+			Expect(fake.LastQuery().Header("X-Trino-Session")).To(Equal("query_max_memory=10GB"))
+
+			_, err := db.Exec("SET SESSION query_max_memory = '2GB';")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+			Expect(fake.LastQuery().Header("X-Trino-Session")).To(Equal("query_max_memory=2GB"))
+
+			for _, size := range []string{"1TB", "10.5GB", "lots"} {
+				_, err = db.Exec("SET SESSION query_max_memory = '" + size + "';")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(db.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+				Expect(fake.LastQuery().Header("X-Trino-Session")).To(Equal("query_max_memory=10GB"), size)
+			}
+		})
+
+		It("keeps SET SESSION of Postgres settings local", func() {
+			fake := newFakeTrino()
+			server := startServer(fake, &config.Config{})
+			defer server.Close()
+			db := server.Connect("hive")
+			defer db.Close()
+
+			_, err := db.Exec("SET SESSION application_name = 'reporting';")
+			Expect(err).NotTo(HaveOccurred())
+			var value string
+			Expect(db.QueryRow("SHOW application_name;").Scan(&value)).To(Succeed())
+			Expect(value).To(Equal("reporting"))
+			Expect(fake.Queries()).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,125 @@
+package main_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"expvar"
+	"strconv"
+	"time"
+
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Shutdown", func() {
+	var (
+		fake   *fakeTrino
+		server *testServer
+		idle   *sql.DB
+		slow   *sql.DB
+		done   chan error
+	)
+
+	metric := func(name string) int {
+		value, err := strconv.Atoi(expvar.Get(name).String())
+		Expect(err).NotTo(HaveOccurred())
+		return value
+	}
+
+	// startSlowQuery runs a query taking delay on its own connection next to
+	// an idle connection.
+	startSlowQuery := func(delay time.Duration) {
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		fake.On("SELECT slow", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+			Delay:   delay,
+		})
+		idle = server.Connect("hive")
+		var value int
+		Expect(idle.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+
+		slow = server.Connect("hive")
+		done = make(chan error, 1)
+		go func() {
+			var value int
+			done <- slow.QueryRow("SELECT slow;").Scan(&value)
+		}()
+		Eventually(func() int { return len(fake.Queries()) }).Should(Equal(2))
+	}
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		server = startServer(fake, &config.Config{})
+	})
+
+	AfterEach(func() {
+		idle.Close()
+		slow.Close()
+	})
+
+	It("drains connections whose queries complete within the timeout", func() {
+		drainedBefore := metric("pg2trino_drained_connections")
+		startSlowQuery(200 * time.Millisecond)
+
+		drained, forced := server.Shutdown(5 * time.Second)
+		Expect(drained).To(Equal(2))
+		Expect(forced).To(Equal(0))
+		Expect(<-done).To(Succeed())
+		Expect(metric("pg2trino_drained_connections") - drainedBefore).To(Equal(2))
+	})
+
+	It("force-closes connections still running a query after the timeout", func() {
+		forcedBefore := metric("pg2trino_force_closed_connections")
+		startSlowQuery(time.Second)
+
+		drained, forced := server.Shutdown(100 * time.Millisecond)
+		Expect(drained).To(Equal(1))
+		Expect(forced).To(Equal(1))
+		Expect(<-done).To(HaveOccurred())
+		Expect(metric("pg2trino_force_closed_connections") - forcedBefore).To(Equal(1))
+	})
+
+	It("cancels the Trino queries still running after the timeout", func() {
+		startSlowQuery(time.Minute)
+
+		start := time.Now()
+		_, forced := server.Shutdown(100 * time.Millisecond)
+		Expect(forced).To(Equal(1))
+		Eventually(fake.Canceled).Should(Equal([]string{"SELECT slow"}))
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		Eventually(done).Should(Receive(HaveOccurred()))
+	})
+})
+
+var _ = Describe("Active connections", func() {
+	It("counts the open client connections", func() {
+		fake := newFakeTrino()
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		server := startServer(fake, &config.Config{LogConnections: true})
+		defer server.Close()
+		var value int
+		first := server.Connect("hive")
+		Expect(first.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		second := server.Connect("hive")
+		defer second.Close()
+		Expect(second.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(server.ActiveConnections()).To(Equal(2))
+		// Connections of other servers may still be open, so the process-wide
+		// gauge counts at least these two.
+		active, err := strconv.Atoi(expvar.Get("pg2trino_active_connections").String())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(active).To(BeNumerically(">=", 2))
+
+		Expect(first.Close()).To(Succeed())
+		Eventually(server.ActiveConnections).Should(Equal(1))
+	})
+})
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// sessionStatements is the statement cache of the wire server. It keeps the
+// statements prepared with the extended protocol apart per session, so
+// clients can reuse names across connections and close them by name.
+type sessionStatements struct{}
+
+// sessionPortals is the portal cache of the wire server, keeping the portals
+// bound with the extended protocol apart per session.
+type sessionPortals struct{}
+
+// boundPortal is a portal of a session along with the statement it was bound
+// from.
+type boundPortal struct {
+	cache     *wire.DefaultPortalCache
+	statement *wire.Statement
+}
+
+// extendedCache holds the named statements and portals of a session. Every
+// name gets a cache of its own, as the wire caches cannot drop a single name.
+type extendedCache struct {
+	mu         sync.Mutex
+	statements map[string]*wire.DefaultStatementCache
+	portals    map[string]boundPortal
+}
+
+func (sessionStatements) Set(ctx context.Context, name string, statement *wire.PreparedStatement) error {
+	cache := &wire.DefaultStatementCache{}
+	if err := cache.Set(ctx, name, statement); err != nil {
+		return err
+	}
+	session := SessionFromContext(ctx)
+	extended := &session.extended
+	extended.mu.Lock()
+	defer extended.mu.Unlock()
+	// The unnamed statement and redefined names replace a statement.
+	if _, ok := extended.statements[name]; !ok && name != "" {
+		named := len(extended.statements)
+		if _, ok := extended.statements[""]; ok {
+			named--
+		}
+		if err := checkPreparedLimit(session.Config(), named); err != nil {
+			return err
+		}
+	}
+	if extended.statements == nil {
+		extended.statements = map[string]*wire.DefaultStatementCache{}
+	}
+	extended.statements[name] = cache
+	return nil
+}
+
+func (sessionStatements) Get(ctx context.Context, name string) (*wire.Statement, error) {
+	extended := &SessionFromContext(ctx).extended
+	extended.mu.Lock()
+	cache, ok := extended.statements[name]
+	extended.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	return cache.Get(ctx, name)
+}
+
+func (sessionPortals) Bind(ctx context.Context, name string, statement *wire.Statement, parameters []wire.Parameter, formats []wire.FormatCode) error {
+	if SessionFromContext(ctx).Config().ForceTextFormat {
+		// No result format codes select the text format for every column.
+		formats = nil
+	}
+	cache := &wire.DefaultPortalCache{}
+	if err := cache.Bind(ctx, name, statement, parameters, formats); err != nil {
+		return err
+	}
+	extended := &SessionFromContext(ctx).extended
+	extended.mu.Lock()
+	defer extended.mu.Unlock()
+	if extended.portals == nil {
+		extended.portals = map[string]boundPortal{}
+	}
+	extended.portals[name] = boundPortal{cache: cache, statement: statement}
+	return nil
+}
+
+func (sessionPortals) Get(ctx context.Context, name string) (*wire.Portal, error) {
+	portal, ok := SessionFromContext(ctx).extended.portal(name)
+	if !ok {
+		return nil, nil
+	}
+	return portal.cache.Get(ctx, name)
+}
+
+func (sessionPortals) Execute(ctx context.Context, name string, writer *buffer.Writer) error {
+	portal, ok := SessionFromContext(ctx).extended.portal(name)
+	if !ok {
+		err := fmt.Errorf("portal %q does not exist", name)
+		return psqlerr.WithCode(err, codes.InvalidCursorName)
+	}
+	return portal.cache.Execute(ctx, name, writer)
+}
+
+func (c *extendedCache) portal(name string) (boundPortal, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	portal, ok := c.portals[name]
+	return portal, ok
+}
+
+// reset frees every statement and portal.
+func (c *extendedCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statements = nil
+	c.portals = nil
+}
+
+// close frees the statement (kind 'S') or portal (kind 'P') of the given
+// name. Closing a statement also closes the portals bound from it, as in
+// Postgres. Closing a name that does not exist is not an error.
+func (c *extendedCache) close(kind byte, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch kind {
+	case 'S':
+		cache, ok := c.statements[name]
+		if !ok {
+			return
+		}
+		statement, _ := cache.Get(context.Background(), name)
+		delete(c.statements, name)
+		for portalName, portal := range c.portals {
+			if portal.statement == statement {
+				delete(c.portals, portalName)
+			}
+		}
+	case 'P':
+		delete(c.portals, name)
+	}
+}
+
+// closeReader follows the messages a client sends after authentication,
+// recording the type of the message being handled in the session and
+// applying their Close messages to the session, which psql-wire merely
+// acknowledges. Messages are handled one at a time, so a Close is applied
+// once every message sent before it has been handled.
+type closeReader struct {
+	buffer.BufferedReader
+	session *Session
+
+	typ       types.ClientMessage
+	header    []byte
+	remaining int
+	body      []byte
+}
+
+func (r *closeReader) Read(p []byte) (int, error) {
+	n, err := r.BufferedReader.Read(p)
+	r.consume(p[:n])
+	return n, err
+}
+
+func (r *closeReader) ReadByte() (byte, error) {
+	b, err := r.BufferedReader.ReadByte()
+	if err == nil {
+		r.consume([]byte{b})
+	}
+	return b, err
+}
+
+func (r *closeReader) ReadString(delim byte) (string, error) {
+	s, err := r.BufferedReader.ReadString(delim)
+	r.consume([]byte(s))
+	return s, err
+}
+
+// consume advances through the messages by the bytes read by psql-wire.
+func (r *closeReader) consume(p []byte) {
+	for len(p) > 0 {
+		switch {
+		case r.typ == 0:
+			r.typ = types.ClientMessage(p[0])
+			r.header = r.header[:0]
+			p = p[1:]
+		case len(r.header) < 4:
+			n := min(4-len(r.header), len(p))
+			r.header = append(r.header, p[:n]...)
+			p = p[n:]
+			if len(r.header) == 4 {
+				r.remaining = int(binary.BigEndian.Uint32(r.header)) - 4
+				r.body = r.body[:0]
+				if r.remaining <= 0 {
+					r.complete()
+				}
+			}
+		default:
+			n := min(r.remaining, len(p))
+			if r.typ == types.ClientClose {
+				r.body = append(r.body, p[:n]...)
+			}
+			r.remaining -= n
+			p = p[n:]
+			if r.remaining == 0 {
+				r.complete()
+			}
+		}
+	}
+}
+
+func (r *closeReader) complete() {
+	if r.session != nil {
+		r.session.setMessage(r.typ)
+	}
+	if r.typ == types.ClientClose && len(r.body) > 0 && r.session != nil {
+		name, _, _ := bytes.Cut(r.body[1:], []byte{0})
+		r.session.extended.close(r.body[0], string(name))
+	}
+	r.typ = 0
+}
@@ -0,0 +1,8 @@
+module github.com/jeroenrinzema/psql-wire
+
+go 1.21
+
+require (
+	github.com/jackc/pgx/v5 v5.0.3
+	github.com/lib/pq v1.10.9
+)
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// hoursOffset matches a numeric TimeZone value, the offset of the zone
+	// in hours east of UTC, such as +02 or -7.5.
+	hoursOffset = regexp.MustCompile(`^[+-]?\d{1,2}(?:\.\d+)?$`)
+	// intervalOffset matches the INTERVAL form of SET TIME ZONE, such as
+	// INTERVAL '-08:00' HOUR TO MINUTE.
+	intervalOffset = regexp.MustCompile(`(?i)^INTERVAL\s+'([+-]?)(\d{1,2})(?::(\d{2}))?'(?:\s+HOUR(?:\s+TO\s+MINUTE)?)?$`)
+)
+
+// maxZoneOffset is the largest UTC offset Trino accepts for a time zone.
+const maxZoneOffset = 14 * time.Hour
+
+// zoneinfoDirs are the directories the time package loads time zones from
+// on Unix systems.
+var zoneinfoDirs = []string{"/usr/share/zoneinfo", "/usr/share/lib/zoneinfo", "/usr/lib/locale/TZ"}
+
+// zoneNames indexes the names of the time zones of the system and of the
+// Go installation by their lower-case form.
+var zoneNames = sync.OnceValue(func() map[string]string {
+	names := map[string]string{}
+	add := func(name string) {
+		if _, ok := names[strings.ToLower(name)]; !ok {
+			names[strings.ToLower(name)] = name
+		}
+	}
+	for _, dir := range zoneinfoDirs {
+		_ = fs.WalkDir(os.DirFS(dir), ".", func(name string, entry fs.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() {
+				add(name)
+			}
+			return nil
+		})
+	}
+	if archive, err := zip.OpenReader(filepath.Join(runtime.GOROOT(), "lib", "time", "zoneinfo.zip")); err == nil {
+		for _, file := range archive.File {
+			add(file.Name)
+		}
+		_ = archive.Close()
+	}
+	return names
+})
+
+// loadLocation returns the time zone selected by a TimeZone value as
+// Postgres accepts it: a time zone name, matched case-insensitively, an
+// offset in hours east of UTC, such as +02, or an INTERVAL offset. It
+// returns false for invalid values and for Local, the time zone of the
+// proxy host rather than one of the client.
+func loadLocation(value string) (*time.Location, bool) {
+	if hoursOffset.MatchString(value) {
+		hours, err := strconv.ParseFloat(value, 64)
+		return fixedZone(time.Duration(hours*float64(time.Hour)), err == nil)
+	}
+	if match := intervalOffset.FindStringSubmatch(value); match != nil {
+		hours, _ := strconv.Atoi(match[2])
+		minutes := 0
+		if match[3] != "" {
+			minutes, _ = strconv.Atoi(match[3])
+		}
+		offset := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+		if match[1] == "-" {
+			offset = -offset
+		}
+		return fixedZone(offset, minutes < 60)
+	}
+	if strings.EqualFold(value, "Local") {
+		return nil, false
+	}
+	if location, err := time.LoadLocation(value); err == nil {
+		return location, true
+	}
+	name, ok := zoneNames()[strings.ToLower(value)]
+	if !ok {
+		return nil, false
+	}
+	location, err := time.LoadLocation(name)
+	return location, err == nil
+}
+
+// fixedZone returns the time zone offset east of UTC, named by the offset
+// in the form Trino accepts, such as +02:00, or false if valid is false or
+// the offset is out of the range of Trino.
+func fixedZone(offset time.Duration, valid bool) (*time.Location, bool) {
+	offset = offset.Round(time.Minute)
+	if !valid || offset.Abs() > maxZoneOffset {
+		return nil, false
+	}
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+	}
+	minutes := int(offset.Abs().Minutes())
+	name := fmt.Sprintf("%c%02d:%02d", sign, minutes/60, minutes%60)
+	return time.FixedZone(name, int(offset.Seconds())), true
+}
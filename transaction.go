@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	wire "github.com/jeroenrinzema/psql-wire"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// errSavepointsUnsupported rejects the savepoint statements, as Trino
+// transactions have no savepoints.
+var errSavepointsUnsupported = psqlerr.WithCode(errors.New("savepoints are not supported"), codes.FeatureNotSupported)
+
+// errChainUnsupported rejects COMMIT AND CHAIN and ROLLBACK AND CHAIN.
+var errChainUnsupported = psqlerr.WithCode(errors.New("AND CHAIN is not supported"), codes.FeatureNotSupported)
+
+// errPreparedTransactionsUnsupported rejects the statements of two-phase
+// commit, which Trino does not support.
+var errPreparedTransactionsUnsupported = psqlerr.WithCode(errors.New("prepared transactions are not supported"), codes.FeatureNotSupported)
+
+// transactionTag returns the command tag of a Postgres transaction control
+// statement, or false if query is not one. Transaction control statements
+// Trino has no equivalent for, such as SAVEPOINT or COMMIT PREPARED, are
+// rejected with an error rather than ending the transaction block.
+func transactionTag(query string) (string, bool, error) {
+	fields := strings.Fields(strings.ToUpper(strings.TrimRight(query, "; \t\r\n")))
+	if len(fields) == 0 {
+		return "", false, nil
+	}
+	switch fields[0] {
+	case "BEGIN":
+		return "BEGIN", true, nil
+	case "START":
+		if len(fields) < 2 || fields[1] != "TRANSACTION" {
+			return "", false, nil
+		}
+		return "START TRANSACTION", true, nil
+	case "SAVEPOINT", "RELEASE":
+		return "", true, errSavepointsUnsupported
+	case "PREPARE":
+		if len(fields) < 2 || fields[1] != "TRANSACTION" {
+			return "", false, nil
+		}
+		return "", true, errPreparedTransactionsUnsupported
+	case "COMMIT", "END":
+		return endTag("COMMIT", fields[1:])
+	case "ROLLBACK", "ABORT":
+		return endTag("ROLLBACK", fields[1:])
+	default:
+		return "", false, nil
+	}
+}
+
+// endTag returns tag for a statement ending the transaction block with the
+// given fields following its first word, or an error if they ask for more
+// than ending it.
+func endTag(tag string, fields []string) (string, bool, error) {
+	if len(fields) > 0 && fields[0] == "PREPARED" {
+		return "", true, errPreparedTransactionsUnsupported
+	}
+	if len(fields) > 0 && (fields[0] == "WORK" || fields[0] == "TRANSACTION") {
+		fields = fields[1:]
+	}
+	switch {
+	case len(fields) == 0, len(fields) == 3 && fields[0] == "AND" && fields[1] == "NO" && fields[2] == "CHAIN":
+		return tag, true, nil
+	case len(fields) == 2 && fields[0] == "AND" && fields[1] == "CHAIN":
+		return "", true, errChainUnsupported
+	case tag == "ROLLBACK" && fields[0] == "TO":
+		return "", true, errSavepointsUnsupported
+	default:
+		return "", true, psqlerr.WithCode(fmt.Errorf("syntax error at or near %q", strings.ToLower(fields[0])), codes.Syntax)
+	}
+}
+
+// transactionHeader is the Trino header naming the transaction a statement
+// runs in. Its value is NONE for START TRANSACTION, which Trino only
+// accepts from clients sending it.
+const transactionHeader = "X-Trino-Transaction-Id"
+
+// transaction answers a transaction control statement. The transaction
+// block of the session is backed by a Trino transaction, which the
+// statements of the block run in. The Trino driver has no support for
+// transactions, so it is started and ended over the Trino HTTP protocol,
+// which needs the HTTP client of NewTrinoDB. With the custom_client of
+// TRINO_DSN, the block is only tracked in the session: its queries run in
+// auto-commit mode and statements modifying data are rejected, as ROLLBACK
+// could not undo them.
+func (tdb *TrinoDB) transaction(ctx context.Context, tag string) (wire.PreparedStatements, error) {
+	session := SessionFromContext(ctx)
+	if tag == "BEGIN" || tag == "START TRANSACTION" {
+		if err := session.beginTransaction(ctx, tdb); err != nil {
+			return nil, classifyError(err)
+		}
+		return commandComplete(tag), nil
+	}
+	tag, err := session.endTransaction(ctx, tag == "COMMIT")
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return commandComplete(tag), nil
+}
+
+// startedTransactions hands the id of the Trino transaction started by a
+// START TRANSACTION statement from the HTTP client, which receives it in a
+// response header the Trino driver drops, to the session starting it. The
+// transactions are started one at a time, as nothing tells apart the
+// responses to concurrent statements.
+type startedTransactions struct {
+	start sync.Mutex
+	mu    sync.Mutex
+	id    string
+}
+
+// record records the id of a started transaction.
+func (t *startedTransactions) record(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.id = id
+}
+
+// take returns and forgets the id of the last started transaction.
+func (t *startedTransactions) take() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := t.id
+	t.id = ""
+	return id
+}
+
+// trinoTransaction is the Trino transaction of a transaction block.
+type trinoTransaction struct {
+	tdb *TrinoDB
+	id  string
+}
+
+// header returns the Trino header running a statement in the transaction.
+func (t *trinoTransaction) header() sql.NamedArg {
+	return sql.Named(transactionHeader, t.id)
+}
+
+// end commits or rolls back the transaction.
+func (t *trinoTransaction) end(ctx context.Context, commit bool) error {
+	statement := "ROLLBACK"
+	if commit {
+		statement = "COMMIT"
+	}
+	_, err := t.tdb.ExecContext(ctx, statement, t.header())
+	return err
+}
+
+// errTransactionsUnsupported is returned by startTransaction when the
+// Trino requests are sent with an HTTP client other than the one of
+// NewTrinoDB.
+var errTransactionsUnsupported = errors.New("starting Trino transactions needs the HTTP client of pg2trino")
+
+// startTransaction starts a Trino transaction for the transaction block of
+// session.
+func (tdb *TrinoDB) startTransaction(ctx context.Context, session *Session) (*trinoTransaction, error) {
+	started := tdb.transactions
+	if started == nil {
+		return nil, errTransactionsUnsupported
+	}
+	started.start.Lock()
+	defer started.start.Unlock()
+	started.take()
+	args := append(tdb.queryArgs(ctx, session, "START TRANSACTION"), sql.Named(transactionHeader, "NONE"))
+	if _, err := tdb.ExecContext(ctx, "START TRANSACTION", args...); err != nil {
+		return nil, err
+	}
+	id := started.take()
+	if id == "" {
+		return nil, errors.New("START TRANSACTION did not start a Trino transaction")
+	}
+	return &trinoTransaction{tdb: tdb, id: id}, nil
+}
+
+// errNoTrinoTransaction rejects the statements modifying data inside a
+// transaction block without a Trino transaction, which Trino would apply at
+// once whatever the block ends with.
+var errNoTrinoTransaction = psqlerr.WithHint(
+	psqlerr.WithCode(errors.New("cannot modify data inside a transaction block without a Trino transaction"), codes.FeatureNotSupported),
+	"Trino transactions need the HTTP client of pg2trino, which the custom_client of TRINO_DSN replaces. Run the statement outside of the transaction block.",
+)
+
+// beginTransaction opens the transaction block of the session and starts
+// its Trino transaction if tdb can. The transaction outlives the statement
+// starting it, until COMMIT or ROLLBACK.
+func (s *Session) beginTransaction(ctx context.Context, tdb *TrinoDB) error {
+	if s.InTransaction() {
+		return nil
+	}
+	tx, err := tdb.startTransaction(ctx, s)
+	if err != nil && !errors.Is(err, errTransactionsUnsupported) {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tx, s.inTransaction, s.failed = tx, true, false
+	return nil
+}
+
+// endTransaction closes the transaction block of the session, committing
+// or rolling back its Trino transaction, and undoes the transaction-local
+// changes of its settings. It returns the command tag of the statement
+// ending the block, which is ROLLBACK for a failed block whatever it ends
+// with.
+func (s *Session) endTransaction(ctx context.Context, commit bool) (string, error) {
+	s.restoreLocal()
+	s.mu.Lock()
+	tx, failed := s.tx, s.failed
+	s.tx, s.inTransaction, s.failed = nil, false, false
+	s.mu.Unlock()
+	commit = commit && !failed
+	tag := "ROLLBACK"
+	if commit {
+		tag = "COMMIT"
+	}
+	if tx == nil {
+		return tag, nil
+	}
+	return tag, tx.end(ctx, commit)
+}
+
+// transaction returns the Trino transaction the statements of the session
+// run in, or nil outside of one.
+func (s *Session) transaction() *trinoTransaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tx
+}
+
+// failTransaction marks the transaction block of the session as failed
+// when err fails a statement run in its Trino transaction, which Trino
+// aborts, and returns err.
+func (s *Session) failTransaction(err error) error {
+	if err == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx != nil {
+		s.failed = true
+	}
+	return err
+}
+
+// transactionStatus returns the transaction status of the session, which
+// the client is told with every ReadyForQuery message.
+func (s *Session) transactionStatus() types.ServerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case !s.inTransaction:
+		return types.ServerIdle
+	case s.failed:
+		return types.ServerTransactionFailed
+	default:
+		return types.ServerTransactionBlock
+	}
+}
+
+// checkWrite fails statements modifying data inside a transaction block
+// that is not backed by a Trino transaction.
+func (s *Session) checkWrite() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inTransaction && s.tx == nil {
+		return errNoTrinoTransaction
+	}
+	return nil
+}
+
+// autocommitOnly adds a hint to the errors of statements Trino can only run
+// in auto-commit mode, such as writes to most connectors, when they fail
+// inside a Trino transaction.
+func autocommitOnly(session *Session, err error) error {
+	if session.transaction() == nil || psqlerr.GetCode(err) != codes.ActiveSQLTransaction {
+		return err
+	}
+	return psqlerr.WithHint(err, "Trino can only run this statement in auto-commit mode. Run it outside of the transaction block.")
+}
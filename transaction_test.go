@@ -0,0 +1,235 @@
+package main_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"time"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	trino "github.com/trinodb/trino-go-client/trino"
+)
+
+var _ = Describe("Transactions", func() {
+	var (
+		fake   *fakeTrino
+		server *testServer
+	)
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		fake.On("SELECT 1", fakeResult{
+			Columns: []fakeColumn{col("_col0", "integer")},
+			Rows:    [][]driver.Value{{int64(1)}},
+		})
+		server = startServer(fake, &config.Config{IdleInTransactionTimeout: 50 * time.Millisecond})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("answers transaction control statements without Trino when it cannot start Trino transactions", func() {
+		db := server.Connect("memory")
+		defer db.Close()
+		tx, err := db.Begin()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tx.Commit()).To(Succeed())
+		tx, err = db.Begin()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tx.Rollback()).To(Succeed())
+		Expect(fake.Queries()).To(BeEmpty())
+	})
+
+	It("keeps sessions open while idle outside a transaction", func() {
+		ctx := context.Background()
+		db := server.Connect("memory")
+		defer db.Close()
+		conn, err := db.Conn(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		tx, err := conn.BeginTx(ctx, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tx.Commit()).To(Succeed())
+		time.Sleep(200 * time.Millisecond)
+
+		var value int
+		Expect(conn.QueryRowContext(ctx, "SELECT 1;").Scan(&value)).To(Succeed())
+	})
+
+	It("closes sessions left idle inside a transaction", func() {
+		ctx := context.Background()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		tx, err := db.BeginTx(ctx, nil)
+		Expect(err).NotTo(HaveOccurred())
+		time.Sleep(200 * time.Millisecond)
+
+		var value int
+		Expect(tx.QueryRowContext(ctx, "SELECT 1;").Scan(&value)).NotTo(Succeed())
+		Expect(fake.Queries()).To(BeEmpty())
+	})
+
+	It("rejects statements modifying data in a block it cannot back with a Trino transaction", func() {
+		fake.On("INSERT INTO orders VALUES (1)", fakeResult{RowsAffected: 1})
+		db := server.Connect("memory")
+		defer db.Close()
+
+		tx, err := db.Begin()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tx.Exec("INSERT INTO orders VALUES (1);")
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*pq.Error).Code).To(Equal(pq.ErrorCode("0A000")))
+		Expect(err.(*pq.Error).Hint).To(ContainSubstring("outside of the transaction block"))
+		var value int
+		Expect(tx.QueryRow("SELECT 1;").Scan(&value)).To(Succeed())
+		Expect(tx.Rollback()).To(Succeed())
+		Expect(fake.Committed()).To(BeEmpty())
+
+		_, err = db.Exec("INSERT INTO orders VALUES (1);")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fake.Committed()).To(Equal([]string{"INSERT INTO orders VALUES (1)"}))
+	})
+
+	Context("with the HTTP client of pg2trino", func() {
+		var (
+			cluster *trinoHTTP
+			served  *testServer
+			db      *sql.DB
+		)
+
+		BeforeEach(func() {
+			cluster = newTrinoHTTP()
+			tdb, err := pgtrino.NewTrinoDB(&config.Config{TrinoDSN: cluster.DSN()})
+			Expect(err).NotTo(HaveOccurred())
+			trinoServer, err := pgtrino.NewServer(tdb)
+			Expect(err).NotTo(HaveOccurred())
+			served = serve(trinoServer)
+			db = served.Connect("hive")
+		})
+
+		AfterEach(func() {
+			db.Close()
+			served.Close()
+			cluster.Close()
+		})
+
+		// transactions returns the statements Trino received with the
+		// transaction they ran in.
+		transactions := func() [][2]string {
+			var statements [][2]string
+			for _, statement := range cluster.Statements() {
+				statements = append(statements, [2]string{statement.Query, statement.Header.Get("X-Trino-Transaction-Id")})
+			}
+			return statements
+		}
+
+		It("runs the statements of a transaction block in a Trino transaction", func() {
+			tx, err := db.Begin()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = tx.Exec("INSERT INTO orders VALUES (1)")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tx.Rollback()).To(Succeed())
+
+			tx, err = db.Begin()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = tx.Exec("INSERT INTO orders VALUES (2)")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tx.Commit()).To(Succeed())
+
+			_, err = db.Exec("INSERT INTO orders VALUES (3)")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(transactions()).To(Equal([][2]string{
+				{"START TRANSACTION", "NONE"},
+				{"INSERT INTO orders VALUES (1)", "tx1"},
+				{"ROLLBACK", "tx1"},
+				{"START TRANSACTION", "NONE"},
+				{"INSERT INTO orders VALUES (2)", "tx2"},
+				{"COMMIT", "tx2"},
+				{"INSERT INTO orders VALUES (3)", ""},
+			}))
+		})
+
+		It("rejects the transaction control statements Trino has no equivalent for without ending the block", func() {
+			tx, err := db.Begin()
+			Expect(err).NotTo(HaveOccurred())
+			for _, stmt := range []string{
+				"SAVEPOINT before_insert",
+				"RELEASE SAVEPOINT before_insert",
+				"ROLLBACK TO SAVEPOINT before_insert",
+				"ROLLBACK WORK TO before_insert",
+				"ROLLBACK AND CHAIN",
+				"COMMIT AND CHAIN",
+				"COMMIT PREPARED 'orders'",
+				"PREPARE TRANSACTION 'orders'",
+			} {
+				_, err = tx.Exec(stmt)
+				Expect(err).To(HaveOccurred(), stmt)
+				Expect(err.(*pq.Error).Code).To(Equal(pq.ErrorCode("0A000")), stmt)
+			}
+			_, err = tx.Exec("INSERT INTO orders VALUES (1)")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tx.Commit()).To(Succeed())
+			Expect(transactions()).To(Equal([][2]string{
+				{"START TRANSACTION", "NONE"},
+				{"INSERT INTO orders VALUES (1)", "tx1"},
+				{"COMMIT", "tx1"},
+			}))
+		})
+
+		It("rolls back failed transaction blocks and explains statements Trino only runs in auto-commit mode", func() {
+			cluster.Fail("DELETE FROM orders", "Catalog only supports writes using autocommit: hive")
+			tx, err := db.Begin()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = tx.Exec("DELETE FROM orders")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*pq.Error).Code).To(Equal(pq.ErrorCode("25001")))
+			Expect(err.(*pq.Error).Hint).To(ContainSubstring("outside of the transaction block"))
+
+			Expect(tx.Commit()).To(MatchError(pq.ErrInFailedTransaction))
+			Expect(transactions()).To(HaveLen(3))
+			Expect(transactions()[2]).To(Equal([2]string{"ROLLBACK", "tx1"}))
+		})
+
+		It("rolls back the Trino transaction of a closed connection", func() {
+			conn, err := pq.Open(served.DSN("hive"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = conn.(driver.ConnBeginTx).BeginTx(context.Background(), driver.TxOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.Close()).To(Succeed())
+			Eventually(transactions).Should(Equal([][2]string{
+				{"START TRANSACTION", "NONE"},
+				{"ROLLBACK", "tx1"},
+			}))
+		})
+
+		It("rejects statements modifying data in a block with the custom_client of TRINO_DSN", func() {
+			Expect(trino.RegisterCustomClient("transactions-test", http.DefaultClient)).To(Succeed())
+			tdb, err := pgtrino.NewTrinoDB(&config.Config{TrinoDSN: cluster.DSN() + "&custom_client=transactions-test"})
+			Expect(err).NotTo(HaveOccurred())
+			customServer, err := pgtrino.NewServer(tdb)
+			Expect(err).NotTo(HaveOccurred())
+			custom := serve(customServer)
+			defer custom.Close()
+			customDB := custom.Connect("hive")
+			defer customDB.Close()
+
+			tx, err := customDB.Begin()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = tx.Exec("INSERT INTO orders VALUES (1)")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*pq.Error).Code).To(Equal(pq.ErrorCode("0A000")))
+			Expect(err.(*pq.Error).Hint).To(ContainSubstring("custom_client"))
+			Expect(tx.Rollback()).To(Succeed())
+			Expect(cluster.Statements()).To(BeEmpty())
+		})
+	})
+})
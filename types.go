@@ -0,0 +1,525 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/lib/pq/oid"
+	trino "github.com/trinodb/trino-go-client/trino"
+)
+
+// typeExtractor describes how values of a Trino driver scan type are sent to
+// the client: the Postgres type they are reported as and how the value is
+// taken out of its Null* wrapper.
+type typeExtractor struct {
+	oid   oid.Oid
+	value func(v any, s *Session) any
+}
+
+// extractorFor builds a typeExtractor for the scan type T.
+func extractorFor[T any](typ oid.Oid, value func(T) any) typeExtractor {
+	return sessionExtractorFor(typ, func(v T, _ *Session) any {
+		return value(v)
+	})
+}
+
+// sessionExtractorFor builds a typeExtractor for the scan type T whose
+// values depend on the settings of the client session. The extractor accepts
+// both T and the *T scan destination, which avoids copying the scanned value
+// into an interface.
+func sessionExtractorFor[T any](typ oid.Oid, value func(T, *Session) any) typeExtractor {
+	return typeExtractor{
+		oid: typ,
+		value: func(v any, s *Session) any {
+			if p, ok := v.(*T); ok {
+				return value(*p, s)
+			}
+			return value(v.(T), s)
+		},
+	}
+}
+
+// textExtractor sends values of unregistered types as text.
+var textExtractor = typeExtractor{
+	oid: oid.T_text,
+	value: func(v any, _ *Session) any {
+		return fmt.Sprintf("%v", reflect.Indirect(reflect.ValueOf(v)).Interface())
+	},
+}
+
+// jsonValue formats v as JSON. Values that cannot be marshaled are sent as
+// a JSON string holding their text form.
+func jsonValue(v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprintf("%v", v))
+	}
+	return string(b)
+}
+
+// typeOf returns the reflect.Type of T.
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf(new(T)).Elem()
+}
+
+// arrayValue formats the elements of a NullSlice array, such as the
+// []sql.NullInt64 of an ARRAY(BIGINT), in the Postgres array syntax, e.g.
+// {1,NULL,3}. An empty array is sent as {}, unlike a NULL array, which the
+// row scanner sends as NULL. Floats are formatted as the extra_float_digits
+// setting of the session asks, and dates and times as their Trino type
+// elem, such as DATE, asks.
+func arrayValue[T any](v T, s *Session, elem string) any {
+	t := trinoType{kind: "scalar"}
+	for typ := reflect.TypeOf(v); typ.Kind() == reflect.Slice; typ = typ.Elem() {
+		t = trinoType{kind: "array", args: []trinoType{t}}
+	}
+	return formatNested(arrayElements(reflect.ValueOf(v), s, elem), t)
+}
+
+// arrayElements converts the nested slices of sql.Null* values of a
+// NullSlice array to the []any values formatNested renders, with nil for
+// NULL elements.
+func arrayElements(v reflect.Value, s *Session, elem string) any {
+	if v.Kind() == reflect.Slice {
+		values := make([]any, v.Len())
+		for i := range values {
+			values[i] = arrayElements(v.Index(i), s, elem)
+		}
+		return values
+	}
+	switch e := v.Interface().(type) {
+	case sql.NullBool:
+		return nullable(e.Valid, e.Bool)
+	case sql.NullString:
+		return nullable(e.Valid, e.String)
+	case sql.NullInt64:
+		return nullable(e.Valid, strconv.FormatInt(e.Int64, 10))
+	case sql.NullFloat64:
+		text, _ := float8{value: e.Float64, precision: s.floatPrecision()}.TextValue()
+		return nullable(e.Valid, text.String)
+	case trino.NullTime:
+		return nullable(e.Valid, formatTimeElement(e.Time, s, elem))
+	case trino.NullMap:
+		return nullable(e.Valid, e.Map)
+	default:
+		return fmt.Sprintf("%v", e)
+	}
+}
+
+// typePrecision matches the precision of a Trino type name, such as the
+// (3) of TIMESTAMP(3).
+var typePrecision = regexp.MustCompile(`\(\d+\)`)
+
+// formatTimeElement formats a date or time array element of the Trino type
+// elem as Postgres prints the matching type: timestamps with a time zone in
+// the TimeZone of the session, and times with a time zone with their own
+// offset.
+func formatTimeElement(t time.Time, s *Session, elem string) string {
+	switch typePrecision.ReplaceAllString(elem, "") {
+	case "DATE":
+		return t.Format("2006-01-02")
+	case "TIME":
+		return t.Format("15:04:05.999999")
+	case "TIME WITH TIME ZONE":
+		_, offset := t.Zone()
+		return t.Format("15:04:05.999999") + formatOffset(offset)
+	case "TIMESTAMP WITH TIME ZONE":
+		return formatTimestamptz(t, s.Location())
+	default:
+		return t.Format("2006-01-02 15:04:05.999999")
+	}
+}
+
+// timeArrayExtractor returns the extractor of arrays of dates or times,
+// whose elements the Trino driver scans into time.Time values whatever
+// their Trino type elem.
+func timeArrayExtractor(elem string) typeExtractor {
+	return typeExtractor{
+		oid: oid.T_text,
+		value: func(v any, s *Session) any {
+			switch v := reflect.Indirect(reflect.ValueOf(v)).Interface().(type) {
+			case trino.NullSliceTime:
+				return arrayValue(v.SliceTime, s, elem)
+			case trino.NullSlice2Time:
+				return arrayValue(v.Slice2Time, s, elem)
+			case trino.NullSlice3Time:
+				return arrayValue(v.Slice3Time, s, elem)
+			default:
+				return fmt.Sprintf("%v", v)
+			}
+		},
+	}
+}
+
+// arrayElementType returns the Trino type of the innermost elements of the
+// array type typeName, such as DATE for ARRAY(ARRAY(DATE)).
+func arrayElementType(typeName string) string {
+	for strings.HasPrefix(typeName, "ARRAY(") && strings.HasSuffix(typeName, ")") {
+		typeName = typeName[len("ARRAY(") : len(typeName)-1]
+	}
+	return typeName
+}
+
+// nullable returns value, or nil if it is not valid.
+func nullable[T any](valid bool, value T) any {
+	if !valid {
+		return nil
+	}
+	return value
+}
+
+// float8 is a float8 value. Its text form uses the Postgres spellings NaN,
+// Infinity and -Infinity instead of Go's +Inf and -Inf, and otherwise the
+// given number of significant digits, or the fewest digits that round-trip
+// when precision is zero. Its binary form is the plain IEEE 754 value.
+type float8 struct {
+	value     float64
+	precision int
+}
+
+func (f float8) TextValue() (pgtype.Text, error) {
+	switch {
+	case math.IsInf(f.value, 1):
+		return pgtype.Text{String: "Infinity", Valid: true}, nil
+	case math.IsInf(f.value, -1):
+		return pgtype.Text{String: "-Infinity", Valid: true}, nil
+	case math.IsNaN(f.value):
+		return pgtype.Text{String: "NaN", Valid: true}, nil
+	case f.precision > 0:
+		return pgtype.Text{String: strconv.FormatFloat(f.value, 'g', f.precision, 64), Valid: true}, nil
+	default:
+		return pgtype.Text{String: strconv.FormatFloat(f.value, 'g', -1, 64), Valid: true}, nil
+	}
+}
+
+func (f float8) Float64Value() (pgtype.Float8, error) {
+	return pgtype.Float8{Float64: f.value, Valid: true}, nil
+}
+
+// numeric is a numeric value given by its decimal text, such as 1.50. Its
+// text form is that text and its binary form the Postgres numeric encoding
+// of the same digits, which binary clients such as pgx request.
+type numeric string
+
+func (n numeric) TextValue() (pgtype.Text, error) {
+	return pgtype.Text{String: string(n), Valid: true}, nil
+}
+
+func (n numeric) NumericValue() (pgtype.Numeric, error) {
+	var value pgtype.Numeric
+	err := value.Scan(string(n))
+	return value, err
+}
+
+// timeOfDay is a time value, the time of day of a time.Time. Its text form
+// is the Postgres one, such as 12:34:56.789, and its binary form the
+// microseconds since midnight.
+type timeOfDay time.Time
+
+func (t timeOfDay) TextValue() (pgtype.Text, error) {
+	return pgtype.Text{String: time.Time(t).Format("15:04:05.999999"), Valid: true}, nil
+}
+
+func (t timeOfDay) TimeValue() (pgtype.Time, error) {
+	hour, minute, second := time.Time(t).Clock()
+	microseconds := int64(hour*3600+minute*60+second)*1e6 + int64(time.Time(t).Nanosecond()/1e3)
+	return pgtype.Time{Microseconds: microseconds, Valid: true}, nil
+}
+
+// trimText returns the value sent for a Trino string, with its leading and
+// trailing whitespace trimmed when TRIM_TEXT is set.
+func trimText(v sql.NullString, s *Session) any {
+	if s.Config().TrimText {
+		return strings.TrimSpace(v.String)
+	}
+	return v.String
+}
+
+// floatValue returns the value sent for a Trino real or double, formatted
+// as the extra_float_digits setting of the session asks.
+func floatValue(v sql.NullFloat64, s *Session) any {
+	return float8{value: v.Float64, precision: s.floatPrecision()}
+}
+
+// scanTypeExtractors maps every scan type used by the Trino driver to its extractor.
+// Adding support for a new type only requires registering it here.
+var scanTypeExtractors = map[reflect.Type]typeExtractor{
+	typeOf[sql.NullBool]():    extractorFor(oid.T_bool, func(v sql.NullBool) any { return v.Bool }),
+	typeOf[sql.NullString]():  sessionExtractorFor(oid.T_text, trimText),
+	typeOf[sql.NullInt32]():   extractorFor(oid.T_int4, func(v sql.NullInt32) any { return int64(v.Int32) }),
+	typeOf[sql.NullInt64]():   extractorFor(oid.T_int8, func(v sql.NullInt64) any { return v.Int64 }),
+	typeOf[sql.NullFloat64](): sessionExtractorFor(oid.T_float8, floatValue),
+	typeOf[sql.NullTime]():    extractorFor(oid.T_timestamp, func(v sql.NullTime) any { return v.Time }),
+
+	typeOf[trino.NullMap]():           extractorFor(oid.T_json, func(v trino.NullMap) any { return jsonValue(v.Map) }),
+	typeOf[trino.NullSliceBool]():     sessionExtractorFor(oid.T_text, func(v trino.NullSliceBool, s *Session) any { return arrayValue(v.SliceBool, s, "") }),
+	typeOf[trino.NullSliceString]():   sessionExtractorFor(oid.T_text, func(v trino.NullSliceString, s *Session) any { return arrayValue(v.SliceString, s, "") }),
+	typeOf[trino.NullSliceInt64]():    sessionExtractorFor(oid.T_text, func(v trino.NullSliceInt64, s *Session) any { return arrayValue(v.SliceInt64, s, "") }),
+	typeOf[trino.NullSliceFloat64]():  sessionExtractorFor(oid.T_text, func(v trino.NullSliceFloat64, s *Session) any { return arrayValue(v.SliceFloat64, s, "") }),
+	typeOf[trino.NullSliceTime]():     timeArrayExtractor("TIMESTAMP"),
+	typeOf[trino.NullSliceMap]():      sessionExtractorFor(oid.T_text, func(v trino.NullSliceMap, s *Session) any { return arrayValue(v.SliceMap, s, "") }),
+	typeOf[trino.NullSlice2Bool]():    sessionExtractorFor(oid.T_text, func(v trino.NullSlice2Bool, s *Session) any { return arrayValue(v.Slice2Bool, s, "") }),
+	typeOf[trino.NullSlice2String]():  sessionExtractorFor(oid.T_text, func(v trino.NullSlice2String, s *Session) any { return arrayValue(v.Slice2String, s, "") }),
+	typeOf[trino.NullSlice2Int64]():   sessionExtractorFor(oid.T_text, func(v trino.NullSlice2Int64, s *Session) any { return arrayValue(v.Slice2Int64, s, "") }),
+	typeOf[trino.NullSlice2Float64](): sessionExtractorFor(oid.T_text, func(v trino.NullSlice2Float64, s *Session) any { return arrayValue(v.Slice2Float64, s, "") }),
+	typeOf[trino.NullSlice2Time]():    timeArrayExtractor("TIMESTAMP"),
+	typeOf[trino.NullSlice2Map]():     sessionExtractorFor(oid.T_text, func(v trino.NullSlice2Map, s *Session) any { return arrayValue(v.Slice2Map, s, "") }),
+	typeOf[trino.NullSlice3Bool]():    sessionExtractorFor(oid.T_text, func(v trino.NullSlice3Bool, s *Session) any { return arrayValue(v.Slice3Bool, s, "") }),
+	typeOf[trino.NullSlice3String]():  sessionExtractorFor(oid.T_text, func(v trino.NullSlice3String, s *Session) any { return arrayValue(v.Slice3String, s, "") }),
+	typeOf[trino.NullSlice3Int64]():   sessionExtractorFor(oid.T_text, func(v trino.NullSlice3Int64, s *Session) any { return arrayValue(v.Slice3Int64, s, "") }),
+	typeOf[trino.NullSlice3Float64](): sessionExtractorFor(oid.T_text, func(v trino.NullSlice3Float64, s *Session) any { return arrayValue(v.Slice3Float64, s, "") }),
+	typeOf[trino.NullSlice3Time]():    timeArrayExtractor("TIMESTAMP"),
+	typeOf[trino.NullSlice3Map]():     sessionExtractorFor(oid.T_text, func(v trino.NullSlice3Map, s *Session) any { return arrayValue(v.Slice3Map, s, "") }),
+}
+
+// typeNameExtractors maps Trino type names, as reported by
+// DatabaseTypeName, to their extractor. They take precedence over the scan
+// type for Trino types sharing a scan type with a different Postgres type.
+// JSON text is sent verbatim, as decoding and encoding it again would
+// reorder the keys of its objects.
+var typeNameExtractors = map[string]typeExtractor{
+	"CHAR": sessionExtractorFor(oid.T_text, func(v sql.NullString, s *Session) any {
+		if s.Config().TrimChar {
+			v.String = strings.TrimRight(v.String, " ")
+		}
+		return trimText(v, s)
+	}),
+	"DECIMAL":  extractorFor(oid.T_numeric, func(v sql.NullString) any { return numeric(v.String) }),
+	"JSON":     extractorFor(oid.T_json, func(v sql.NullString) any { return v.String }),
+	"SMALLINT": extractorFor(oid.T_int2, func(v sql.NullInt32) any { return int64(v.Int32) }),
+	"TINYINT":  extractorFor(oid.T_int2, func(v sql.NullInt32) any { return int64(v.Int32) }),
+	"TIME":     extractorFor(oid.T_time, func(v sql.NullTime) any { return timeOfDay(v.Time) }),
+	"TIMESTAMP WITH TIME ZONE": sessionExtractorFor(oid.T_timestamptz, func(v sql.NullTime, s *Session) any {
+		return formatTimestamptz(v.Time, s.Location())
+	}),
+}
+
+// mappedTypes are the scalar Trino types, as reported by DatabaseTypeName,
+// sent as the Postgres type they correspond to. The other types the Trino
+// driver reads, such as UUID, IPADDRESS and the interval types, are sent as
+// text. UNKNOWN is the type of a NULL literal, which Postgres sends as text
+// too.
+var mappedTypes = map[string]bool{
+	"BOOLEAN": true, "TINYINT": true, "SMALLINT": true, "INTEGER": true, "BIGINT": true,
+	"REAL": true, "DOUBLE": true, "DECIMAL": true,
+	"VARCHAR": true, "CHAR": true, "VARBINARY": true, "JSON": true, "UNKNOWN": true,
+	"DATE": true, "TIME": true, "TIME WITH TIME ZONE": true, "TIMESTAMP": true, "TIMESTAMP WITH TIME ZONE": true,
+}
+
+// isMappedType reports whether the Trino type typeName is sent as a
+// Postgres type of its own. Arrays, maps and rows are, whatever their
+// element types.
+func isMappedType(typeName string) bool {
+	return mappedTypes[typeName] || parseTrinoType(typeName).kind != "scalar"
+}
+
+// geometryTypes are the Trino spatial types, which the Trino driver cannot
+// read, by the expression selecting the WKT text of a value.
+var geometryTypes = map[string]string{
+	"GEOMETRY":           "ST_AsText(%s)",
+	"SPHERICALGEOGRAPHY": "ST_AsText(to_geometry(%s))",
+}
+
+// wktQuery returns query wrapped to select the WKT text of its spatial
+// columns instead of their values, keeping the names of all columns.
+func wktQuery(query string, columns []ColumnType) string {
+	selects := make([]string, len(columns))
+	aliases := make([]string, len(columns))
+	for i, col := range columns {
+		aliases[i] = fmt.Sprintf("_col%d", i)
+		selects[i] = aliases[i]
+		if expr, ok := geometryTypes[col.DatabaseTypeName()]; ok {
+			selects[i] = fmt.Sprintf(expr, aliases[i])
+		}
+		selects[i] += " AS " + quoteIdentifier(col.Name())
+	}
+	// The newline ends a trailing comment of the query.
+	return fmt.Sprintf("SELECT %s FROM (%s\n) AS wkt (%s)",
+		strings.Join(selects, ", "), trimStatement(query), strings.Join(aliases, ", "))
+}
+
+// decimalExtractor returns the extractor of DECIMAL(p, s) columns, which
+// sends the values as numeric with exactly scale fractional digits, e.g.
+// 1.50 for 1.5 in a DECIMAL(10, 2) column.
+func decimalExtractor(scale int64) typeExtractor {
+	return extractorFor(oid.T_numeric, func(v sql.NullString) any {
+		return numeric(formatDecimal(v.String, int(scale)))
+	})
+}
+
+// formatDecimal pads the decimal string s with trailing zeros up to scale
+// fractional digits. Its digits are kept as they are otherwise.
+func formatDecimal(s string, scale int) string {
+	if scale <= 0 || strings.ContainsAny(s, "eE") {
+		return s
+	}
+	digits := 0
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		digits = len(s) - i - 1
+	} else {
+		s += "."
+	}
+	if digits < scale {
+		s += strings.Repeat("0", scale-digits)
+	}
+	return s
+}
+
+// lookupExtractor returns the extractor for a column of the given Trino type
+// name and scan type. Arrays of dates and times are formatted as their
+// element type asks. Unregistered types are sent as text.
+func lookupExtractor(typeName string, scanType reflect.Type) typeExtractor {
+	if e, ok := typeNameExtractors[typeName]; ok {
+		return e
+	}
+	switch scanType {
+	case typeOf[trino.NullSliceTime](), typeOf[trino.NullSlice2Time](), typeOf[trino.NullSlice3Time]():
+		if typeName != "" {
+			return timeArrayExtractor(arrayElementType(typeName))
+		}
+	}
+	if e, ok := scanTypeExtractors[scanType]; ok {
+		return e
+	}
+	if scanType == typeOf[any]() {
+		return compositeExtractor(typeName)
+	}
+	return textExtractor
+}
+
+// parseTypeOverrides parses the trinoType=pgType pairs of TYPE_OVERRIDES
+// into the Postgres type sent for each Trino type name, such as text for
+// decimal. Invalid pairs are reported and left out.
+func parseTypeOverrides(pairs []string) (map[string]oid.Oid, error) {
+	overrides := map[string]oid.Oid{}
+	var err error
+	for _, pair := range pairs {
+		trinoType, pgType, ok := strings.Cut(pair, "=")
+		trinoType = strings.ToUpper(strings.TrimSpace(trinoType))
+		typ := regtypeOid(pgType)
+		if !ok || trinoType == "" || typ == 0 {
+			err = fmt.Errorf("invalid TYPE_OVERRIDES pair %q: expected trinoType=pgType", pair)
+			continue
+		}
+		overrides[trinoType] = oid.Oid(typ)
+	}
+	return overrides, err
+}
+
+// overrideExtractor returns e reporting its values as the type typ. Values
+// overridden to text are sent in their text form.
+func overrideExtractor(e typeExtractor, typ oid.Oid) typeExtractor {
+	value := e.value
+	if typ == oid.T_text {
+		value = func(v any, s *Session) any {
+			return textForm(e.value(v, s))
+		}
+	}
+	return typeExtractor{oid: typ, value: value}
+}
+
+// textForm returns the text of a value sent to the client.
+func textForm(v any) any {
+	switch v := v.(type) {
+	case nil, string:
+		return v
+	case pgtype.TextValuer:
+		text, err := v.TextValue()
+		if err != nil || !text.Valid {
+			return nil
+		}
+		return text.String
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// pgTypeNames are the Postgres names of the types sent to clients, as
+// reported by pg_typeof.
+var pgTypeNames = map[oid.Oid]string{
+	oid.T_bool:        "boolean",
+	oid.T_int2:        "smallint",
+	oid.T_int4:        "integer",
+	oid.T_int8:        "bigint",
+	oid.T_float8:      "double precision",
+	oid.T_numeric:     "numeric",
+	oid.T_text:        "text",
+	oid.T_time:        "time without time zone",
+	oid.T_timestamp:   "timestamp without time zone",
+	oid.T_timestamptz: "timestamp with time zone",
+	oid.T_json:        "json",
+	oid.T_record:      "record",
+	oid.T__record:     "record[]",
+}
+
+// pgTypeName returns the Postgres name of a type sent to clients, or
+// "unknown" if it has none.
+func pgTypeName(typ oid.Oid) string {
+	if name, ok := pgTypeNames[typ]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// maxTimePrecision is the highest fractional seconds precision of Postgres
+// time values.
+const maxTimePrecision = 6
+
+// typeModifier returns the Postgres type modifier of a result column, the
+// precision of time(p) columns, or -1 if it has none.
+func typeModifier(col ColumnType, typ oid.Oid) int32 {
+	if typ != oid.T_time {
+		return -1
+	}
+	precision, _, ok := col.DecimalSize()
+	if !ok {
+		return -1
+	}
+	return int32(min(precision, maxTimePrecision))
+}
+
+// typeOid returns the Postgres type reported for a Trino driver scan type.
+// Unregistered types are reported as text.
+func typeOid(scanType reflect.Type) oid.Oid {
+	return lookupExtractor("", scanType).oid
+}
+
+// extract returns the value sent to the client for a scanned Null* value
+// together with its Postgres type. Unregistered types are sent as text.
+func extract(v any, s *Session) (any, oid.Oid) {
+	e := lookupExtractor("", reflect.TypeOf(v))
+	return e.value(v, s), e.oid
+}
+
+// formatTimestamptz renders t in loc the way Postgres prints timestamptz
+// values, e.g. 2001-08-22 03:04:05.123+05:30.
+func formatTimestamptz(t time.Time, loc *time.Location) string {
+	t = t.In(loc)
+	_, offset := t.Zone()
+	return t.Format("2006-01-02 15:04:05.999999") + formatOffset(offset)
+}
+
+// formatOffset renders a UTC offset in seconds the way Postgres prints
+// the offsets of timestamptz and timetz values, e.g. +05:30 or -08.
+func formatOffset(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	zone := fmt.Sprintf("%c%02d", sign, offset/3600)
+	if minutes := offset % 3600 / 60; minutes != 0 {
+		zone += fmt.Sprintf(":%02d", minutes)
+	}
+	return zone
+}
@@ -0,0 +1,837 @@
+package main_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/lib/pq"
+	"github.com/lib/pq/oid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	trino "github.com/trinodb/trino-go-client/trino"
+)
+
+var _ = Describe("Type registry", func() {
+	It("registers a value extractor and an OID for every type", func() {
+		types := pgtrino.RegisteredTypes()
+		Expect(types).NotTo(BeEmpty())
+		for _, t := range types {
+			Expect(pgtrino.HasValueExtractor(t)).To(BeTrue(), "missing value extractor for %s", t)
+			Expect(pgtrino.TypeOid(t)).NotTo(BeZero(), "missing OID for %s", t)
+
+			_, typ := pgtrino.Extract(reflect.New(t).Elem().Interface(), &pgtrino.Session{})
+			Expect(typ).To(Equal(pgtrino.TypeOid(t)), "extracted OID differs for %s", t)
+		}
+	})
+
+	It("extracts values from their Null* wrappers", func() {
+		now := time.Now()
+		value, typ := pgtrino.Extract(sql.NullBool{Bool: true, Valid: true}, &pgtrino.Session{})
+		Expect(value).To(Equal(true))
+		Expect(typ).To(Equal(oid.T_bool))
+		value, typ = pgtrino.Extract(sql.NullInt32{Int32: 7, Valid: true}, &pgtrino.Session{})
+		Expect(value).To(Equal(int64(7)))
+		Expect(typ).To(Equal(oid.T_int4))
+		value, typ = pgtrino.Extract(sql.NullTime{Time: now, Valid: true}, &pgtrino.Session{})
+		Expect(value).To(Equal(now))
+		Expect(typ).To(Equal(oid.T_timestamp))
+
+		value, typ = pgtrino.Extract(trino.NullSliceInt64{
+			SliceInt64: []sql.NullInt64{{Int64: 1, Valid: true}},
+			Valid:      true,
+		}, &pgtrino.Session{})
+		Expect(value).To(Equal("{1}"))
+		Expect(typ).To(Equal(oid.T_text))
+		value, _ = pgtrino.Extract(trino.NullSlice2Int64{
+			Slice2Int64: [][]sql.NullInt64{{{Int64: 1, Valid: true}, {}}, {}},
+			Valid:       true,
+		}, &pgtrino.Session{})
+		Expect(value).To(Equal("{{1,NULL},{}}"))
+	})
+
+	It("falls back to text for unregistered types", func() {
+		Expect(pgtrino.TypeOid(reflect.TypeOf(struct{}{}))).To(Equal(oid.T_text))
+	})
+
+	It("sends Trino JSON values verbatim, keeping the order of their keys", func() {
+		fake := newFakeTrino()
+		const document = `{"zeta":1,"alpha":[2,{"y":null,"b":"x"}],"mid":{"k":true}}`
+		fake.On("SELECT doc FROM documents", fakeResult{
+			Columns: []fakeColumn{col("doc", "json")},
+			Rows:    [][]driver.Value{{document}},
+		})
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		rows, err := db.Query("SELECT doc FROM documents;")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		types, err := rows.ColumnTypes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(types[0].DatabaseTypeName()).To(Equal("JSON"))
+		Expect(rows.Next()).To(BeTrue())
+		var doc string
+		Expect(rows.Scan(&doc)).To(Succeed())
+		Expect(doc).To(Equal(document))
+	})
+
+	It("fails Trino types without a Postgres mapping with STRICT_TYPES", func() {
+		const id = "12151fd2-7586-11e9-8f9e-2a86e4085a59"
+		fake := newFakeTrino()
+		fake.On("SELECT uuid", fakeResult{
+			Columns: []fakeColumn{col("id", "uuid")},
+			Rows:    [][]driver.Value{{id}},
+		})
+		fake.On("SELECT id, name, tags, NULL", fakeResult{
+			Columns: []fakeColumn{col("id", "bigint"), col("name", "varchar(10)"), col("tags", "array(varchar)"), col("_col3", "unknown")},
+			Rows:    [][]driver.Value{{int64(1), "tea", []any{"hot"}, nil}},
+		})
+
+		lenient := startServer(fake, &config.Config{})
+		defer lenient.Close()
+		db := lenient.Connect("memory")
+		defer db.Close()
+		var value string
+		Expect(db.QueryRow("SELECT uuid;").Scan(&value)).To(Succeed())
+		Expect(value).To(Equal(id))
+
+		strict := startServer(fake, &config.Config{StrictTypes: true})
+		defer strict.Close()
+		db = strict.Connect("memory")
+		defer db.Close()
+		err := db.QueryRow("SELECT uuid;").Scan(&value)
+		Expect(err).To(MatchError(ContainSubstring(`column "id" has the unsupported Trino type uuid`)))
+		Expect(err).To(BeAssignableToTypeOf(&pq.Error{}))
+		Expect(err.(*pq.Error).Code).To(BeEquivalentTo("0A000"))
+
+		var name, tags string
+		var null sql.NullString
+		var number int64
+		Expect(db.QueryRow("SELECT id, name, tags, NULL;").Scan(&number, &name, &tags, &null)).To(Succeed())
+		Expect(name).To(Equal("tea"))
+
+		overridden := startServer(fake, &config.Config{StrictTypes: true, TypeOverrides: []string{"uuid=text"}})
+		defer overridden.Close()
+		db = overridden.Connect("memory")
+		defer db.Close()
+		Expect(db.QueryRow("SELECT uuid;").Scan(&value)).To(Succeed())
+		Expect(value).To(Equal(id))
+	})
+
+	It("falls back to the scan type and then text for empty type names", func() {
+		fake := newFakeTrino()
+		fake.On("SELECT a, b", fakeResult{
+			Columns: []fakeColumn{
+				{Name: "a", Type: "", ScanType: reflect.TypeOf(sql.NullInt64{})},
+				{Name: "b", Type: ""},
+			},
+			Rows: [][]driver.Value{{int64(5), int64(6)}},
+		})
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		rows, err := db.Query("SELECT a, b;")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		types, err := rows.ColumnTypes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(types[0].DatabaseTypeName()).To(Equal("INT8"))
+		Expect(types[1].DatabaseTypeName()).To(Equal("TEXT"))
+		Expect(rows.Next()).To(BeTrue())
+		var a int64
+		var b string
+		Expect(rows.Scan(&a, &b)).To(Succeed())
+		Expect(a).To(Equal(int64(5)))
+		Expect(b).To(Equal("6"))
+	})
+})
+
+var _ = Describe("CHAR values", func() {
+	var fake *fakeTrino
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		fake.On("SELECT c", fakeResult{
+			Columns: []fakeColumn{col("c", "char(5)")},
+			Rows:    [][]driver.Value{{"ab   "}},
+		})
+	})
+
+	query := func(cfg *config.Config) string {
+		server := startServer(fake, cfg)
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+		var value string
+		Expect(db.QueryRow("SELECT c;").Scan(&value)).To(Succeed())
+		return value
+	}
+
+	It("preserves the space padding by default", func() {
+		Expect(query(&config.Config{})).To(Equal("ab   "))
+	})
+
+	It("trims the space padding with TRIM_CHAR", func() {
+		Expect(query(&config.Config{TrimChar: true})).To(Equal("ab"))
+	})
+})
+
+var _ = Describe("Text values", func() {
+	var fake *fakeTrino
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		fake.On("SELECT v, c", fakeResult{
+			Columns: []fakeColumn{col("v", "varchar"), col("c", "char(6)")},
+			Rows:    [][]driver.Value{{" \tab c\n ", " ab   "}},
+		})
+	})
+
+	query := func(cfg *config.Config) []string {
+		server := startServer(fake, cfg)
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+		values := make([]string, 2)
+		Expect(db.QueryRow("SELECT v, c;").Scan(&values[0], &values[1])).To(Succeed())
+		return values
+	}
+
+	It("preserves surrounding whitespace by default", func() {
+		Expect(query(&config.Config{})).To(Equal([]string{" \tab c\n ", " ab   "}))
+	})
+
+	It("trims surrounding whitespace with TRIM_TEXT", func() {
+		Expect(query(&config.Config{TrimText: true})).To(Equal([]string{"ab c", "ab"}))
+	})
+})
+
+var _ = Describe("Map values", func() {
+	var fake *fakeTrino
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		fake.On("SELECT tags, counts", fakeResult{
+			Columns: []fakeColumn{col("tags", "map(varchar, varchar)"), col("counts", "map(varchar, bigint)")},
+			Rows: [][]driver.Value{{
+				map[string]any{"env": "prod", `say "hi"`: `c:\tmp`, "none": nil},
+				map[string]any{"a": int64(1)},
+			}},
+		})
+	})
+
+	query := func(cfg *config.Config) []string {
+		server := startServer(fake, cfg)
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+		values := make([]string, 2)
+		Expect(db.QueryRow("SELECT tags, counts;").Scan(&values[0], &values[1])).To(Succeed())
+		return values
+	}
+
+	It("sends maps as JSON by default", func() {
+		values := query(&config.Config{})
+		Expect(values[0]).To(MatchJSON(`{"env": "prod", "say \"hi\"": "c:\\tmp", "none": null}`))
+		Expect(values[1]).To(MatchJSON(`{"a": 1}`))
+	})
+
+	It("sends MAP(VARCHAR, VARCHAR) values as hstore with HSTORE_MAPS", func() {
+		values := query(&config.Config{HstoreMaps: true})
+		Expect(values[0]).To(Equal(`"env"=>"prod", "none"=>NULL, "say \"hi\""=>"c:\\tmp"`))
+		Expect(values[1]).To(MatchJSON(`{"a": 1}`))
+	})
+})
+
+var _ = Describe("Spatial values", func() {
+	const (
+		query = "SELECT id, ST_Point(1.5, -2) AS point, to_spherical_geography(ST_Point(0, 0)) AS place FROM places"
+		wkt   = "SELECT _col0 AS \"id\", ST_AsText(_col1) AS \"point\", ST_AsText(to_geometry(_col2)) AS \"place\" FROM (" + query + "\n) AS wkt (_col0, _col1, _col2)"
+	)
+	var fake *fakeTrino
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		// The Trino driver reports the columns of spatial types, but fails
+		// to read their values.
+		fake.On(query, fakeResult{
+			Columns: []fakeColumn{col("id", "integer"), col("point", "geometry"), col("place", "sphericalgeography")},
+			Rows:    [][]driver.Value{{int64(1), "POINT (1.5 -2)", "POINT (0 0)"}},
+			NextErr: errors.New(`type not supported: "Geometry"`),
+		})
+		fake.On(wkt, fakeResult{
+			Columns: []fakeColumn{col("id", "integer"), col("point", "varchar"), col("place", "varchar")},
+			Rows:    [][]driver.Value{{int64(1), "POINT (1.5 -2)", "POINT (0 0)"}},
+		})
+	})
+
+	It("selects the WKT of spatial columns as text", func() {
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		rows, err := db.Query(query + ";")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		columns, err := rows.ColumnTypes()
+		Expect(err).NotTo(HaveOccurred())
+		var names []string
+		for _, column := range columns[1:] {
+			Expect(column.DatabaseTypeName()).To(Equal("TEXT"))
+			names = append(names, column.Name())
+		}
+		Expect(names).To(Equal([]string{"point", "place"}))
+		Expect(rows.Next()).To(BeTrue())
+		var (
+			id               int
+			point, geography string
+		)
+		Expect(rows.Scan(&id, &point, &geography)).To(Succeed())
+		Expect(point).To(Equal("POINT (1.5 -2)"))
+		Expect(geography).To(Equal("POINT (0 0)"))
+		Expect(fake.LastQuery().Query).To(Equal(wkt))
+	})
+
+	It("sends the configured geometry OID", func() {
+		tdb := pgtrino.NewTrinoDBFromDB(fake.DB(), &config.Config{GeometryOid: 16397})
+		columns, err := tdb.FetchColumns(context.Background(), query)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(columns[0].Oid).To(Equal(oid.T_int4))
+		for _, column := range columns[1:] {
+			Expect(column.Oid).To(BeEquivalentTo(16397))
+		}
+	})
+})
+
+var _ = Describe("Nested values", func() {
+	var (
+		fake   *fakeTrino
+		server *testServer
+	)
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		server = startServer(fake, &config.Config{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	query := func(typeName string, value driver.Value) string {
+		fake.On("SELECT v", fakeResult{
+			Columns: []fakeColumn{col("v", typeName)},
+			Rows:    [][]driver.Value{{value}},
+		})
+		db := server.Connect("memory")
+		defer db.Close()
+		var text string
+		Expect(db.QueryRow("SELECT v;").Scan(&text)).To(Succeed())
+		return text
+	}
+
+	It("tells NULL arrays apart from empty ones", func() {
+		fake.On("SELECT v", fakeResult{
+			Columns: []fakeColumn{col("v", "array(varchar)")},
+			Rows:    [][]driver.Value{{[]any{"a", nil, "c"}}, {[]any{}}, {nil}},
+		})
+		db := server.Connect("memory")
+		defer db.Close()
+		rows, err := db.Query("SELECT v;")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		var values []sql.NullString
+		for rows.Next() {
+			var value sql.NullString
+			Expect(rows.Scan(&value)).To(Succeed())
+			values = append(values, value)
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		Expect(values).To(Equal([]sql.NullString{
+			{String: "{a,NULL,c}", Valid: true},
+			{String: "{}", Valid: true},
+			{},
+		}))
+	})
+
+	It("serializes arrays of scalars in the Postgres array syntax", func() {
+		Expect(query("array(varchar)", []any{"a", "b c", `"q"`, "NULL"})).To(Equal(`{a,"b c","\"q\"","NULL"}`))
+	})
+
+	It("formats float elements as extra_float_digits asks", func() {
+		elements := []any{json.Number("0.1"), json.Number("0.3333333333333333"), "Infinity", "-Infinity", "NaN", nil}
+		Expect(query("array(double)", elements)).To(Equal("{0.1,0.3333333333333333,Infinity,-Infinity,NaN,NULL}"))
+
+		fake.On("SELECT v", fakeResult{
+			Columns: []fakeColumn{col("v", "array(double)")},
+			Rows:    [][]driver.Value{{elements}},
+		})
+		db := server.Connect("memory", "options=-c%20extra_float_digits%3D0")
+		defer db.Close()
+		var text string
+		Expect(db.QueryRow("SELECT v;").Scan(&text)).To(Succeed())
+		Expect(text).To(Equal("{0.1,0.333333333333333,Infinity,-Infinity,NaN,NULL}"))
+	})
+
+	It("formats date and time elements as their type", func() {
+		Expect(query("array(date)", []any{"2024-01-02", nil})).To(Equal("{2024-01-02,NULL}"))
+		Expect(query("array(time(3))", []any{"12:34:56.789"})).To(Equal("{12:34:56.789}"))
+		Expect(query("array(time(3) with time zone)", []any{"12:34:56.789+05:30"})).To(Equal("{12:34:56.789+05:30}"))
+		Expect(query("array(timestamp(3))", []any{"2024-01-02 03:04:05.123"})).To(Equal(`{"2024-01-02 03:04:05.123"}`))
+	})
+
+	It("formats timestamptz elements in the session time zone", func() {
+		fake.On("SELECT v", fakeResult{
+			Columns: []fakeColumn{col("v", "array(timestamp(3) with time zone)")},
+			Rows:    [][]driver.Value{{[]any{"2024-01-02 03:04:05.000 UTC"}}},
+		})
+		db := server.Connect("memory", "options=-c%20TimeZone%3DEurope/Berlin")
+		defer db.Close()
+		var text string
+		Expect(db.QueryRow("SELECT v;").Scan(&text)).To(Succeed())
+		Expect(text).To(Equal(`{"2024-01-02 04:04:05+01"}`))
+	})
+
+	It("serializes arrays of rows as arrays of records", func() {
+		// SELECT ARRAY[ROW(1, 'a'), ROW(2, 'b')]
+		Expect(query("array(row(integer, varchar(1)))", []any{
+			[]any{json.Number("1"), "a"},
+			[]any{json.Number("2"), "b"},
+		})).To(Equal(`{"(1,a)","(2,b)"}`))
+	})
+
+	It("escapes special characters at every nesting level", func() {
+		Expect(query("array(row(x integer, y varchar, z array(varchar)))", []any{
+			[]any{nil, `say "hi", bye`, []any{"a b", nil}},
+		})).To(Equal(`{"(,\"say \"\"hi\"\", bye\",\"{\"\"a b\"\",NULL}\")"}`))
+	})
+
+	It("serializes rows as records and keeps NULL rows NULL", func() {
+		Expect(query("row(a integer, b boolean)", []any{json.Number("7"), true})).To(Equal("(7,t)"))
+
+		fake.On("SELECT v", fakeResult{
+			Columns: []fakeColumn{col("v", "row(a integer)")},
+			Rows:    [][]driver.Value{{nil}},
+		})
+		db := server.Connect("memory")
+		defer db.Close()
+		var text sql.NullString
+		Expect(db.QueryRow("SELECT v;").Scan(&text)).To(Succeed())
+		Expect(text.Valid).To(BeFalse())
+	})
+})
+
+var _ = Describe("Floating point special values", func() {
+	const query = "SELECT nan(), infinity(), -infinity(), real 'Infinity'"
+
+	var server *testServer
+
+	BeforeEach(func() {
+		fake := newFakeTrino()
+		fake.On(query, fakeResult{
+			Columns: []fakeColumn{col("a", "double"), col("b", "double"), col("c", "double"), col("d", "real")},
+			Rows:    [][]driver.Value{{math.NaN(), math.Inf(1), math.Inf(-1), math.Inf(1)}},
+		})
+		server = startServer(fake, &config.Config{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("sends NaN and infinities with their Postgres spellings", func() {
+		client := dialWire(server.addr, "memory")
+		defer client.Close()
+		client.Query(query + ";")
+		client.Send('S')
+
+		var values []string
+		for typ, body := client.Read(); typ != 'Z'; typ, body = client.Read() {
+			if typ != 'D' {
+				continue
+			}
+			for body = body[2:]; len(body) > 0; {
+				n := binary.BigEndian.Uint32(body)
+				values = append(values, string(body[4:4+n]))
+				body = body[4+n:]
+			}
+		}
+		Expect(values).To(Equal([]string{"NaN", "Infinity", "-Infinity", "Infinity"}))
+	})
+
+	It("lets the client parse them as floats", func() {
+		db := server.Connect("memory")
+		defer db.Close()
+
+		var nan, inf, negInf, realInf float64
+		Expect(db.QueryRow(query+";").Scan(&nan, &inf, &negInf, &realInf)).To(Succeed())
+		Expect(math.IsNaN(nan)).To(BeTrue())
+		Expect(math.IsInf(inf, 1)).To(BeTrue())
+		Expect(math.IsInf(negInf, -1)).To(BeTrue())
+		Expect(math.IsInf(realInf, 1)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Double values", func() {
+	const query = "SELECT x FROM doubles"
+
+	doubles := []float64{0.1, 1.0 / 3, -2.5e-300, 123456789.123456789, math.MaxFloat64, math.SmallestNonzeroFloat64}
+	var server *testServer
+
+	BeforeEach(func() {
+		fake := newFakeTrino()
+		rows := make([][]driver.Value, len(doubles))
+		for i, x := range doubles {
+			rows[i] = []driver.Value{x}
+		}
+		fake.On(query, fakeResult{Columns: []fakeColumn{col("x", "double")}, Rows: rows})
+		server = startServer(fake, &config.Config{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	// values returns the text of the doubles sent to db.
+	values := func(db *sql.DB) []string {
+		rows, err := db.Query(query + ";")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		var texts []string
+		for rows.Next() {
+			var text string
+			Expect(rows.Scan(&text)).To(Succeed())
+			texts = append(texts, text)
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		return texts
+	}
+
+	It("sends the shortest text that round-trips exactly", func() {
+		db := server.Connect("memory")
+		defer db.Close()
+
+		texts := values(db)
+		Expect(texts).To(HaveLen(len(doubles)))
+		for i, text := range texts {
+			x, err := strconv.ParseFloat(text, 64)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(x).To(Equal(doubles[i]), text)
+		}
+		Expect(texts[:2]).To(Equal([]string{"0.1", "0.3333333333333333"}))
+	})
+
+	It("lowers the significant digits with extra_float_digits", func() {
+		db := server.Connect("memory")
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+
+		_, err := db.Exec("SET extra_float_digits = -3;")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values(db)[:2]).To(Equal([]string{"0.1", "0.333333333333"}))
+
+		_, err = db.Exec("SET extra_float_digits = 4;")
+		Expect(err).To(MatchError(ContainSubstring(`invalid value for parameter "extra_float_digits"`)))
+		_, err = db.Exec("SET extra_float_digits TO DEFAULT;")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values(db)[1]).To(Equal("0.3333333333333333"))
+	})
+})
+
+var _ = Describe("Time values", func() {
+	var fake *fakeTrino
+
+	BeforeEach(func() {
+		fake = newFakeTrino()
+		value := time.Date(0, 1, 1, 12, 34, 56, 789000000, time.UTC)
+		fake.On("SELECT a, b, c", fakeResult{
+			Columns: []fakeColumn{col("a", "time"), col("b", "time(3)"), col("c", "time(6)")},
+			Rows:    [][]driver.Value{{value, value, value}},
+		})
+	})
+
+	It("reports the precision of time(p) columns as their type modifier", func() {
+		tdb := pgtrino.NewTrinoDBFromDB(fake.DB(), &config.Config{})
+		columns, err := tdb.FetchColumns(context.Background(), "SELECT a, b, c")
+		Expect(err).NotTo(HaveOccurred())
+		var modifiers []int32
+		for _, column := range columns {
+			Expect(column.Oid).To(Equal(oid.T_time))
+			modifiers = append(modifiers, column.TypeModifier)
+		}
+		Expect(modifiers).To(Equal([]int32{-1, 3, 6}))
+	})
+
+	It("sends the precision of time(p) columns to clients", func() {
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		conn, err := pgconn.Connect(context.Background(), server.DSN("memory"))
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(context.Background())
+
+		result := conn.ExecParams(context.Background(), "SELECT a, b, c;", nil, nil, nil, nil).Read()
+		Expect(result.Err).NotTo(HaveOccurred())
+		var modifiers []int32
+		for _, field := range result.FieldDescriptions {
+			Expect(field.TableOID).To(BeZero())
+			modifiers = append(modifiers, field.TypeModifier)
+		}
+		Expect(modifiers).To(Equal([]int32{-1, 3, 6}))
+	})
+
+	It("sends time values to clients reading them in binary", func() {
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		conn, err := pgx.Connect(context.Background(), server.DSN("memory"))
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(context.Background())
+
+		var a, b, c pgtype.Time
+		Expect(conn.QueryRow(context.Background(), "SELECT a, b, c;").Scan(&a, &b, &c)).To(Succeed())
+		for _, t := range []pgtype.Time{a, b, c} {
+			Expect(t).To(Equal(pgtype.Time{Microseconds: (12*3600+34*60+56)*1e6 + 789000, Valid: true}))
+		}
+	})
+
+	It("sends time values as Postgres times", func() {
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		var a, b, c time.Time
+		Expect(db.QueryRow("SELECT a, b, c;").Scan(&a, &b, &c)).To(Succeed())
+		for _, t := range []time.Time{a, b, c} {
+			Expect(t.Format("15:04:05.999999")).To(Equal("12:34:56.789"))
+		}
+	})
+})
+
+var _ = Describe("Decimal values", func() {
+	It("keeps the declared scale of DECIMAL columns", func() {
+		fake := newFakeTrino()
+		fake.On("SELECT a, b, c, d", fakeResult{
+			Columns: []fakeColumn{col("a", "decimal(10,2)"), col("b", "decimal(10,2)"), col("c", "decimal(38,0)"), col("d", "decimal(5,3)")},
+			Rows:    [][]driver.Value{{"1.5", "-7", "12345678901234567890", "0.125"}},
+		})
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		rows, err := db.Query("SELECT a, b, c, d;")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		types, err := rows.ColumnTypes()
+		Expect(err).NotTo(HaveOccurred())
+		for _, t := range types {
+			Expect(t.DatabaseTypeName()).To(Equal("NUMERIC"))
+		}
+		Expect(rows.Next()).To(BeTrue())
+		var a, b, c, d string
+		Expect(rows.Scan(&a, &b, &c, &d)).To(Succeed())
+		Expect([]string{a, b, c, d}).To(Equal([]string{"1.50", "-7.00", "12345678901234567890", "0.125"}))
+	})
+
+	It("sends DECIMAL values to clients reading them in binary", func() {
+		fake := newFakeTrino()
+		fake.On("SELECT a, b", fakeResult{
+			Columns: []fakeColumn{col("a", "decimal(10,2)"), col("b", "decimal(38,0)")},
+			Rows:    [][]driver.Value{{"1.5", "12345678901234567890"}},
+		})
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		conn, err := pgx.Connect(context.Background(), server.DSN("memory"))
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(context.Background())
+
+		var a, b pgtype.Numeric
+		Expect(conn.QueryRow(context.Background(), "SELECT a, b;").Scan(&a, &b)).To(Succeed())
+		for value, text := range map[*pgtype.Numeric]string{&a: "1.50", &b: "12345678901234567890"} {
+			encoded, err := value.Value()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encoded).To(Equal(text))
+		}
+	})
+})
+
+var _ = Describe("Type overrides", func() {
+	It("reports overridden Trino types as the configured Postgres type", func() {
+		fake := newFakeTrino()
+		fake.On("SELECT price, qty FROM orders", fakeResult{
+			Columns: []fakeColumn{col("price", "decimal(10,2)"), col("qty", "bigint")},
+			Rows:    [][]driver.Value{{"1.5", int64(3)}},
+		})
+		server := startServer(fake, &config.Config{TypeOverrides: []string{"decimal=text", "BIGINT=int4"}})
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		rows, err := db.Query("SELECT price, qty FROM orders;")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		types, err := rows.ColumnTypes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(types[0].DatabaseTypeName()).To(Equal("TEXT"))
+		Expect(types[1].DatabaseTypeName()).To(Equal("INT4"))
+		Expect(rows.Next()).To(BeTrue())
+		var (
+			price string
+			qty   int
+		)
+		Expect(rows.Scan(&price, &qty)).To(Succeed())
+		Expect(price).To(Equal("1.50"))
+		Expect(qty).To(Equal(3))
+	})
+
+	It("rejects overrides to unknown Postgres types", func() {
+		_, err := pgtrino.NewTrinoDB(&config.Config{TypeOverrides: []string{"decimal=money"}})
+		Expect(err).To(MatchError(ContainSubstring(`invalid TYPE_OVERRIDES pair "decimal=money"`)))
+	})
+})
+
+var _ = Describe("Integer values", func() {
+	It("sends integers in binary with the byte width of their type", func() {
+		fake := newFakeTrino()
+		fake.On("SELECT a, b, c, d FROM ints", fakeResult{
+			Columns: []fakeColumn{col("a", "tinyint"), col("b", "smallint"), col("c", "integer"), col("d", "bigint")},
+			Rows: [][]driver.Value{
+				{int64(-128), int64(math.MinInt16), int64(math.MinInt32), int64(math.MinInt64)},
+				{int64(127), int64(-2), int64(-70000), int64(1) << 40},
+			},
+		})
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		client := dialWire(server.addr, "memory")
+		defer client.Close()
+
+		client.Send('P', cstring(""), cstring("SELECT a, b, c, d FROM ints;"), []byte{0, 0})
+		// One binary result format code for every column.
+		client.Send('B', cstring(""), cstring(""), []byte{0, 0, 0, 0, 0, 1, 0, 1})
+		client.Send('E', cstring(""), []byte{0, 0, 0, 0})
+		client.Send('S')
+
+		var rows [][]int64
+		for typ, body := client.Read(); typ != 'Z'; typ, body = client.Read() {
+			if typ != 'D' {
+				continue
+			}
+			var row []int64
+			for i, body := 0, body[2:]; len(body) > 0; i++ {
+				n := binary.BigEndian.Uint32(body)
+				Expect(n).To(Equal([]uint32{2, 2, 4, 8}[i]))
+				value := body[4 : 4+n]
+				switch n {
+				case 2:
+					row = append(row, int64(int16(binary.BigEndian.Uint16(value))))
+				case 4:
+					row = append(row, int64(int32(binary.BigEndian.Uint32(value))))
+				case 8:
+					row = append(row, int64(binary.BigEndian.Uint64(value)))
+				}
+				body = body[4+n:]
+			}
+			rows = append(rows, row)
+		}
+		Expect(rows).To(Equal([][]int64{
+			{-128, math.MinInt16, math.MinInt32, math.MinInt64},
+			{127, -2, -70000, 1 << 40},
+		}))
+	})
+
+	It("sends integers as text despite the binary format requested with FORCE_TEXT_FORMAT", func() {
+		fake := newFakeTrino()
+		fake.On("SELECT b FROM ints", fakeResult{
+			Columns: []fakeColumn{col("b", "smallint")},
+			Rows:    [][]driver.Value{{int64(-2)}},
+		})
+		server := startServer(fake, &config.Config{ForceTextFormat: true})
+		defer server.Close()
+		client := dialWire(server.addr, "memory")
+		defer client.Close()
+
+		client.Send('P', cstring(""), cstring("SELECT b FROM ints;"), []byte{0, 0})
+		client.Send('B', cstring(""), cstring(""), []byte{0, 0, 0, 0, 0, 1, 0, 1})
+		client.Send('D', []byte{'P'}, cstring(""))
+		client.Send('E', cstring(""), []byte{0, 0, 0, 0})
+		client.Send('S')
+
+		var values []string
+		for typ, body := client.Read(); typ != 'Z'; typ, body = client.Read() {
+			switch typ {
+			case 'T':
+				// The format code ends the description of the only column.
+				Expect(body[len(body)-2:]).To(Equal([]byte{0, 0}))
+			case 'D':
+				n := binary.BigEndian.Uint32(body[2:])
+				values = append(values, string(body[6:6+n]))
+			}
+		}
+		Expect(values).To(Equal([]string{"-2"}))
+	})
+
+	It("sends boolean values as t and f in the text format", func() {
+		fake := newFakeTrino()
+		fake.On("SELECT active FROM users", fakeResult{
+			Columns: []fakeColumn{col("active", "boolean")},
+			Rows:    [][]driver.Value{{true}, {false}},
+		})
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		client := dialWire(server.addr, "memory")
+		defer client.Close()
+
+		client.Send('Q', cstring("SELECT active FROM users;"))
+		var values []string
+		for typ, body := client.Read(); typ != 'Z'; typ, body = client.Read() {
+			if typ == 'D' {
+				n := binary.BigEndian.Uint32(body[2:])
+				values = append(values, string(body[6:6+n]))
+			}
+		}
+		Expect(values).To(Equal([]string{"t", "f"}))
+	})
+
+	It("reports tinyint and smallint columns as int2", func() {
+		fake := newFakeTrino()
+		fake.On("SELECT a, b FROM ints", fakeResult{
+			Columns: []fakeColumn{col("a", "tinyint"), col("b", "smallint")},
+			Rows:    [][]driver.Value{{int64(1), int64(-2)}},
+		})
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		db := server.Connect("memory")
+		defer db.Close()
+
+		rows, err := db.Query("SELECT a, b FROM ints;")
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		types, err := rows.ColumnTypes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(types[0].DatabaseTypeName()).To(Equal("INT2"))
+		Expect(types[1].DatabaseTypeName()).To(Equal("INT2"))
+		Expect(rows.Next()).To(BeTrue())
+		var a, b int16
+		Expect(rows.Scan(&a, &b)).To(Succeed())
+		Expect([]int16{a, b}).To(Equal([]int16{1, -2}))
+	})
+})
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// version and commit identify the pg2trino build. Release builds set them
+// with the linker:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD)"
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+// buildInfo returns the version and commit of the build, such as
+// pg2trino 1.4.0 (commit 3ab1aec).
+func buildInfo() string {
+	return fmt.Sprintf("pg2trino %s (commit %s)", version, commit)
+}
+
+// versionProbeTimeout bounds the startup query reading the Trino version.
+const versionProbeTimeout = 10 * time.Second
+
+// trinoRelease matches the release number leading a Trino version, such as
+// 435 in 435-e.3.
+var trinoRelease = regexp.MustCompile(`^\s*(\d+)`)
+
+// probeVersion queries the version of the Trino cluster and logs it. It
+// fails if the version is below the configured minimum version.
+func (tdb *TrinoDB) probeVersion(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, versionProbeTimeout)
+	defer cancel()
+	version, err := tdb.queryVersion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to query the Trino version: %w", err)
+	}
+	log.Printf("Connected to Trino version %s", version)
+	minimum := tdb.Config.MinTrinoVersion
+	if minimum <= 0 {
+		return version, nil
+	}
+	match := trinoRelease.FindStringSubmatch(version)
+	if match == nil {
+		return version, fmt.Errorf("unrecognized Trino version %q", version)
+	}
+	if release, err := strconv.Atoi(match[1]); err != nil || release < minimum {
+		return version, fmt.Errorf("the Trino version %s is below the minimum version %d", version, minimum)
+	}
+	return version, nil
+}
+
+// queryVersion returns the version of the Trino cluster, queried with the
+// executor of tdb.
+func (tdb *TrinoDB) queryVersion(ctx context.Context) (string, error) {
+	rows, err := tdb.QueryContext(ctx, "SELECT version()")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var version string
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		return "", sql.ErrNoRows
+	}
+	if err := rows.Scan(&version); err != nil {
+		return "", err
+	}
+	return version, rows.Close()
+}
@@ -0,0 +1,58 @@
+package main_test
+
+import (
+	"context"
+	"database/sql/driver"
+
+	pgtrino "pg2trino"
+	"pg2trino/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Trino version probe", func() {
+	probe := func(version string, minimum int) (string, error) {
+		fake := newFakeTrino()
+		fake.On("SELECT version()", fakeResult{
+			Columns: []fakeColumn{col("_col0", "varchar")},
+			Rows:    [][]driver.Value{{version}},
+		})
+		tdb := pgtrino.NewTrinoDBFromDB(fake.DB(), &config.Config{MinTrinoVersion: minimum})
+		return tdb.ProbeVersion(context.Background())
+	}
+
+	It("returns the cluster version", func() {
+		Expect(probe("435-e.3", 0)).To(Equal("435-e.3"))
+	})
+
+	It("accepts versions at or above the minimum", func() {
+		Expect(probe("435-e.3", 435)).To(Equal("435-e.3"))
+	})
+
+	It("rejects versions below the minimum", func() {
+		_, err := probe("351", 400)
+		Expect(err).To(MatchError("the Trino version 351 is below the minimum version 400"))
+	})
+
+	It("rejects unrecognized versions when a minimum is set", func() {
+		_, err := probe("dev", 400)
+		Expect(err).To(MatchError(ContainSubstring(`unrecognized Trino version "dev"`)))
+	})
+})
+
+var _ = Describe("Build version", func() {
+	It("answers pg2trino_version() with the version and commit of the build", func() {
+		defer pgtrino.SetBuild("1.4.0", "3ab1aec")()
+		fake := newFakeTrino()
+		server := startServer(fake, &config.Config{})
+		defer server.Close()
+		db := server.Connect("hive")
+		defer db.Close()
+
+		var version string
+		Expect(db.QueryRow("SELECT pg2trino_version();").Scan(&version)).To(Succeed())
+		Expect(version).To(Equal("pg2trino 1.4.0 (commit 3ab1aec)"))
+		Expect(fake.Queries()).To(BeEmpty())
+	})
+})